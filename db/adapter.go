@@ -1,13 +1,204 @@
 package adapter
 
 import (
+	"context"
 	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unit-io/unitd/pkg/log"
+	"github.com/unit-io/unitd/pkg/metrics"
 )
 
+// Errors returned by adapters, adapters may wrap them with details of the failure so callers
+// should check them using errors.Is.
 var (
-	errNotFound = errors.New("no messages were found")
+	// ErrNotFound is returned if the message was not found.
+	ErrNotFound = errors.New("no messages were found")
+
+	// ErrInvalidLimit is returned if the query limit is less than one or the query offset is negative.
+	ErrInvalidLimit = errors.New("query limit must be greater than zero")
+
+	// ErrReadOnly is returned on writes to a database opened in read-only mode.
+	ErrReadOnly = errors.New("database is opened in read-only mode")
+
+	// ErrClosed is returned on operations on a database that is not open.
+	ErrClosed = errors.New("database is closed")
+
+	// ErrAlreadyOpen is returned on open of a database that is already open.
+	ErrAlreadyOpen = errors.New("database is already open")
+
+	// ErrVersionMismatch is returned if the database version does not match the adapter version.
+	ErrVersionMismatch = errors.New("database version mismatch")
+
+	// ErrEmptyTopic is returned on operations with an empty topic.
+	ErrEmptyTopic = errors.New("topic must not be empty")
+
+	// ErrEmptyPayload is returned on writes of an empty payload, unless the adapter is
+	// configured to allow empty payloads.
+	ErrEmptyPayload = errors.New("payload must not be empty")
+
+	// ErrPayloadTooLarge is returned on writes of a payload larger than the maximum payload
+	// size the adapter is configured with.
+	ErrPayloadTooLarge = errors.New("payload is too large")
+
+	// ErrTopicTooLong is returned on writes to a topic longer than the maximum topic size
+	// the adapter is configured with.
+	ErrTopicTooLong = errors.New("topic is too long")
+
+	// ErrInvalidMessageID is returned if the messageId does not have the size of messageIds
+	// generated by the adapter.
+	ErrInvalidMessageID = errors.New("invalid messageId")
+
+	// ErrInvalidArgument is returned if an argument is rejected for a reason not covered
+	// by the other errors, such as a contract reserved by the adapter.
+	ErrInvalidArgument = errors.New("invalid argument")
 )
 
+// ErrorClass is the class of an adapter error, servers exposing an adapter map the class
+// to the status code of their protocol, so all servers report an error alike.
+type ErrorClass int
+
+// Error classes
+const (
+	ClassInternal ErrorClass = iota
+	ClassNotFound
+	ClassUnavailable
+	ClassInvalidArgument
+	ClassTooLarge
+	ClassReadOnly
+)
+
+// Classify returns the class of an error returned by an adapter. Errors not returned by
+// adapters are ClassInternal.
+func Classify(err error) ErrorClass {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return ClassNotFound
+	case errors.Is(err, ErrClosed):
+		return ClassUnavailable
+	case errors.Is(err, ErrInvalidLimit), errors.Is(err, ErrEmptyTopic), errors.Is(err, ErrEmptyPayload),
+		errors.Is(err, ErrInvalidMessageID), errors.Is(err, ErrTopicTooLong), errors.Is(err, ErrInvalidArgument):
+		return ClassInvalidArgument
+	case errors.Is(err, ErrPayloadTooLarge):
+		return ClassTooLarge
+	case errors.Is(err, ErrReadOnly):
+		return ClassReadOnly
+	}
+	return ClassInternal
+}
+
+// TopicErrors holds errors of topics that failed in a query of multiple topics, keyed by topic.
+type TopicErrors map[string]error
+
+func (e TopicErrors) Error() string {
+	topics := make([]string, 0, len(e))
+	for topic := range e {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	msgs := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		msgs = append(msgs, "topic "+topic+": "+e[topic].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the topic errors matches target.
+func (e TopicErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// BatchErrors holds errors of entries that failed in a batch write, keyed by index of the entry.
+type BatchErrors map[int]error
+
+func (e BatchErrors) Error() string {
+	entries := make([]int, 0, len(e))
+	for i := range e {
+		entries = append(entries, i)
+	}
+	sort.Ints(entries)
+	msgs := make([]string, 0, len(entries))
+	for _, i := range entries {
+		msgs = append(msgs, "entry "+strconv.Itoa(i)+": "+e[i].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the entry errors matches target.
+func (e BatchErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats represents the database stats.
+type Stats struct {
+	Size          int64     `json:"size"`           // Size of the database files on disk in bytes.
+	Count         uint64    `json:"count"`          // Number of messages stored.
+	Version       int       `json:"version"`        // Database version.
+	LastReconnect time.Time `json:"last_reconnect"` // Time the database was last reopened after a failure, zero if never.
+}
+
+// Message represents a stored message along with its metadata.
+type Message struct {
+	ID        []byte    `json:"id"`        // The messageId of the message.
+	Timestamp time.Time `json:"timestamp"` // The time message was stored.
+	Qos       uint8     `json:"qos"`       // The QoS level of the message.
+	Seq       uint64    `json:"seq"`       // The sequence number of the message, zero unless sequence ID mode is used.
+	Payload   []byte    `json:"payload"`   // The payload of the message.
+}
+
+// Logger is the logger used by the adapter. Each method receives the context of the log
+// entry, such as the adapter operation, and the message.
+type Logger interface {
+	Error(context, msg string)
+	Info(context, msg string)
+	Debug(context, msg string)
+}
+
+// DefaultLogger is the Logger writing to the unitd log.
+type DefaultLogger struct{}
+
+func (DefaultLogger) Error(context, msg string) { log.Error(context, msg) }
+func (DefaultLogger) Info(context, msg string)  { log.Info(context, msg) }
+func (DefaultLogger) Debug(context, msg string) { log.Debug(context, msg) }
+
+// Tracer starts spans of adapter operations. The oteltrace package provides a Tracer
+// recording spans with OpenTelemetry.
+type Tracer interface {
+	// Start starts the span of the operation on the topic, the returned context carries the span.
+	Start(ctx context.Context, name string, contract uint32, topic []byte) (context.Context, Span)
+}
+
+// Span is the span of an adapter operation.
+type Span interface {
+	// End records the result size and the error, if any, and ends the span.
+	End(size int, err error)
+}
+
+// NopTracer is the Tracer starting no spans, it is the default tracer of the adapter.
+type NopTracer struct{}
+
+func (NopTracer) Start(ctx context.Context, name string, contract uint32, topic []byte) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(size int, err error) {}
+
 // Adapter represents a message storage contract that message storage provides
 // must fulfill.
 type Adapter interface {
@@ -17,18 +208,77 @@ type Adapter interface {
 	Open(config string) error
 	// Close the adapter
 	Close() error
+	// CloseContext closes the adapter, it returns the context error if the context is done
+	// before the close completes and the adapter is closed regardless
+	CloseContext(ctx context.Context) error
 	// IsOpen checks if the adapter is ready for use
 	IsOpen() bool
+	// Ping checks if the connection to database is actually live
+	Ping() error
 	// // CheckDbVersion checks if the actual database version matches adapter version.
 	// CheckDbVersion() error
+	// Version returns the on-disk format version of the database, 0 if unknown and -1 if closed
+	Version() int
 	// GetName returns the name of the adapter
 	GetName() string
+	// RegisterMetrics registers the adapter metrics with the metrics registry
+	RegisterMetrics(r metrics.Metrics) error
+	// SetLogger sets the logger used by the adapter, a nil logger restores the default logger
+	SetLogger(l Logger)
+	// SetTracer sets the tracer used by the adapter, a nil tracer restores the no-op tracer
+	SetTracer(t Tracer)
+
+	// OnWrite registers a hook called after each successful write with the message as written.
+	// Hooks are called synchronously in registration order, a panic in a hook is recovered.
+	OnWrite(hook func(contract uint32, topic, messageId, payload []byte))
+
+	// OnExpire registers a hook called for each expired message removed by the adapter. Hooks
+	// are called at most once per message, a panic in a hook is recovered.
+	OnExpire(hook func(contract uint32, topic, messageId []byte))
 
 	// Put is used to store a message, the SSID provided must be a full SSID
 	// SSID, where first element should be a contract ID. The time resolution
 	// for TTL will be in seconds. The function is executed synchronously and
 	// it returns an error if some error was encountered during storage.
-	Put(contract uint32, topic, payload []byte) error
+	// Write options set the messageId, TTL and QoS level of the message.
+	Put(contract uint32, topic, payload []byte, opts ...WriteOption) error
+
+	// PutContext is used to store a message, the write is aborted if the context is done
+	// before the message is written.
+	PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...WriteOption) error
+
+	// PutWithTTL is used to store a message that expires after the ttl duration. The
+	// ttl is capped at the adapter maximum TTL and a zero ttl means the message never expires.
+	PutWithTTL(contract uint32, topic, payload []byte, ttl time.Duration) error
+
+	// PutReturningID is used to store a message and it returns the generated messageId
+	// that can later be used to delete the message.
+	PutReturningID(contract uint32, topic, payload []byte) ([]byte, error)
+
+	// PutAt is used to store a message with the timestamp, such as the original event time of a
+	// backfilled message, and it returns the generated messageId. Time range queries and ordering
+	// use the timestamp instead of the time message was stored.
+	PutAt(contract uint32, topic, payload []byte, ts time.Time) ([]byte, error)
+
+	// PutIfAbsent is used to store a message using the messageId unless a message with the messageId
+	// is already stored for the topic, it returns false if the message was already stored.
+	PutIfAbsent(contract uint32, topic, messageId, payload []byte) (bool, error)
+
+	// CompareAndSwap is used to store a message if the messageId of the most recent message of the
+	// topic equals expectedId, a nil expectedId means the topic has no messages. It returns the
+	// generated messageId and false if the precondition failed.
+	CompareAndSwap(contract uint32, topic, expectedId, payload []byte) ([]byte, bool, error)
+
+	// Increment adds delta to the counter of the topic and returns the new value, concurrent
+	// increments of the same counter do not lose updates.
+	Increment(contract uint32, topic []byte, delta int64) (int64, error)
+
+	// Truncate removes all messages of the database keeping the database open.
+	Truncate() error
+
+	// PutWithQoS is used to store a message along with its QoS level. QoS levels are
+	// 0, 1 and 2 as in MQTT and messages stored without QoS have QoS level 0.
+	PutWithQoS(contract uint32, topic, payload []byte, qos uint8) error
 
 	// PutWithID is used to store a message using a pre generated ID, the SSID provided must be a full SSID
 	// SSID, where first element should be a contract ID. The time resolution
@@ -36,10 +286,116 @@ type Adapter interface {
 	// it returns an error if some error was encountered during storage.
 	PutWithID(contract uint32, messageId, topic, payload []byte) error
 
+	// BatchPut is used to store multiple messages for the topic in a single batch. The batch
+	// is atomic, entries that fail are reported in BatchErrors and no messages are stored.
+	BatchPut(contract uint32, topic []byte, payloads [][]byte) error
+
+	// BatchPutResult is used to store multiple messages for the topic in a single batch and it
+	// returns the messageIds of the messages by index of the payload. Entries that fail are
+	// reported in BatchErrors and have a nil messageId. If atomic is set no messages are stored
+	// if any entry fails, otherwise the other entries are stored.
+	BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error)
+
 	// Get performs a query and attempts to fetch last n messages where
-	// n is specified by limit argument. From and until times can also be specified
-	// for time-series retrieval.
-	Get(contract uint32, topic []byte) ([][]byte, error)
+	// n is specified by limit argument. The limit is capped at the adapter maximum results
+	// and ErrInvalidLimit is returned if the limit is less than one. The order of messages
+	// is defined by the adapter, use GetOrdered to get messages ordered by time.
+	Get(contract uint32, topic []byte, limit int) ([][]byte, error)
+
+	// GetContext performs a query and attempts to fetch last n messages where
+	// n is specified by limit argument. The query is cancelled if the context is done.
+	GetContext(ctx context.Context, contract uint32, topic []byte, limit int) ([][]byte, error)
+
+	// GetInto performs a query like Get and copies payloads into the buffers of dst reusing
+	// their capacity, it returns the matches as dst resliced.
+	GetInto(contract uint32, topic []byte, limit int, dst [][]byte) ([][]byte, error)
+
+	// GetLast performs a query and attempts to fetch the most recent message for the topic.
+	// It returns false if no messages were found.
+	GetLast(contract uint32, topic []byte) ([]byte, bool, error)
+
+	// GetPage performs a query and attempts to fetch n messages after the cursor where n
+	// is specified by limit argument. A nil cursor fetches the first page. It returns the
+	// cursor to fetch the next page or nil if there are no more messages. It returns
+	// ErrNotFound if the cursor matches no message of the topic.
+	GetPage(contract uint32, topic, cursor []byte, limit int) (matches [][]byte, next []byte, err error)
+
+	// GetOffset performs a query and attempts to fetch n messages after skipping the first offset
+	// messages, where n is specified by limit argument. Large offsets are slow as skipped messages are iterated.
+	GetOffset(contract uint32, topic []byte, offset, limit int) ([][]byte, error)
+
+	// Stream performs a query and sends messages to the returned channel as it iterates.
+	// The channel is closed when iteration completes or the context is done.
+	Stream(ctx context.Context, contract uint32, topic []byte) (<-chan []byte, <-chan error)
+
+	// Watch returns a channel delivering messages as they are written to the topic. The
+	// channel is closed when the context is done or the adapter is closed.
+	Watch(ctx context.Context, contract uint32, topic []byte) (<-chan Message, error)
+
+	// GetMessages performs a query and attempts to fetch last n messages along with the messageId
+	// and the time message was stored, where n is specified by limit argument.
+	GetMessages(contract uint32, topic []byte, limit int) ([]Message, error)
+
+	// GetOrdered performs a query and attempts to fetch n messages ordered by time, where n
+	// is specified by limit argument. If desc is set newest messages are returned first.
+	GetOrdered(contract uint32, topic []byte, limit int, desc bool) ([][]byte, error)
+
+	// GetWildcard performs a query for topics matching the MQTT-style topic pattern, where
+	// '+' matches a single topic level and '#' matches multiple levels, and attempts to
+	// fetch last n messages where n is specified by limit argument.
+	GetWildcard(contract uint32, topicPattern []byte, limit int) ([][]byte, error)
+
+	// GetRange performs a query and attempts to fetch last n messages stored between
+	// from and until times, where n is specified by limit argument. A zero from time
+	// fetches messages from the beginning and a zero until time means now. Messages are ordered
+	// oldest first.
+	GetRange(contract uint32, topic []byte, from, until time.Time, limit int) ([][]byte, error)
+
+	// GetSince performs a query and attempts to fetch first n messages stored after the since
+	// time ordered oldest first, where n is specified by limit argument.
+	GetSince(contract uint32, topic []byte, since time.Time, limit int) ([]Message, error)
+
+	// CountRange returns number of messages of the topic stored between from and until times,
+	// both inclusive. A zero from time counts from the beginning and a zero until time means now.
+	CountRange(contract uint32, topic []byte, from, until time.Time) (uint64, error)
+
+	// ScanPrefix performs a query of each topic starting with the prefix and attempts to fetch
+	// n messages, where n is specified by limit argument. Messages are ordered by topic.
+	ScanPrefix(contract uint32, prefix []byte, limit int) ([]Message, error)
+
+	// GetMulti performs a query for each topic concurrently and attempts to fetch last n messages
+	// of each topic where n is specified by limit argument. Matches are keyed by topic, topics
+	// that failed are reported in a TopicErrors error along with matches of the other topics.
+	GetMulti(contract uint32, topics [][]byte, limit int) (map[string][][]byte, error)
+
+	// Count returns number of messages stored for the topic. It returns zero if
+	// no messages were found for the topic.
+	Count(contract uint32, topic []byte) (uint64, error)
+
+	// Exists checks if a message with the messageId is stored for the topic.
+	// It returns false with no error if the message was not found.
+	Exists(contract uint32, topic, messageId []byte) (bool, error)
+
+	// GetByID performs a query and attempts to fetch the message with the messageId.
+	// It returns false with no error if the message was not found.
+	GetByID(contract uint32, topic, messageId []byte) ([]byte, bool, error)
+
+	// Topics returns distinct topics that have at least one message stored under the contract.
+	// It returns an empty slice if the contract has no messages.
+	Topics(contract uint32) ([][]byte, error)
+
+	// Contracts returns distinct contracts that have at least one message stored. Enumerating
+	// contracts may require a full scan, a limit greater than zero bounds number of contracts returned.
+	Contracts(limit int) ([]uint32, error)
+
+	// NextSeq returns the next sequence number of the topic. Sequence numbers start at one and
+	// are persisted, so they keep increasing across restarts.
+	NextSeq(contract uint32, topic []byte) (uint64, error)
+
+	// Touch is used to reset the TTL of a stored message, the new TTL is capped at the adapter
+	// maximum TTL and a zero ttl means the message never expires. It returns ErrNotFound if the
+	// message was not found.
+	Touch(contract uint32, topic, messageId []byte, ttl time.Duration) error
 
 	// NewID generate messageId that can later used to store and delete message from message store
 	NewID() ([]byte, error)
@@ -49,6 +405,56 @@ type Adapter interface {
 	// it returns an error if some error was encountered during delete.
 	Delete(contract uint32, messageId, topic []byte) error
 
+	// DeleteByTopic is used to delete all messages stored for the topic, it returns
+	// number of messages deleted.
+	DeleteByTopic(contract uint32, topic []byte) (int, error)
+
+	// DropContract is used to delete all messages stored under the contract along with
+	// metadata kept for the contract, it returns number of messages deleted.
+	DropContract(contract uint32) (int, error)
+
+	// PurgeExpired is used to delete messages of the topic whose TTL has elapsed, a nil topic
+	// purges all topics of the contract. It returns number of messages deleted.
+	PurgeExpired(contract uint32, topic []byte) (int, error)
+
+	// BatchDelete is used to delete messages for the messageIds in a single batch.
+	BatchDelete(contract uint32, topic []byte, messageIds [][]byte) error
+
+	// DeleteContext is used to delete entry, the delete is aborted if the context is done
+	// before the message is deleted.
+	DeleteContext(ctx context.Context, contract uint32, messageId, topic []byte) error
+
+	// Sync flushes buffered writes to disk, messages written before Sync are durable once it returns.
+	Sync() error
+
+	// Compact releases space of deleted and expired messages for reuse, it blocks until complete.
+	Compact() error
+
+	// Stats returns the database stats such as size on disk, number of messages and version.
+	Stats() (Stats, error)
+
+	// FileSize returns total size of the database files on disk in bytes.
+	FileSize() (int64, error)
+
+	// Migrate upgrades a database of an older version to the adapter version and stamps the new
+	// version, it is a no-op if the database is up to date. Adapters may migrate on open, so
+	// existing databases keep working without calling Migrate.
+	Migrate() error
+
+	// Backup writes a point-in-time snapshot of the database to w and returns number of bytes written.
+	Backup(w io.Writer) (int64, error)
+
+	// Restore rebuilds the database from a snapshot written by Backup. Restoring into
+	// a non-empty database is rejected unless force is set.
+	Restore(r io.Reader, force bool) error
+
+	// ExportJSON writes all messages stored under the contract to w as newline-delimited JSON.
+	ExportJSON(contract uint32, w io.Writer) error
+
+	// ImportJSON stores messages read from r in the format written by ExportJSON keeping their
+	// messageIds. It returns number of messages imported and malformed lines skipped.
+	ImportJSON(r io.Reader, skipMalformed bool) (imported, skipped int, err error)
+
 	// Append appends message to the buffer.
 	Append(delFlag bool, k uint64, data []byte) error
 
@@ -0,0 +1,33 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{ErrNotFound, ClassNotFound},
+		{ErrClosed, ClassUnavailable},
+		{ErrInvalidLimit, ClassInvalidArgument},
+		{ErrEmptyTopic, ClassInvalidArgument},
+		{ErrEmptyPayload, ClassInvalidArgument},
+		{ErrInvalidMessageID, ClassInvalidArgument},
+		{ErrTopicTooLong, ClassInvalidArgument},
+		{fmt.Errorf("%w: contract is reserved", ErrInvalidArgument), ClassInvalidArgument},
+		{ErrPayloadTooLarge, ClassTooLarge},
+		{ErrReadOnly, ClassReadOnly},
+		{BatchErrors{1: ErrEmptyPayload}, ClassInvalidArgument},
+		{ErrVersionMismatch, ClassInternal},
+		{errors.New("disk failure"), ClassInternal},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.class, Classify(tt.err), tt.err.Error())
+	}
+}
@@ -0,0 +1,166 @@
+package adapter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFlushBytes    = 1 << 20
+	defaultFlushInterval = 100 * time.Millisecond
+	defaultQueueSize     = 1024
+)
+
+// ErrWriterClosed is returned on enqueue to a closed AsyncWriter.
+var ErrWriterClosed = errors.New("async writer is closed")
+
+// AsyncWriterConfig is the configuration of an AsyncWriter.
+type AsyncWriterConfig struct {
+	// FlushBytes is the size of pending payloads that triggers a flush.
+	FlushBytes int
+
+	// FlushInterval is the longest time a message is pending before it is flushed.
+	FlushInterval time.Duration
+
+	// QueueSize is the number of messages queued before Enqueue blocks.
+	QueueSize int
+
+	// OnError is called with the messages of a topic that failed to be written.
+	OnError func(contract uint32, topic []byte, payloads [][]byte, err error)
+}
+
+type asyncMessage struct {
+	contract uint32
+	topic    []byte
+	payload  []byte
+}
+
+type asyncKey struct {
+	contract uint32
+	topic    string
+}
+
+// AsyncWriter queues messages and writes them to the adapter in batches. Pending messages are
+// flushed when their size reaches FlushBytes or FlushInterval elapses, messages of each topic
+// are written using a single BatchPut.
+type AsyncWriter struct {
+	adp    Adapter
+	config AsyncWriterConfig
+
+	msgs    chan asyncMessage
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncWriter returns an AsyncWriter writing messages to adp.
+func NewAsyncWriter(adp Adapter, config AsyncWriterConfig) *AsyncWriter {
+	if config.FlushBytes <= 0 {
+		config.FlushBytes = defaultFlushBytes
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	w := &AsyncWriter{
+		adp:     adp,
+		config:  config,
+		msgs:    make(chan asyncMessage, config.QueueSize),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Enqueue queues the message to be written, it blocks if the queue is full.
+func (w *AsyncWriter) Enqueue(contract uint32, topic, payload []byte) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	w.msgs <- asyncMessage{contract: contract, topic: topic, payload: payload}
+	return nil
+}
+
+// Flush writes the pending messages and waits until they are written.
+func (w *AsyncWriter) Flush() {
+	ack := make(chan struct{})
+	select {
+	case w.flush <- ack:
+		<-ack
+	case <-w.stopped:
+	}
+}
+
+// Close writes the pending messages and stops the writer. The adapter is not closed.
+func (w *AsyncWriter) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	w.mu.Unlock()
+	close(w.done)
+	<-w.stopped
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[asyncKey][][]byte)
+	var order []asyncKey
+	size := 0
+	add := func(m asyncMessage) {
+		k := asyncKey{contract: m.contract, topic: string(m.topic)}
+		if _, ok := pending[k]; !ok {
+			order = append(order, k)
+		}
+		pending[k] = append(pending[k], m.payload)
+		size += len(m.payload)
+	}
+	write := func() {
+		for _, k := range order {
+			if err := w.adp.BatchPut(k.contract, []byte(k.topic), pending[k]); err != nil && w.config.OnError != nil {
+				w.config.OnError(k.contract, []byte(k.topic), pending[k], err)
+			}
+		}
+		pending = make(map[asyncKey][][]byte)
+		order = order[:0]
+		size = 0
+	}
+	drain := func() {
+		for n := len(w.msgs); n > 0; n-- {
+			add(<-w.msgs)
+		}
+		write()
+	}
+	for {
+		select {
+		case m := <-w.msgs:
+			add(m)
+			if size >= w.config.FlushBytes {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		case ack := <-w.flush:
+			drain()
+			close(ack)
+		case <-w.done:
+			drain()
+			return
+		}
+	}
+}
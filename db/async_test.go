@@ -0,0 +1,88 @@
+package adapter
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type batchCall struct {
+	contract uint32
+	topic    string
+	payloads int
+}
+
+// batchAdapter records BatchPut calls.
+type batchAdapter struct {
+	Adapter
+	mu    sync.Mutex
+	err   error
+	calls []batchCall
+}
+
+func (a *batchAdapter) BatchPut(contract uint32, topic []byte, payloads [][]byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, batchCall{contract: contract, topic: string(topic), payloads: len(payloads)})
+	return a.err
+}
+
+func (a *batchAdapter) batches() []batchCall {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]batchCall(nil), a.calls...)
+}
+
+func TestAsyncWriterFlushBytes(t *testing.T) {
+	adp := &batchAdapter{}
+	w := NewAsyncWriter(adp, AsyncWriterConfig{FlushBytes: 30, FlushInterval: time.Hour})
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, w.Enqueue(1, []byte("unit1.async"), []byte("0123456789")))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, adp.batches())
+
+	assert.NoError(t, w.Enqueue(1, []byte("unit1.async"), []byte("0123456789")))
+	assert.Eventually(t, func() bool { return len(adp.batches()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, batchCall{contract: 1, topic: "unit1.async", payloads: 3}, adp.batches()[0])
+}
+
+func TestAsyncWriterFlushInterval(t *testing.T) {
+	adp := &batchAdapter{}
+	w := NewAsyncWriter(adp, AsyncWriterConfig{FlushInterval: 10 * time.Millisecond})
+	defer w.Close()
+
+	assert.NoError(t, w.Enqueue(1, []byte("unit1.async"), []byte("msg")))
+	assert.NoError(t, w.Enqueue(2, []byte("unit1.async"), []byte("msg")))
+	assert.NoError(t, w.Enqueue(1, []byte("unit1.async"), []byte("msg")))
+	assert.Eventually(t, func() bool { return len(adp.batches()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []batchCall{
+		{contract: 1, topic: "unit1.async", payloads: 2},
+		{contract: 2, topic: "unit1.async", payloads: 1},
+	}, adp.batches())
+}
+
+func TestAsyncWriterClose(t *testing.T) {
+	adp := &batchAdapter{err: errors.New("write failed")}
+	var failed int
+	w := NewAsyncWriter(adp, AsyncWriterConfig{FlushInterval: time.Hour, OnError: func(contract uint32, topic []byte, payloads [][]byte, err error) {
+		failed += len(payloads)
+	}})
+
+	assert.NoError(t, w.Enqueue(1, []byte("unit1.async"), []byte("msg")))
+	w.Flush()
+	assert.Len(t, adp.batches(), 1)
+	assert.Equal(t, 1, failed)
+
+	assert.NoError(t, w.Enqueue(1, []byte("unit1.async"), []byte("msg")))
+	w.Close()
+	assert.Len(t, adp.batches(), 2)
+	assert.Equal(t, 2, failed)
+	assert.Equal(t, ErrWriterClosed, w.Enqueue(1, []byte("unit1.async"), []byte("msg")))
+	w.Flush()
+}
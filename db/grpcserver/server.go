@@ -0,0 +1,109 @@
+package grpcserver
+
+import (
+	"context"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Adapter is the subset of the db adapter used by the Store service. Any
+// dbadapter.Adapter satisfies it.
+type Adapter interface {
+	PutWithID(contract uint32, messageId, topic, payload []byte) error
+	PutReturningID(contract uint32, topic, payload []byte) ([]byte, error)
+	Stream(ctx context.Context, contract uint32, topic []byte) (<-chan []byte, <-chan error)
+	Delete(contract uint32, messageId, topic []byte) error
+	NewID() ([]byte, error)
+	Exists(contract uint32, topic, messageId []byte) (bool, error)
+}
+
+// Server implements StoreServer on top of a db adapter.
+type Server struct {
+	adp Adapter
+}
+
+// NewServer returns a Store service backed by adp. Register it with RegisterStoreServer.
+func NewServer(adp Adapter) *Server {
+	return &Server{adp: adp}
+}
+
+// Put stores the payload under the topic. If the request carries no id a new one is generated.
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	if len(req.Id) == 0 {
+		id, err := s.adp.PutReturningID(req.Contract, req.Topic, req.Payload)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return &PutResponse{Id: id}, nil
+	}
+	if err := s.adp.PutWithID(req.Contract, req.Id, req.Topic, req.Payload); err != nil {
+		return nil, toStatus(err)
+	}
+	return &PutResponse{Id: req.Id}, nil
+}
+
+// Get streams the messages stored under the topic. A limit of zero or less streams all messages.
+func (s *Server) Get(req *GetRequest, stream Store_GetServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	msgs, errc := s.adp.Stream(ctx, req.Contract, req.Topic)
+	var sent int32
+	for payload := range msgs {
+		if err := stream.Send(&Message{Payload: payload}); err != nil {
+			return err
+		}
+		sent++
+		if req.Limit > 0 && sent >= req.Limit {
+			return nil
+		}
+	}
+	if err := <-errc; err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// Delete removes a message from the topic.
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*Empty, error) {
+	if err := s.adp.Delete(req.Contract, req.Id, req.Topic); err != nil {
+		return nil, toStatus(err)
+	}
+	return &Empty{}, nil
+}
+
+// GenID generates a new message id.
+func (s *Server) GenID(ctx context.Context, req *Empty) (*GenIDResponse, error) {
+	id, err := s.adp.NewID()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &GenIDResponse{Id: id}, nil
+}
+
+// Exists reports whether a message id is stored under the topic.
+func (s *Server) Exists(ctx context.Context, req *ExistsRequest) (*ExistsResponse, error) {
+	ok, err := s.adp.Exists(req.Contract, req.Topic, req.Id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ExistsResponse{Exists: ok}, nil
+}
+
+// toStatus maps adapter errors to gRPC status codes.
+func toStatus(err error) error {
+	switch dbadapter.Classify(err) {
+	case dbadapter.ClassNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case dbadapter.ClassUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	case dbadapter.ClassInvalidArgument:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case dbadapter.ClassTooLarge:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case dbadapter.ClassReadOnly:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
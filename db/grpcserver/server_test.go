@@ -0,0 +1,182 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	dbadapter "github.com/unit-io/unitd/db"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type memEntry struct {
+	id      string
+	payload []byte
+}
+
+// memAdapter is an in-memory Adapter used to exercise the service.
+type memAdapter struct {
+	mu     sync.Mutex
+	next   int
+	closed bool
+	topics map[string][]memEntry
+}
+
+func newMemAdapter() *memAdapter {
+	return &memAdapter{topics: make(map[string][]memEntry)}
+}
+
+func (m *memAdapter) key(contract uint32, topic []byte) string {
+	return strconv.FormatUint(uint64(contract), 10) + "/" + string(topic)
+}
+
+func (m *memAdapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return dbadapter.ErrClosed
+	}
+	k := m.key(contract, topic)
+	m.topics[k] = append(m.topics[k], memEntry{id: string(messageId), payload: payload})
+	return nil
+}
+
+func (m *memAdapter) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	id, err := m.NewID()
+	if err != nil {
+		return nil, err
+	}
+	return id, m.PutWithID(contract, id, topic, payload)
+}
+
+func (m *memAdapter) Stream(ctx context.Context, contract uint32, topic []byte) (<-chan []byte, <-chan error) {
+	m.mu.Lock()
+	entries := append([]memEntry(nil), m.topics[m.key(contract, topic)]...)
+	m.mu.Unlock()
+	msgs := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(msgs)
+		defer close(errc)
+		for _, e := range entries {
+			select {
+			case msgs <- e.payload:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return msgs, errc
+}
+
+func (m *memAdapter) Delete(contract uint32, messageId, topic []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := m.key(contract, topic)
+	for i, e := range m.topics[k] {
+		if e.id == string(messageId) {
+			m.topics[k] = append(m.topics[k][:i], m.topics[k][i+1:]...)
+			return nil
+		}
+	}
+	return dbadapter.ErrNotFound
+}
+
+func (m *memAdapter) NewID() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, dbadapter.ErrClosed
+	}
+	m.next++
+	return []byte(strconv.Itoa(m.next)), nil
+}
+
+func (m *memAdapter) Exists(contract uint32, topic, messageId []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.topics[m.key(contract, topic)] {
+		if e.id == string(messageId) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestClient(t *testing.T, adp Adapter) (StoreClient, func()) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterStoreServer(s, NewServer(adp))
+	go s.Serve(lis)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStoreClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestStoreServer(t *testing.T) {
+	adp := newMemAdapter()
+	c, stop := newTestClient(t, adp)
+	defer stop()
+	ctx := context.Background()
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+
+	resp, err := c.Put(ctx, &PutRequest{Contract: contract, Topic: topic, Payload: []byte("msg1")})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Id)
+	_, err = c.Put(ctx, &PutRequest{Contract: contract, Topic: topic, Payload: []byte("msg2"), Id: []byte("id2")})
+	assert.NoError(t, err)
+
+	ex, err := c.Exists(ctx, &ExistsRequest{Contract: contract, Topic: topic, Id: resp.Id})
+	assert.NoError(t, err)
+	assert.True(t, ex.Exists)
+
+	stream, err := c.Get(ctx, &GetRequest{Contract: contract, Topic: topic})
+	assert.NoError(t, err)
+	var got []string
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		got = append(got, string(m.Payload))
+	}
+	assert.Equal(t, []string{"msg1", "msg2"}, got)
+
+	stream, err = c.Get(ctx, &GetRequest{Contract: contract, Topic: topic, Limit: 1})
+	assert.NoError(t, err)
+	m, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "msg1", string(m.Payload))
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = c.Delete(ctx, &DeleteRequest{Contract: contract, Topic: topic, Id: []byte("id2")})
+	assert.NoError(t, err)
+	_, err = c.Delete(ctx, &DeleteRequest{Contract: contract, Topic: topic, Id: []byte("id2")})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	id, err := c.GenID(ctx, &Empty{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id.Id)
+
+	adp.closed = true
+	_, err = c.GenID(ctx, &Empty{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,434 @@
+// Package kafkasink mirrors writes to the store into Kafka. The sink does not depend
+// on a Kafka client library, callers provide a Producer wrapping the library of their choice.
+package kafkasink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitd/pkg/log"
+)
+
+var _ dbadapter.Adapter = (*Sink)(nil)
+
+const (
+	// Message headers carrying the store contract, topic and messageId.
+	HeaderContract = "contract"
+	HeaderTopic    = "topic"
+	HeaderID       = "id"
+
+	defaultBufferSize    = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = 100 * time.Millisecond
+	defaultMaxRetries    = 3
+)
+
+var (
+	// ErrBufferFull is reported when a message is dropped because the sink buffer is full.
+	ErrBufferFull = errors.New("kafkasink: buffer is full, message dropped")
+
+	// ErrSinkClosed is returned by writes after the sink is closed, the message is stored
+	// but it is not published.
+	ErrSinkClosed = errors.New("kafkasink: sink is closed, message not published")
+
+	// ErrNotMirrored is returned by writes the sink cannot publish to Kafka, such as
+	// ImportJSON, Restore and Touch. The write is not applied to the adapter.
+	ErrNotMirrored = errors.New("kafkasink: write is not mirrored to Kafka")
+)
+
+// Message is a message published to Kafka.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string][]byte
+}
+
+// Producer publishes batches of messages to Kafka.
+type Producer interface {
+	Produce(msgs []Message) error
+}
+
+// Config is the sink configuration.
+type Config struct {
+	// Topic is the Kafka topic messages are published to.
+	Topic string
+
+	// BufferSize is the number of messages buffered before messages are dropped, messages
+	// waiting to be retried count against the buffer size.
+	BufferSize int
+
+	// BatchSize is the maximum number of messages published in a batch.
+	BatchSize int
+
+	// FlushInterval is the longest time a message is buffered before it is published.
+	FlushInterval time.Duration
+
+	// MaxRetries is the number of times a batch the producer failed to publish is retried,
+	// once each flush interval, before its messages are dropped.
+	MaxRetries int
+
+	// Strict publishes each message before the write returns and fails the write if the
+	// message cannot be published. The message is stored in the store even if the write fails.
+	Strict bool
+
+	// OnError is called when messages are dropped. Errors are logged if it is nil.
+	OnError func(msgs []Message, err error)
+}
+
+// Sink wraps an adapter and publishes each message successfully written to the adapter
+// to Kafka along with its messageId. Unless strict mode is configured messages are published
+// at least once on a best effort basis: batches the producer fails to publish are retried in
+// order, and a message is dropped if it is not published after MaxRetries retries, if the
+// buffer stays full for a flush interval or if the sink is closed before it is published.
+// Drops are reported using OnError and do not fail the write. In strict mode a message is
+// published before the write returns, so a write that succeeds has been published at least
+// once. Writes storing messages the sink cannot publish return ErrNotMirrored.
+type Sink struct {
+	dbadapter.Adapter
+	producer Producer
+	config   Config
+
+	msgs      chan Message
+	flush     chan chan struct{}
+	done      chan struct{}
+	exited    chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a sink publishing writes to adp using producer.
+func New(adp dbadapter.Adapter, producer Producer, config Config) (*Sink, error) {
+	if config.Topic == "" {
+		return nil, errors.New("kafkasink: topic is required")
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultBufferSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaultMaxRetries
+	}
+	s := &Sink{
+		Adapter:  adp,
+		producer: producer,
+		config:   config,
+		msgs:     make(chan Message, config.BufferSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	if !config.Strict {
+		go s.run()
+	}
+	return s, nil
+}
+
+// Put stores the message and publishes it to Kafka.
+func (s *Sink) Put(contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	return s.PutContext(context.Background(), contract, topic, payload, opts...)
+}
+
+// PutContext stores the message and publishes it to Kafka. A messageId is generated by
+// the adapter if it is not set, so the published message carries the messageId.
+func (s *Sink) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	messageId := dbadapter.NewWriteOptions(opts...).ID
+	if messageId == nil {
+		if len(opts) == 0 {
+			id, err := s.Adapter.PutReturningID(contract, topic, payload)
+			if err != nil {
+				return err
+			}
+			return s.publish(s.message(contract, topic, id, payload))
+		}
+		id, err := s.Adapter.NewID()
+		if err != nil {
+			return err
+		}
+		messageId = id
+		opts = append(opts[:len(opts):len(opts)], dbadapter.WithID(messageId))
+	}
+	if err := s.Adapter.PutContext(ctx, contract, topic, payload, opts...); err != nil {
+		return err
+	}
+	return s.publish(s.message(contract, topic, messageId, payload))
+}
+
+// PutWithTTL stores the message with the ttl and publishes it to Kafka.
+func (s *Sink) PutWithTTL(contract uint32, topic, payload []byte, ttl time.Duration) error {
+	return s.Put(contract, topic, payload, dbadapter.WithTTL(ttl))
+}
+
+// PutWithQoS stores the message with the QoS level and publishes it to Kafka.
+func (s *Sink) PutWithQoS(contract uint32, topic, payload []byte, qos uint8) error {
+	return s.Put(contract, topic, payload, dbadapter.WithQoS(qos))
+}
+
+// PutWithID stores the message using the messageId and publishes it to Kafka.
+func (s *Sink) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	return s.Put(contract, topic, payload, dbadapter.WithID(messageId))
+}
+
+// PutReturningID stores the message, publishes it to Kafka and returns the generated messageId.
+func (s *Sink) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	id, err := s.Adapter.PutReturningID(contract, topic, payload)
+	if err != nil {
+		return nil, err
+	}
+	return id, s.publish(s.message(contract, topic, id, payload))
+}
+
+// PutIfAbsent stores the message using the messageId unless it is already stored, a message
+// that is stored is published to Kafka.
+func (s *Sink) PutIfAbsent(contract uint32, topic, messageId, payload []byte) (bool, error) {
+	written, err := s.Adapter.PutIfAbsent(contract, topic, messageId, payload)
+	if err != nil || !written {
+		return written, err
+	}
+	return true, s.publish(s.message(contract, topic, messageId, payload))
+}
+
+// CompareAndSwap stores the message if the precondition holds, a message that is stored is
+// published to Kafka.
+func (s *Sink) CompareAndSwap(contract uint32, topic, expectedId, payload []byte) ([]byte, bool, error) {
+	newId, ok, err := s.Adapter.CompareAndSwap(contract, topic, expectedId, payload)
+	if err != nil || !ok {
+		return newId, ok, err
+	}
+	return newId, true, s.publish(s.message(contract, topic, newId, payload))
+}
+
+// BatchPutResult stores the messages and publishes the messages that were stored to Kafka.
+func (s *Sink) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	ids, err := s.Adapter.BatchPutResult(contract, topic, payloads, atomic)
+	var batchErrs dbadapter.BatchErrors
+	if err != nil && !errors.As(err, &batchErrs) {
+		return ids, err
+	}
+	for i, id := range ids {
+		if id == nil {
+			continue
+		}
+		if perr := s.publish(s.message(contract, topic, id, payloads[i])); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	return ids, err
+}
+
+// PutAt stores the message with the timestamp, publishes it to Kafka and returns the generated messageId.
+func (s *Sink) PutAt(contract uint32, topic, payload []byte, ts time.Time) ([]byte, error) {
+	id, err := s.Adapter.PutAt(contract, topic, payload, ts)
+	if err != nil {
+		return nil, err
+	}
+	return id, s.publish(s.message(contract, topic, id, payload))
+}
+
+// BatchPut stores the messages and publishes them to Kafka.
+func (s *Sink) BatchPut(contract uint32, topic []byte, payloads [][]byte) error {
+	_, err := s.BatchPutResult(contract, topic, payloads, true)
+	return err
+}
+
+// Touch returns ErrNotMirrored, the message with the new TTL cannot be published.
+func (s *Sink) Touch(contract uint32, topic, messageId []byte, ttl time.Duration) error {
+	return ErrNotMirrored
+}
+
+// ImportJSON returns ErrNotMirrored, imported messages cannot be published.
+func (s *Sink) ImportJSON(r io.Reader, skipMalformed bool) (int, int, error) {
+	return 0, 0, ErrNotMirrored
+}
+
+// Restore returns ErrNotMirrored, restored messages cannot be published.
+func (s *Sink) Restore(r io.Reader, force bool) error {
+	return ErrNotMirrored
+}
+
+// Flush publishes the buffered messages and retries the messages waiting to be retried,
+// it waits until the messages are published or fail to publish.
+func (s *Sink) Flush() {
+	if s.config.Strict {
+		return
+	}
+	ack := make(chan struct{})
+	select {
+	case s.flush <- ack:
+		<-ack
+	case <-s.done:
+	}
+}
+
+// Close publishes the buffered messages and closes the adapter, messages that fail to
+// publish are dropped.
+func (s *Sink) Close() error {
+	s.stop()
+	return s.Adapter.Close()
+}
+
+// CloseContext publishes the buffered messages and closes the adapter.
+func (s *Sink) CloseContext(ctx context.Context) error {
+	s.stop()
+	return s.Adapter.CloseContext(ctx)
+}
+
+func (s *Sink) stop() {
+	s.closeOnce.Do(func() {
+		if s.config.Strict {
+			return
+		}
+		s.Flush()
+		close(s.done)
+		<-s.exited
+	})
+}
+
+func (s *Sink) message(contract uint32, topic, id, payload []byte) Message {
+	headers := map[string][]byte{
+		HeaderContract: []byte(strconv.FormatUint(uint64(contract), 10)),
+		HeaderTopic:    topic,
+	}
+	if len(id) > 0 {
+		headers[HeaderID] = id
+	}
+	return Message{Topic: s.config.Topic, Key: topic, Value: payload, Headers: headers}
+}
+
+// publish publishes the message synchronously in strict mode, otherwise it buffers the message.
+// If the buffer is full it waits up to a flush interval for the buffer to drain before the
+// message is dropped. It returns ErrSinkClosed if the sink is closed.
+func (s *Sink) publish(m Message) error {
+	if s.config.Strict {
+		return s.producer.Produce([]Message{m})
+	}
+	select {
+	case <-s.done:
+		return ErrSinkClosed
+	default:
+	}
+	select {
+	case s.msgs <- m:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(s.config.FlushInterval)
+	defer timer.Stop()
+	select {
+	case s.msgs <- m:
+	case <-timer.C:
+		s.failed([]Message{m}, ErrBufferFull)
+	case <-s.done:
+		return ErrSinkClosed
+	}
+	return nil
+}
+
+// retryBatch is a batch waiting to be published, tick is the flush interval the batch last
+// failed to publish in.
+type retryBatch struct {
+	msgs     []Message
+	attempts int
+	tick     int
+	err      error
+}
+
+// run batches buffered messages and publishes them until the sink is closed. Batches the
+// producer fails to publish are retried once each flush interval, batches are published
+// in order, so while a batch waits to be retried new batches are queued behind it.
+func (s *Sink) run() {
+	defer close(s.exited)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]Message, 0, s.config.BatchSize)
+	var queue []retryBatch
+	queued, tick := 0, 0
+	pop := func() {
+		queued -= len(queue[0].msgs)
+		queue[0] = retryBatch{}
+		queue = queue[1:]
+	}
+	// send publishes the queued batches oldest first. It stops at a batch that fails unless
+	// the batch was retried MaxRetries times, the batch is dropped then. A batch that failed
+	// is retried in a later flush interval unless force is set.
+	send := func(force bool) {
+		for len(queue) > 0 {
+			r := &queue[0]
+			if r.attempts > 0 && !force && r.tick == tick {
+				return
+			}
+			if r.err = s.producer.Produce(r.msgs); r.err != nil {
+				if r.attempts < s.config.MaxRetries {
+					r.attempts++
+					r.tick = tick
+					return
+				}
+				s.failed(r.msgs, r.err)
+			}
+			pop()
+		}
+	}
+	produce := func() {
+		if len(batch) == 0 {
+			return
+		}
+		queue = append(queue, retryBatch{msgs: batch})
+		queued += len(batch)
+		batch = make([]Message, 0, s.config.BatchSize)
+		// messages waiting to be retried count against the buffer size
+		for queued > s.config.BufferSize && len(queue) > 1 {
+			s.failed(queue[0].msgs, ErrBufferFull)
+			pop()
+		}
+	}
+	for {
+		select {
+		case m := <-s.msgs:
+			batch = append(batch, m)
+			if len(batch) >= s.config.BatchSize {
+				produce()
+				send(false)
+			}
+		case <-ticker.C:
+			tick++
+			produce()
+			send(false)
+		case ack := <-s.flush:
+			for n := len(s.msgs); n > 0; n-- {
+				batch = append(batch, <-s.msgs)
+				if len(batch) >= s.config.BatchSize {
+					produce()
+				}
+			}
+			produce()
+			send(true)
+			close(ack)
+		case <-s.done:
+			// batches not published by the final flush are dropped
+			for _, r := range queue {
+				if r.err == nil {
+					r.err = ErrSinkClosed
+				}
+				s.failed(r.msgs, r.err)
+			}
+			return
+		}
+	}
+}
+
+func (s *Sink) failed(msgs []Message, err error) {
+	if s.config.OnError != nil {
+		s.config.OnError(msgs, err)
+		return
+	}
+	log.Error("kafkasink.publish", "unable to publish "+strconv.Itoa(len(msgs))+" messages "+err.Error())
+}
@@ -0,0 +1,164 @@
+package kafkasink
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// fakeAdapter implements the writes used by the sink, other methods panic.
+type fakeAdapter struct {
+	dbadapter.Adapter
+	err error
+}
+
+func (a *fakeAdapter) NewID() ([]byte, error) {
+	return []byte("newid"), nil
+}
+
+func (a *fakeAdapter) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	return a.err
+}
+
+func (a *fakeAdapter) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	return []byte("id"), a.err
+}
+
+func (a *fakeAdapter) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	ids := make([][]byte, len(payloads))
+	for i := range payloads {
+		ids[i] = []byte("batchid")
+	}
+	return ids, nil
+}
+
+func (a *fakeAdapter) Close() error { return nil }
+
+type fakeProducer struct {
+	mu      sync.Mutex
+	err     error
+	batches [][]Message
+}
+
+func (p *fakeProducer) Produce(msgs []Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.batches = append(p.batches, append([]Message(nil), msgs...))
+	return nil
+}
+
+func (p *fakeProducer) messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var msgs []Message
+	for _, b := range p.batches {
+		msgs = append(msgs, b...)
+	}
+	return msgs
+}
+
+func TestSink(t *testing.T) {
+	p := &fakeProducer{}
+	s, err := New(&fakeAdapter{}, p, Config{Topic: "store", BatchSize: 2})
+	assert.NoError(t, err)
+
+	topic := []byte("unit1.kafka")
+	assert.NoError(t, s.Put(3376684800, topic, []byte("msg1")))
+	_, err = s.PutReturningID(3376684800, topic, []byte("msg2"))
+	assert.NoError(t, err)
+	assert.NoError(t, s.BatchPut(3376684800, topic, [][]byte{[]byte("msg3")}))
+	assert.NoError(t, s.Put(3376684800, topic, []byte("msg4"), dbadapter.WithQoS(1)))
+	s.Flush()
+
+	msgs := p.messages()
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "store", msgs[0].Topic)
+	assert.Equal(t, topic, msgs[0].Key)
+	assert.Equal(t, []byte("3376684800"), msgs[0].Headers[HeaderContract])
+	assert.Equal(t, topic, msgs[0].Headers[HeaderTopic])
+	// every published message carries its messageId
+	assert.Equal(t, []byte("id"), msgs[0].Headers[HeaderID])
+	assert.Equal(t, []byte("id"), msgs[1].Headers[HeaderID])
+	assert.Equal(t, []byte("batchid"), msgs[2].Headers[HeaderID])
+	assert.Equal(t, []byte("newid"), msgs[3].Headers[HeaderID])
+	assert.NoError(t, s.Close())
+
+	// writes after close are stored but not published
+	assert.Equal(t, ErrSinkClosed, s.Put(3376684800, topic, []byte("msg5")))
+	assert.Len(t, p.messages(), 4)
+}
+
+func TestSinkErrors(t *testing.T) {
+	p := &fakeProducer{err: errors.New("broker unavailable")}
+	var mu sync.Mutex
+	var failed int
+	s, err := New(&fakeAdapter{}, p, Config{Topic: "store", FlushInterval: time.Hour, MaxRetries: 1, OnError: func(msgs []Message, err error) {
+		mu.Lock()
+		failed += len(msgs)
+		mu.Unlock()
+	}})
+	assert.NoError(t, err)
+	failures := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed
+	}
+	// Best effort, publish errors do not fail the write and failed batches are retried.
+	assert.NoError(t, s.Put(3376684800, []byte("unit1.kafka"), []byte("msg1")))
+	s.Flush()
+	assert.Equal(t, 0, failures())
+	p.mu.Lock()
+	p.err = nil
+	p.mu.Unlock()
+	s.Flush()
+	assert.Len(t, p.messages(), 1)
+	assert.Equal(t, 0, failures())
+
+	// a batch is dropped once it is retried MaxRetries times
+	p.mu.Lock()
+	p.err = errors.New("broker unavailable")
+	p.mu.Unlock()
+	assert.NoError(t, s.Put(3376684800, []byte("unit1.kafka"), []byte("msg2")))
+	s.Flush()
+	s.Flush()
+	assert.Equal(t, 1, failures())
+
+	// batches not published when the sink is closed are dropped
+	assert.NoError(t, s.Put(3376684800, []byte("unit1.kafka"), []byte("msg3")))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, 2, failures())
+	p.mu.Lock()
+	p.err = nil
+	p.mu.Unlock()
+
+	// writes the sink cannot publish are rejected
+	assert.Equal(t, ErrNotMirrored, s.Touch(3376684800, []byte("unit1.kafka"), []byte("id"), time.Minute))
+	_, _, err = s.ImportJSON(strings.NewReader(""), false)
+	assert.Equal(t, ErrNotMirrored, err)
+	assert.Equal(t, ErrNotMirrored, s.Restore(strings.NewReader(""), true))
+
+	p.err = errors.New("broker unavailable")
+	strict, err := New(&fakeAdapter{}, p, Config{Topic: "store", Strict: true})
+	assert.NoError(t, err)
+	assert.Error(t, strict.Put(3376684800, []byte("unit1.kafka"), []byte("msg")))
+
+	// Messages are not published if the write fails.
+	p.err = nil
+	storeErr := errors.New("store failed")
+	strict, err = New(&fakeAdapter{err: storeErr}, p, Config{Topic: "store", Strict: true})
+	assert.NoError(t, err)
+	assert.Equal(t, storeErr, strict.Put(3376684800, []byte("unit1.kafka"), []byte("msg")))
+	assert.Len(t, p.messages(), 1)
+}
@@ -0,0 +1,192 @@
+// Package mqttbridge persists messages received from an MQTT broker into the store.
+// The bridge does not depend on an MQTT client library, callers provide a Client
+// wrapping the library of their choice.
+package mqttbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+const (
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = time.Minute
+)
+
+// Client is the MQTT client used by the bridge.
+type Client interface {
+	// Connect connects to the broker. onLost is called when an established
+	// connection to the broker is lost.
+	Connect(onLost func(err error)) error
+
+	// Subscribe subscribes to the topic filter with the qos, handler is called
+	// for each message received with the qos the message was delivered with.
+	Subscribe(filter string, qos byte, handler func(topic string, qos byte, payload []byte)) error
+
+	// Disconnect disconnects from the broker.
+	Disconnect()
+}
+
+// Store is the subset of the db adapter used by the bridge. Any dbadapter.Adapter satisfies it.
+type Store interface {
+	Put(contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error
+}
+
+// Config is the bridge configuration.
+type Config struct {
+	// Contracts maps MQTT topic prefixes to contracts. The bridge subscribes to all
+	// topics under each prefix and messages are stored using the contract of the
+	// longest matching prefix, prefixes match whole topic levels. Messages are stored
+	// under the MQTT topic with '/' level separators replaced by '.'.
+	Contracts map[string]uint32
+
+	// QoS is the qos used to subscribe to the broker. Messages are stored with the qos
+	// they were delivered with, which the broker caps at the subscription qos.
+	QoS byte
+
+	// ReconnectDelay is the initial delay before reconnecting to the broker after the
+	// connection is lost, it is doubled after each failed attempt up to MaxReconnectDelay.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+
+	// OnError is called when a received message cannot be stored. Errors are logged if it is nil.
+	OnError func(topic string, err error)
+
+	// Logger is the logger of the bridge, the unitd log is used if it is nil.
+	Logger dbadapter.Logger
+}
+
+// Bridge subscribes to an MQTT broker and writes received messages into the store.
+type Bridge struct {
+	client Client
+	store  Store
+	config Config
+	lost   chan error
+}
+
+// New returns a bridge writing messages received by client into store.
+func New(client Client, store Store, config Config) (*Bridge, error) {
+	if len(config.Contracts) == 0 {
+		return nil, errors.New("mqttbridge: no topic prefixes configured")
+	}
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("mqttbridge: invalid qos %d", config.QoS)
+	}
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = defaultReconnectDelay
+	}
+	if config.MaxReconnectDelay < config.ReconnectDelay {
+		config.MaxReconnectDelay = defaultMaxReconnectDelay
+		if config.MaxReconnectDelay < config.ReconnectDelay {
+			config.MaxReconnectDelay = config.ReconnectDelay
+		}
+	}
+	if config.Logger == nil {
+		config.Logger = dbadapter.DefaultLogger{}
+	}
+	return &Bridge{client: client, store: store, config: config, lost: make(chan error, 1)}, nil
+}
+
+// Run connects to the broker and persists received messages until the context is done.
+// The bridge reconnects and subscribes again whenever the connection to the broker is lost.
+func (b *Bridge) Run(ctx context.Context) error {
+	delay := b.config.ReconnectDelay
+	for {
+		err := b.connect()
+		if err == nil {
+			delay = b.config.ReconnectDelay
+			select {
+			case <-ctx.Done():
+				b.client.Disconnect()
+				return ctx.Err()
+			case err = <-b.lost:
+			}
+		}
+		b.config.Logger.Error("mqttbridge.Run", "connection to broker failed "+err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > b.config.MaxReconnectDelay {
+			delay = b.config.MaxReconnectDelay
+		}
+	}
+}
+
+func (b *Bridge) connect() error {
+	// Drop a loss reported by a previous connection.
+	select {
+	case <-b.lost:
+	default:
+	}
+	var once sync.Once
+	onLost := func(err error) {
+		once.Do(func() {
+			if err == nil {
+				err = errors.New("connection lost")
+			}
+			b.lost <- err
+		})
+	}
+	if err := b.client.Connect(onLost); err != nil {
+		return err
+	}
+	for prefix := range b.config.Contracts {
+		if err := b.client.Subscribe(filter(prefix), b.config.QoS, b.handle); err != nil {
+			b.client.Disconnect()
+			return err
+		}
+	}
+	return nil
+}
+
+// handle stores a received message with its delivered qos using the contract of the longest
+// matching prefix. MQTT topic levels are separated by '/' and store topic levels by '.', so
+// the topic is converted before it is stored.
+func (b *Bridge) handle(topic string, qos byte, payload []byte) {
+	contract, ok := b.contract(topic)
+	if !ok {
+		return
+	}
+	if err := b.store.Put(contract, []byte(strings.ReplaceAll(topic, "/", ".")), payload, dbadapter.WithQoS(qos)); err != nil {
+		if b.config.OnError != nil {
+			b.config.OnError(topic, err)
+			return
+		}
+		b.config.Logger.Error("mqttbridge.handle", "unable to store message on topic "+topic+" "+err.Error())
+	}
+}
+
+// contract returns the contract of the longest prefix matching the topic. A prefix matches
+// whole topic levels, so prefix "a/b" matches topics "a/b" and "a/b/c" but not "a/bc".
+func (b *Bridge) contract(topic string) (uint32, bool) {
+	var match string
+	var contract uint32
+	var ok bool
+	for prefix, c := range b.config.Contracts {
+		p := strings.TrimSuffix(prefix, "/")
+		if p != "" && topic != p && !strings.HasPrefix(topic, p+"/") {
+			continue
+		}
+		if !ok || len(p) > len(match) {
+			match, contract, ok = p, c, true
+		}
+	}
+	return contract, ok
+}
+
+// filter returns the MQTT topic filter matching all topics under the prefix.
+func filter(prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return "#"
+	}
+	return prefix + "/#"
+}
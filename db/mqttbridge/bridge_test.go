@@ -0,0 +1,133 @@
+package mqttbridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+type fakeClient struct {
+	mu       sync.Mutex
+	connects int
+	onLost   func(error)
+	handlers map[string]func(string, byte, []byte)
+	qos      byte
+}
+
+func (c *fakeClient) Connect(onLost func(error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connects++
+	c.onLost = onLost
+	c.handlers = make(map[string]func(string, byte, []byte))
+	return nil
+}
+
+func (c *fakeClient) Subscribe(filter string, qos byte, handler func(string, byte, []byte)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.qos = qos
+	c.handlers[filter] = handler
+	return nil
+}
+
+func (c *fakeClient) Disconnect() {}
+
+func (c *fakeClient) publish(filter, topic string, qos byte, payload []byte) {
+	c.mu.Lock()
+	h := c.handlers[filter]
+	c.mu.Unlock()
+	h(topic, qos, payload)
+}
+
+func (c *fakeClient) drop() {
+	c.mu.Lock()
+	onLost := c.onLost
+	c.mu.Unlock()
+	onLost(errors.New("broker went away"))
+}
+
+func (c *fakeClient) connected() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connects
+}
+
+type put struct {
+	contract uint32
+	topic    string
+	qos      uint8
+}
+
+type fakeStore struct {
+	mu   sync.Mutex
+	puts []put
+}
+
+func (s *fakeStore) Put(contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	o := dbadapter.NewWriteOptions(opts...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts = append(s.puts, put{contract: contract, topic: string(topic), qos: o.QoS})
+	return nil
+}
+
+func TestBridge(t *testing.T) {
+	client := &fakeClient{}
+	store := &fakeStore{}
+	b, err := New(client, store, Config{
+		Contracts:      map[string]uint32{"sensors": 1, "sensors/kitchen": 2, "sensors/kitchenette": 3},
+		QoS:            1,
+		ReconnectDelay: time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+
+	waitFor(t, func() bool { return client.connected() == 1 })
+	assert.Equal(t, byte(1), client.qos)
+	client.publish("sensors/#", "sensors/garden/temp", 1, []byte("20"))
+	client.publish("sensors/kitchen/#", "sensors/kitchen/temp", 1, []byte("22"))
+	client.publish("sensors/kitchen/#", "sensors/kitchen", 1, []byte("23"))
+	// messages published with a lower qos are stored with the qos they were delivered with
+	client.publish("sensors/#", "sensors/kitchenette/temp", 0, []byte("24"))
+
+	client.drop()
+	waitFor(t, func() bool { return client.connected() == 2 })
+	client.publish("sensors/#", "sensors/garden/temp", 1, []byte("21"))
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	assert.Equal(t, []put{
+		{contract: 1, topic: "sensors.garden.temp", qos: 1},
+		{contract: 2, topic: "sensors.kitchen.temp", qos: 1},
+		{contract: 2, topic: "sensors.kitchen", qos: 1},
+		{contract: 3, topic: "sensors.kitchenette.temp", qos: 0},
+		{contract: 1, topic: "sensors.garden.temp", qos: 1},
+	}, store.puts)
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	_, err := New(&fakeClient{}, &fakeStore{}, Config{})
+	assert.Error(t, err)
+	_, err = New(&fakeClient{}, &fakeStore{}, Config{Contracts: map[string]uint32{"a": 1}, QoS: 3})
+	assert.Error(t, err)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+package adapter
+
+import "time"
+
+// WriteOptions represents options of a message write.
+type WriteOptions struct {
+	ID        []byte        // The messageId of the message, a messageId is generated if it is nil.
+	TTL       time.Duration // The duration after which the message expires, zero means the message never expires.
+	HasTTL    bool          // Whether the TTL was set, messages without TTL get the adapter default TTL if any.
+	QoS       uint8         // The QoS level of the message.
+	Timestamp time.Time     // The time of the message, zero means the time the message is stored.
+}
+
+// WriteOption sets an option of a message write.
+type WriteOption func(*WriteOptions)
+
+// NewWriteOptions returns the write options with opts applied.
+func NewWriteOptions(opts ...WriteOption) WriteOptions {
+	var o WriteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithID sets a pre generated messageId of the message.
+func WithID(id []byte) WriteOption {
+	return func(o *WriteOptions) {
+		o.ID = id
+	}
+}
+
+// WithTTL sets the duration after which the message expires, it overrides the adapter
+// default TTL. A zero ttl means the message never expires.
+func WithTTL(ttl time.Duration) WriteOption {
+	return func(o *WriteOptions) {
+		o.TTL = ttl
+		o.HasTTL = true
+	}
+}
+
+// WithTimestamp sets the time of the message, such as the original event time of a
+// backfilled message, instead of the time the message is stored.
+func WithTimestamp(ts time.Time) WriteOption {
+	return func(o *WriteOptions) {
+		o.Timestamp = ts
+	}
+}
+
+// WithQoS sets the QoS level of the message.
+func WithQoS(qos uint8) WriteOption {
+	return func(o *WriteOptions) {
+		o.QoS = qos
+	}
+}
@@ -0,0 +1,46 @@
+// Package oteltrace records spans of adapter operations with OpenTelemetry. Set the
+// Tracer on the adapter with SetTracer, so the adapter itself does not depend on
+// OpenTelemetry.
+package oteltrace
+
+import (
+	"context"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/unit-io/unitd/db/oteltrace"
+
+var _ dbadapter.Tracer = Tracer{}
+
+// Tracer is the dbadapter.Tracer starting OpenTelemetry spans using the tracer provider of
+// the span in the context. If the context has no span then no span is recorded.
+type Tracer struct{}
+
+// Start starts the span of the adapter operation with the contract and the topic length
+// as attributes.
+func (Tracer) Start(ctx context.Context, name string, contract uint32, topic []byte) (context.Context, dbadapter.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.Int64("unitdb.contract", int64(contract)),
+		attribute.Int("unitdb.topic_length", len(topic)),
+	))
+	return ctx, otelSpan{span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+// End records the result size and error, if any, and ends the span.
+func (s otelSpan) End(size int, err error) {
+	s.span.SetAttributes(attribute.Int("unitdb.result_size", size))
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,54 @@
+package oteltrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	defer parent.End()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.spans")
+	_, span := Tracer{}.Start(ctx, "unitdb.Get", contract, topic)
+	span.End(1, nil)
+	_, span = Tracer{}.Start(ctx, "unitdb.Delete", contract, topic)
+	span.End(0, errors.New("not found"))
+
+	attrs := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	spans := sr.Ended()
+	assert.Len(t, spans, 2)
+	for i, name := range []string{"unitdb.Get", "unitdb.Delete"} {
+		assert.Equal(t, name, spans[i].Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[i].Parent().SpanID())
+		assert.Equal(t, int64(contract), attrs(spans[i])["unitdb.contract"].AsInt64())
+		assert.Equal(t, int64(len(topic)), attrs(spans[i])["unitdb.topic_length"].AsInt64())
+	}
+	assert.Equal(t, int64(1), attrs(spans[0])["unitdb.result_size"].AsInt64())
+
+	// errors are recorded on the span
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Len(t, spans[1].Events(), 1)
+
+	// without a span in the context no spans are recorded
+	_, span = Tracer{}.Start(context.Background(), "unitdb.Put", contract, topic)
+	span.End(3, nil)
+	assert.Len(t, sr.Ended(), 2)
+}
@@ -0,0 +1,467 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ Adapter = (*ReplicatedAdapter)(nil)
+
+// ReplicationMode sets how writes are applied to secondary adapters.
+type ReplicationMode int
+
+const (
+	// ReplicateSync applies writes to secondaries before the write returns and fails the
+	// write if any secondary fails.
+	ReplicateSync ReplicationMode = iota
+
+	// ReplicateAsync applies writes to secondaries in background, failures are logged.
+	ReplicateAsync
+)
+
+// ErrReplication is returned by a synchronously replicated write if a secondary failed to apply
+// the write. The write is applied to the primary and the secondaries that did not fail.
+var ErrReplication = errors.New("replication to secondary failed")
+
+// ReplicatedAdapter wraps a primary adapter and mirrors writes to one or more secondary
+// adapters. Writes are applied to the primary first and then to the secondaries, a write
+// that fails on the primary is not replicated. Reads and other operations go to the primary.
+// In async mode each secondary has a queue, so writes are applied to a secondary in the
+// order they were applied to the primary.
+type ReplicatedAdapter struct {
+	Adapter
+	secondaries []Adapter
+	queues      []*replicaQueue
+	mode        ReplicationMode
+	logger      Logger
+	pending     sync.WaitGroup
+	failures    uint64
+
+	// gate is held for reading while writes are replicated and for writing while pending
+	// replication is waited for, so no write is queued while Wait is called.
+	gate   sync.RWMutex
+	closed bool
+}
+
+// replicaQueue applies queued writes to a secondary in background one at a time in the
+// order they were queued. A goroutine drains the queue while it is not empty.
+type replicaQueue struct {
+	mu      sync.Mutex
+	writes  []func()
+	running bool
+}
+
+func (q *replicaQueue) push(write func()) {
+	q.mu.Lock()
+	q.writes = append(q.writes, write)
+	if q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.mu.Unlock()
+	go q.run()
+}
+
+func (q *replicaQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.writes) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		write := q.writes[0]
+		q.writes[0] = nil
+		q.writes = q.writes[1:]
+		q.mu.Unlock()
+		write()
+	}
+}
+
+// NewReplicatedAdapter returns an adapter replicating writes to primary onto secondaries using mode.
+func NewReplicatedAdapter(mode ReplicationMode, primary Adapter, secondaries ...Adapter) *ReplicatedAdapter {
+	r := &ReplicatedAdapter{
+		Adapter:     primary,
+		secondaries: secondaries,
+		mode:        mode,
+		logger:      DefaultLogger{},
+	}
+	for range secondaries {
+		r.queues = append(r.queues, &replicaQueue{})
+	}
+	return r
+}
+
+// Open opens the primary and the secondaries. The config is a JSON array holding the config
+// of the primary followed by the config of each secondary.
+func (r *ReplicatedAdapter) Open(config string) error {
+	var configs []json.RawMessage
+	if err := json.Unmarshal([]byte(config), &configs); err != nil {
+		return errors.New("replicated adapter failed to parse config: " + err.Error())
+	}
+	if len(configs) != len(r.secondaries)+1 {
+		return fmt.Errorf("replicated adapter has %d secondaries but %d secondary configs", len(r.secondaries), len(configs)-1)
+	}
+	r.gate.Lock()
+	defer r.gate.Unlock()
+	if err := r.Adapter.Open(string(configs[0])); err != nil {
+		return err
+	}
+	for i, s := range r.secondaries {
+		if err := s.Open(string(configs[i+1])); err != nil {
+			for _, s := range r.secondaries[:i] {
+				s.Close()
+			}
+			r.Adapter.Close()
+			return fmt.Errorf("replicated adapter failed to open secondary %d: %w", i, err)
+		}
+	}
+	r.closed = false
+	return nil
+}
+
+// SetLogger sets the logger of the primary and the logger used to report replication failures.
+func (r *ReplicatedAdapter) SetLogger(l Logger) {
+	r.Adapter.SetLogger(l)
+	if l == nil {
+		l = DefaultLogger{}
+	}
+	r.logger = l
+}
+
+// Failures returns number of writes that failed on a secondary. A secondary that failed a
+// write has diverged from the primary and should be resynced, for example using Backup and Restore.
+func (r *ReplicatedAdapter) Failures() uint64 {
+	return atomic.LoadUint64(&r.failures)
+}
+
+// Put stores the message on the primary and the secondaries. A messageId is generated by
+// the primary if it is not set, so the message has the same messageId on all adapters.
+func (r *ReplicatedAdapter) Put(contract uint32, topic, payload []byte, opts ...WriteOption) error {
+	return r.PutContext(context.Background(), contract, topic, payload, opts...)
+}
+
+// PutContext stores the message on the primary and the secondaries.
+func (r *ReplicatedAdapter) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...WriteOption) error {
+	_, err := r.put(ctx, contract, topic, payload, opts)
+	return err
+}
+
+// PutWithTTL stores the message that expires after the ttl on the primary and the secondaries.
+func (r *ReplicatedAdapter) PutWithTTL(contract uint32, topic, payload []byte, ttl time.Duration) error {
+	return r.Put(contract, topic, payload, WithTTL(ttl))
+}
+
+// PutReturningID stores the message on the primary and the secondaries and returns its messageId.
+func (r *ReplicatedAdapter) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	return r.put(context.Background(), contract, topic, payload, nil)
+}
+
+// PutAt stores the message with the timestamp on the primary and the secondaries and returns its messageId.
+func (r *ReplicatedAdapter) PutAt(contract uint32, topic, payload []byte, ts time.Time) ([]byte, error) {
+	return r.put(context.Background(), contract, topic, payload, []WriteOption{WithTimestamp(ts)})
+}
+
+// PutWithQoS stores the message with the QoS level on the primary and the secondaries.
+func (r *ReplicatedAdapter) PutWithQoS(contract uint32, topic, payload []byte, qos uint8) error {
+	return r.Put(contract, topic, payload, WithQoS(qos))
+}
+
+// PutWithID stores the message using the messageId on the primary and the secondaries.
+func (r *ReplicatedAdapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	return r.Put(contract, topic, payload, WithID(messageId))
+}
+
+// PutIfAbsent stores the message using the messageId on the primary unless it is already
+// stored, a message written to the primary is stored on the secondaries.
+func (r *ReplicatedAdapter) PutIfAbsent(contract uint32, topic, messageId, payload []byte) (bool, error) {
+	written, err := r.Adapter.PutIfAbsent(contract, topic, messageId, payload)
+	if err != nil || !written {
+		return written, err
+	}
+	return true, r.replicate("PutIfAbsent", func(s Adapter) error {
+		return s.PutContext(context.Background(), contract, topic, payload, WithID(messageId))
+	})
+}
+
+// CompareAndSwap stores the message on the primary if the precondition holds on the primary,
+// a message written to the primary is stored on the secondaries using the same messageId.
+func (r *ReplicatedAdapter) CompareAndSwap(contract uint32, topic, expectedId, payload []byte) ([]byte, bool, error) {
+	newId, ok, err := r.Adapter.CompareAndSwap(contract, topic, expectedId, payload)
+	if err != nil || !ok {
+		return newId, ok, err
+	}
+	return newId, true, r.replicate("CompareAndSwap", func(s Adapter) error {
+		return s.PutContext(context.Background(), contract, topic, payload, WithID(newId))
+	})
+}
+
+func (r *ReplicatedAdapter) put(ctx context.Context, contract uint32, topic, payload []byte, opts []WriteOption) ([]byte, error) {
+	messageId := NewWriteOptions(opts...).ID
+	if messageId == nil {
+		id, err := r.Adapter.NewID()
+		if err != nil {
+			return nil, err
+		}
+		messageId = id
+		opts = append(opts[:len(opts):len(opts)], WithID(messageId))
+	}
+	if err := r.Adapter.PutContext(ctx, contract, topic, payload, opts...); err != nil {
+		return nil, err
+	}
+	return messageId, r.replicate("Put", func(s Adapter) error {
+		return s.PutContext(context.Background(), contract, topic, payload, opts...)
+	})
+}
+
+// Increment adds delta to the counter of the topic on the primary and the secondaries, it
+// returns the new value of the counter on the primary.
+func (r *ReplicatedAdapter) Increment(contract uint32, topic []byte, delta int64) (int64, error) {
+	value, err := r.Adapter.Increment(contract, topic, delta)
+	if err != nil {
+		return value, err
+	}
+	return value, r.replicate("Increment", func(s Adapter) error {
+		_, err := s.Increment(contract, topic, delta)
+		return err
+	})
+}
+
+// Touch resets the TTL of the message on the primary and the secondaries.
+func (r *ReplicatedAdapter) Touch(contract uint32, topic, messageId []byte, ttl time.Duration) error {
+	if err := r.Adapter.Touch(contract, topic, messageId, ttl); err != nil {
+		return err
+	}
+	return r.replicate("Touch", func(s Adapter) error {
+		return s.Touch(contract, topic, messageId, ttl)
+	})
+}
+
+// BatchPut stores the messages on the primary and the secondaries.
+func (r *ReplicatedAdapter) BatchPut(contract uint32, topic []byte, payloads [][]byte) error {
+	_, err := r.BatchPutResult(contract, topic, payloads, true)
+	return err
+}
+
+// BatchPutResult stores the messages on the primary and returns their messageIds, messages
+// stored on the primary are stored on the secondaries using the same messageIds.
+func (r *ReplicatedAdapter) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	messageIds, err := r.Adapter.BatchPutResult(contract, topic, payloads, atomic)
+	if messageIds == nil {
+		return messageIds, err
+	}
+	rerr := r.replicate("BatchPut", func(s Adapter) error {
+		for i, id := range messageIds {
+			if id == nil {
+				continue
+			}
+			if err := s.PutContext(context.Background(), contract, topic, payloads[i], WithID(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return messageIds, err
+	}
+	return messageIds, rerr
+}
+
+// Delete deletes the message from the primary and the secondaries.
+func (r *ReplicatedAdapter) Delete(contract uint32, messageId, topic []byte) error {
+	return r.DeleteContext(context.Background(), contract, messageId, topic)
+}
+
+// DeleteContext deletes the message from the primary and the secondaries.
+func (r *ReplicatedAdapter) DeleteContext(ctx context.Context, contract uint32, messageId, topic []byte) error {
+	if err := r.Adapter.DeleteContext(ctx, contract, messageId, topic); err != nil {
+		return err
+	}
+	return r.replicate("Delete", func(s Adapter) error {
+		return s.DeleteContext(context.Background(), contract, messageId, topic)
+	})
+}
+
+// DeleteByTopic deletes all messages of the topic from the primary and the secondaries,
+// it returns number of messages deleted from the primary.
+func (r *ReplicatedAdapter) DeleteByTopic(contract uint32, topic []byte) (int, error) {
+	deleted, err := r.Adapter.DeleteByTopic(contract, topic)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, r.replicate("DeleteByTopic", func(s Adapter) error {
+		_, err := s.DeleteByTopic(contract, topic)
+		return err
+	})
+}
+
+// BatchDelete deletes the messages from the primary and the secondaries.
+func (r *ReplicatedAdapter) BatchDelete(contract uint32, topic []byte, messageIds [][]byte) error {
+	if err := r.Adapter.BatchDelete(contract, topic, messageIds); err != nil {
+		return err
+	}
+	return r.replicate("BatchDelete", func(s Adapter) error {
+		return s.BatchDelete(contract, topic, messageIds)
+	})
+}
+
+// PurgeExpired deletes expired messages of the topic from the primary and the secondaries,
+// it returns number of messages purged from the primary.
+func (r *ReplicatedAdapter) PurgeExpired(contract uint32, topic []byte) (int, error) {
+	purged, err := r.Adapter.PurgeExpired(contract, topic)
+	if err != nil {
+		return purged, err
+	}
+	return purged, r.replicate("PurgeExpired", func(s Adapter) error {
+		_, err := s.PurgeExpired(contract, topic)
+		return err
+	})
+}
+
+// DropContract deletes all messages of the contract from the primary and the secondaries,
+// it returns number of messages deleted from the primary.
+func (r *ReplicatedAdapter) DropContract(contract uint32) (int, error) {
+	deleted, err := r.Adapter.DropContract(contract)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted, r.replicate("DropContract", func(s Adapter) error {
+		_, err := s.DropContract(contract)
+		return err
+	})
+}
+
+// Truncate waits for pending replication, then truncates the primary and the secondaries.
+// Writes wait for Truncate to queue the truncation, so they are applied after it.
+func (r *ReplicatedAdapter) Truncate() error {
+	r.gate.Lock()
+	defer r.gate.Unlock()
+	r.pending.Wait()
+	if err := r.Adapter.Truncate(); err != nil {
+		return err
+	}
+	return r.apply("Truncate", func(s Adapter) error {
+		return s.Truncate()
+	})
+}
+
+// Migrate migrates the primary and the secondaries.
+func (r *ReplicatedAdapter) Migrate() error {
+	if err := r.Adapter.Migrate(); err != nil {
+		return err
+	}
+	return r.replicate("Migrate", func(s Adapter) error {
+		return s.Migrate()
+	})
+}
+
+// ImportJSON imports the messages into the primary and the secondaries. The export is read
+// into memory, so it can be imported into each adapter.
+func (r *ReplicatedAdapter) ImportJSON(rd io.Reader, skipMalformed bool) (int, int, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return 0, 0, err
+	}
+	imported, skipped, err := r.Adapter.ImportJSON(bytes.NewReader(data), skipMalformed)
+	if err != nil {
+		return imported, skipped, err
+	}
+	return imported, skipped, r.replicate("ImportJSON", func(s Adapter) error {
+		_, _, err := s.ImportJSON(bytes.NewReader(data), skipMalformed)
+		return err
+	})
+}
+
+// Restore waits for pending replication, then restores the snapshot to the primary and the
+// secondaries. The snapshot is read into memory, so it can be restored to each adapter.
+func (r *ReplicatedAdapter) Restore(rd io.Reader, force bool) error {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	r.gate.Lock()
+	defer r.gate.Unlock()
+	r.pending.Wait()
+	if err := r.Adapter.Restore(bytes.NewReader(data), force); err != nil {
+		return err
+	}
+	return r.apply("Restore", func(s Adapter) error {
+		return s.Restore(bytes.NewReader(data), force)
+	})
+}
+
+// Close waits for pending replication, then closes the primary and the secondaries. It
+// returns the first error encountered.
+func (r *ReplicatedAdapter) Close() error {
+	return r.CloseContext(context.Background())
+}
+
+// CloseContext waits for pending replication, then closes the primary and the secondaries.
+// Writes are no longer replicated once CloseContext is called.
+func (r *ReplicatedAdapter) CloseContext(ctx context.Context) error {
+	r.gate.Lock()
+	defer r.gate.Unlock()
+	r.closed = true
+	r.pending.Wait()
+	err := r.Adapter.CloseContext(ctx)
+	for _, s := range r.secondaries {
+		if serr := s.CloseContext(ctx); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// replicate applies the write to the secondaries, it returns ErrClosed if the adapter was
+// closed.
+func (r *ReplicatedAdapter) replicate(op string, write func(s Adapter) error) error {
+	r.gate.RLock()
+	defer r.gate.RUnlock()
+	if r.closed {
+		return ErrClosed
+	}
+	return r.apply(op, write)
+}
+
+// apply applies the write to the secondaries, in async mode the write is queued to the
+// queue of each secondary. The caller must hold the gate.
+func (r *ReplicatedAdapter) apply(op string, write func(s Adapter) error) error {
+	if r.mode == ReplicateAsync {
+		for i, s := range r.secondaries {
+			i, s := i, s
+			r.pending.Add(1)
+			r.queues[i].push(func() {
+				defer r.pending.Done()
+				if err := write(s); err != nil {
+					r.failed(op, i, err)
+				}
+			})
+		}
+		return nil
+	}
+	var first error
+	for i, s := range r.secondaries {
+		if err := write(s); err != nil {
+			r.failed(op, i, err)
+			if first == nil {
+				first = fmt.Errorf("%w: secondary %d: %v", ErrReplication, i, err)
+			}
+		}
+	}
+	return first
+}
+
+func (r *ReplicatedAdapter) failed(op string, secondary int, err error) {
+	atomic.AddUint64(&r.failures, 1)
+	r.logger.Error("ReplicatedAdapter."+op, "secondary "+strconv.Itoa(secondary)+" failed "+err.Error())
+}
@@ -0,0 +1,268 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memAdapter stores messages in memory, operations not used by the tests panic.
+type memAdapter struct {
+	Adapter
+	mu       sync.Mutex
+	next     int
+	err      error
+	closed   bool
+	migrated bool
+	msgs     map[string]map[string][]byte
+}
+
+func newMemAdapter() *memAdapter {
+	return &memAdapter{msgs: make(map[string]map[string][]byte)}
+}
+
+func memKey(contract uint32, topic []byte) string {
+	return strconv.FormatUint(uint64(contract), 10) + "/" + string(topic)
+}
+
+func (m *memAdapter) NewID() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	return []byte("id" + strconv.Itoa(m.next)), nil
+}
+
+func (m *memAdapter) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...WriteOption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	id := NewWriteOptions(opts...).ID
+	if id == nil {
+		m.next++
+		id = []byte("id" + strconv.Itoa(m.next))
+	}
+	k := memKey(contract, topic)
+	if m.msgs[k] == nil {
+		m.msgs[k] = make(map[string][]byte)
+	}
+	m.msgs[k][string(id)] = payload
+	return nil
+}
+
+func (m *memAdapter) Put(contract uint32, topic, payload []byte, opts ...WriteOption) error {
+	return m.PutContext(context.Background(), contract, topic, payload, opts...)
+}
+
+func (m *memAdapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	return m.Put(contract, topic, payload, WithID(messageId))
+}
+
+func (m *memAdapter) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	var ids [][]byte
+	for _, payload := range payloads {
+		id, err := m.NewID()
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Put(contract, topic, payload, WithID(id)); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m *memAdapter) Delete(contract uint32, messageId, topic []byte) error {
+	return m.DeleteContext(context.Background(), contract, messageId, topic)
+}
+
+func (m *memAdapter) DeleteContext(ctx context.Context, contract uint32, messageId, topic []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	delete(m.msgs[memKey(contract, topic)], string(messageId))
+	return nil
+}
+
+func (m *memAdapter) Get(contract uint32, topic []byte, limit int) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matches [][]byte
+	for _, payload := range m.msgs[memKey(contract, topic)] {
+		if len(matches) == limit {
+			break
+		}
+		matches = append(matches, payload)
+	}
+	return matches, nil
+}
+
+func (m *memAdapter) Exists(contract uint32, topic, messageId []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.msgs[memKey(contract, topic)][string(messageId)]
+	return ok, nil
+}
+
+func (m *memAdapter) Close() error {
+	return m.CloseContext(context.Background())
+}
+
+func (m *memAdapter) CloseContext(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memAdapter) Migrate() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrated = true
+	return m.err
+}
+
+func (m *memAdapter) SetLogger(l Logger) {}
+
+type nopLogger struct{}
+
+func (nopLogger) Error(context, msg string) {}
+func (nopLogger) Info(context, msg string)  {}
+func (nopLogger) Debug(context, msg string) {}
+
+func TestReplicatedAdapterSync(t *testing.T) {
+	primary, s1, s2 := newMemAdapter(), newMemAdapter(), newMemAdapter()
+	r := NewReplicatedAdapter(ReplicateSync, primary, s1, s2)
+	r.SetLogger(nopLogger{})
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.replicated")
+	id, err := r.PutReturningID(contract, topic, []byte("msg1"))
+	assert.NoError(t, err)
+	for _, a := range []*memAdapter{primary, s1, s2} {
+		ok, err := a.Exists(contract, topic, id)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	s2.err = errors.New("disk full")
+	err = r.PutWithID(contract, []byte("id2"), topic, []byte("msg2"))
+	assert.True(t, errors.Is(err, ErrReplication))
+	assert.Equal(t, uint64(1), r.Failures())
+	ok, _ := primary.Exists(contract, topic, []byte("id2"))
+	assert.True(t, ok)
+	ok, _ = s1.Exists(contract, topic, []byte("id2"))
+	assert.True(t, ok)
+	ok, _ = s2.Exists(contract, topic, []byte("id2"))
+	assert.False(t, ok)
+
+	// Writes failing on the primary are not replicated.
+	primary.err = errors.New("primary failed")
+	assert.Equal(t, primary.err, r.Delete(contract, id, topic))
+	ok, _ = s1.Exists(contract, topic, id)
+	assert.True(t, ok)
+
+	primary.err, s2.err = nil, nil
+	assert.NoError(t, r.Delete(contract, id, topic))
+	ok, _ = s1.Exists(contract, topic, id)
+	assert.False(t, ok)
+
+	assert.NoError(t, r.Close())
+	assert.True(t, primary.closed && s1.closed && s2.closed)
+}
+
+func TestReplicatedAdapterAsync(t *testing.T) {
+	primary, s1, s2 := newMemAdapter(), newMemAdapter(), newMemAdapter()
+	r := NewReplicatedAdapter(ReplicateAsync, primary, s1, s2)
+	r.SetLogger(nopLogger{})
+	s2.err = errors.New("disk full")
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.replicated")
+	assert.NoError(t, r.PutWithID(contract, []byte("id1"), topic, []byte("msg1")))
+	assert.NoError(t, r.Close())
+
+	ok, _ := s1.Exists(contract, topic, []byte("id1"))
+	assert.True(t, ok)
+	ok, _ = s2.Exists(contract, topic, []byte("id1"))
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), r.Failures())
+}
+
+func TestReplicatedAdapterAsyncClose(t *testing.T) {
+	primary, s1 := newMemAdapter(), newMemAdapter()
+	r := NewReplicatedAdapter(ReplicateAsync, primary, s1)
+	r.SetLogger(nopLogger{})
+
+	// writes racing Close are either replicated or refused
+	contract := uint32(3376684800)
+	topic := []byte("unit1.replicated")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := r.PutWithID(contract, []byte("id"+strconv.Itoa(i)), topic, []byte("msg"))
+			assert.True(t, err == nil || errors.Is(err, ErrClosed))
+		}(i)
+	}
+	assert.NoError(t, r.Close())
+	wg.Wait()
+
+	assert.Equal(t, ErrClosed, r.PutWithID(contract, []byte("closed"), topic, []byte("msg")))
+	ok, _ := s1.Exists(contract, topic, []byte("closed"))
+	assert.False(t, ok)
+}
+
+func TestReplicatedAdapterMigrate(t *testing.T) {
+	primary, s1, s2 := newMemAdapter(), newMemAdapter(), newMemAdapter()
+	r := NewReplicatedAdapter(ReplicateSync, primary, s1, s2)
+	r.SetLogger(nopLogger{})
+
+	assert.NoError(t, r.Migrate())
+	assert.True(t, primary.migrated && s1.migrated && s2.migrated)
+}
+
+func TestReplicatedAdapterBatchPut(t *testing.T) {
+	primary, s1 := newMemAdapter(), newMemAdapter()
+	r := NewReplicatedAdapter(ReplicateSync, primary, s1)
+	r.SetLogger(nopLogger{})
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.replicated")
+	ids, err := r.BatchPutResult(contract, topic, [][]byte{[]byte("msg1"), []byte("msg2")}, true)
+	assert.NoError(t, err)
+	assert.Len(t, ids, 2)
+	for _, id := range ids {
+		ok, _ := s1.Exists(contract, topic, id)
+		assert.True(t, ok)
+	}
+}
+
+func TestReplicatedAdapterAsyncOrder(t *testing.T) {
+	primary, s1 := newMemAdapter(), newMemAdapter()
+	r := NewReplicatedAdapter(ReplicateAsync, primary, s1)
+	r.SetLogger(nopLogger{})
+
+	// writes are applied to the secondary in order, so a delete follows the put of the message
+	contract := uint32(3376684800)
+	topic := []byte("unit1.replicated")
+	for i := 0; i < 100; i++ {
+		id := []byte("id" + strconv.Itoa(i))
+		assert.NoError(t, r.PutWithID(contract, id, topic, []byte("msg")))
+		assert.NoError(t, r.Delete(contract, id, topic))
+	}
+	assert.NoError(t, r.Close())
+	matches, err := s1.Get(contract, topic, 1000)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	assert.Equal(t, uint64(0), r.Failures())
+}
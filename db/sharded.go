@@ -0,0 +1,611 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unit-io/unitd/pkg/metrics"
+)
+
+var _ Adapter = (*ShardedAdapter)(nil)
+
+const shardedAdapterName = "sharded"
+
+// errShardedBackup is returned by Backup and Restore of a sharded adapter.
+var errShardedBackup = errors.New("sharded adapter cannot back up shards into a single snapshot, back up each shard instead")
+
+// ShardErrors holds errors of operations applied to multiple shards, keyed by shard index.
+type ShardErrors map[int]error
+
+func (e ShardErrors) Error() string {
+	shards := make([]int, 0, len(e))
+	for i := range e {
+		shards = append(shards, i)
+	}
+	sort.Ints(shards)
+	msgs := make([]string, 0, len(shards))
+	for _, i := range shards {
+		msgs = append(msgs, "shard "+strconv.Itoa(i)+": "+e[i].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the shard errors matches target.
+func (e ShardErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShardedAdapter distributes contracts across multiple adapters. Each contract is routed to
+// exactly one shard, so reads and writes of a contract always go to the same shard and
+// contracts on different shards are isolated. Operations that are not scoped to a contract
+// are applied to all shards, except operations of the message log which is kept on the
+// first shard.
+type ShardedAdapter struct {
+	shards []Adapter
+	route  func(contract uint32) int
+}
+
+// NewShardedAdapter returns an adapter distributing contracts across shards. The route
+// function returns the shard index of the contract, contracts are distributed by contract
+// modulo number of shards if route is nil.
+func NewShardedAdapter(route func(contract uint32) int, shards ...Adapter) (*ShardedAdapter, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("sharded adapter requires at least one shard")
+	}
+	if route == nil {
+		n := uint32(len(shards))
+		route = func(contract uint32) int { return int(contract % n) }
+	}
+	return &ShardedAdapter{shards: shards, route: route}, nil
+}
+
+// Shards returns the underlying adapters.
+func (s *ShardedAdapter) Shards() []Adapter {
+	return s.shards
+}
+
+// shardIndex returns the index of the shard the contract is routed to. Contracts are
+// routed to the first shard if the route function returns an index out of range.
+func (s *ShardedAdapter) shardIndex(contract uint32) int {
+	i := s.route(contract)
+	if i < 0 || i >= len(s.shards) {
+		return 0
+	}
+	return i
+}
+
+// shard returns the adapter the contract is routed to.
+func (s *ShardedAdapter) shard(contract uint32) Adapter {
+	return s.shards[s.shardIndex(contract)]
+}
+
+// each calls fn for each shard and aggregates the errors.
+func (s *ShardedAdapter) each(fn func(i int, a Adapter) error) error {
+	errs := make(ShardErrors)
+	for i, a := range s.shards {
+		if err := fn(i, a); err != nil {
+			errs[i] = err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Open opens all shards. The config is a JSON array holding the config of each shard. If a
+// shard fails to open the shards that opened are closed.
+func (s *ShardedAdapter) Open(config string) error {
+	var configs []json.RawMessage
+	if err := json.Unmarshal([]byte(config), &configs); err != nil {
+		return errors.New("sharded adapter failed to parse config: " + err.Error())
+	}
+	if len(configs) != len(s.shards) {
+		return fmt.Errorf("sharded adapter has %d shards but %d shard configs", len(s.shards), len(configs))
+	}
+	if err := s.each(func(i int, a Adapter) error { return a.Open(string(configs[i])) }); err != nil {
+		errs := err.(ShardErrors)
+		for i, a := range s.shards {
+			if _, ok := errs[i]; !ok {
+				a.Close()
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Close closes all shards.
+func (s *ShardedAdapter) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext closes all shards.
+func (s *ShardedAdapter) CloseContext(ctx context.Context) error {
+	return s.each(func(i int, a Adapter) error { return a.CloseContext(ctx) })
+}
+
+// IsOpen returns true if all shards are open.
+func (s *ShardedAdapter) IsOpen() bool {
+	for _, a := range s.shards {
+		if !a.IsOpen() {
+			return false
+		}
+	}
+	return true
+}
+
+// Ping checks all shards.
+func (s *ShardedAdapter) Ping() error {
+	return s.each(func(i int, a Adapter) error { return a.Ping() })
+}
+
+// Version returns the lowest version of the shards.
+func (s *ShardedAdapter) Version() int {
+	version := s.shards[0].Version()
+	for _, a := range s.shards[1:] {
+		if v := a.Version(); v < version {
+			version = v
+		}
+	}
+	return version
+}
+
+// GetName returns the name of the adapter.
+func (s *ShardedAdapter) GetName() string {
+	return shardedAdapterName
+}
+
+// shardMetrics prefixes metric names with the shard, so the metrics of shards do not collide.
+type shardMetrics struct {
+	metrics.Metrics
+	prefix string
+}
+
+func (m shardMetrics) GetOrRegister(name string, metric interface{}) interface{} {
+	return m.Metrics.GetOrRegister(m.prefix+name, metric)
+}
+
+func (m shardMetrics) Unregister(name string) {
+	m.Metrics.Unregister(m.prefix + name)
+}
+
+// RegisterMetrics registers the metrics of each shard, metric names are prefixed by "shard<i>_".
+func (s *ShardedAdapter) RegisterMetrics(r metrics.Metrics) error {
+	return s.each(func(i int, a Adapter) error {
+		return a.RegisterMetrics(shardMetrics{Metrics: r, prefix: "shard" + strconv.Itoa(i) + "_"})
+	})
+}
+
+// SetLogger sets the logger of all shards.
+func (s *ShardedAdapter) SetLogger(l Logger) {
+	for _, a := range s.shards {
+		a.SetLogger(l)
+	}
+}
+
+// SetTracer sets the tracer of all shards.
+func (s *ShardedAdapter) SetTracer(t Tracer) {
+	for _, a := range s.shards {
+		a.SetTracer(t)
+	}
+}
+
+// OnWrite registers the hook on all shards.
+func (s *ShardedAdapter) OnWrite(hook func(contract uint32, topic, messageId, payload []byte)) {
+	for _, a := range s.shards {
+		a.OnWrite(hook)
+	}
+}
+
+// OnExpire registers the hook on all shards.
+func (s *ShardedAdapter) OnExpire(hook func(contract uint32, topic, messageId []byte)) {
+	for _, a := range s.shards {
+		a.OnExpire(hook)
+	}
+}
+
+// Operations scoped to a contract are routed to the shard of the contract.
+
+// Put stores the message on the shard of the contract.
+func (s *ShardedAdapter) Put(contract uint32, topic, payload []byte, opts ...WriteOption) error {
+	return s.shard(contract).Put(contract, topic, payload, opts...)
+}
+
+// PutContext stores the message on the shard of the contract.
+func (s *ShardedAdapter) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...WriteOption) error {
+	return s.shard(contract).PutContext(ctx, contract, topic, payload, opts...)
+}
+
+// PutWithTTL stores the message that expires after the ttl on the shard of the contract.
+func (s *ShardedAdapter) PutWithTTL(contract uint32, topic, payload []byte, ttl time.Duration) error {
+	return s.shard(contract).PutWithTTL(contract, topic, payload, ttl)
+}
+
+// PutReturningID stores the message on the shard of the contract and returns its messageId.
+func (s *ShardedAdapter) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	return s.shard(contract).PutReturningID(contract, topic, payload)
+}
+
+// PutAt stores the message with the timestamp on the shard of the contract and returns its messageId.
+func (s *ShardedAdapter) PutAt(contract uint32, topic, payload []byte, ts time.Time) ([]byte, error) {
+	return s.shard(contract).PutAt(contract, topic, payload, ts)
+}
+
+// PutIfAbsent stores the message on the shard of the contract unless it is already stored.
+func (s *ShardedAdapter) PutIfAbsent(contract uint32, topic, messageId, payload []byte) (bool, error) {
+	return s.shard(contract).PutIfAbsent(contract, topic, messageId, payload)
+}
+
+// CompareAndSwap stores the message on the shard of the contract if the precondition holds on the shard.
+func (s *ShardedAdapter) CompareAndSwap(contract uint32, topic, expectedId, payload []byte) ([]byte, bool, error) {
+	return s.shard(contract).CompareAndSwap(contract, topic, expectedId, payload)
+}
+
+// Increment adds delta to the counter of the topic on the shard of the contract.
+func (s *ShardedAdapter) Increment(contract uint32, topic []byte, delta int64) (int64, error) {
+	return s.shard(contract).Increment(contract, topic, delta)
+}
+
+// PutWithQoS stores the message with the QoS level on the shard of the contract.
+func (s *ShardedAdapter) PutWithQoS(contract uint32, topic, payload []byte, qos uint8) error {
+	return s.shard(contract).PutWithQoS(contract, topic, payload, qos)
+}
+
+// PutWithID stores the message using the messageId on the shard of the contract.
+func (s *ShardedAdapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	return s.shard(contract).PutWithID(contract, messageId, topic, payload)
+}
+
+// BatchPut stores the messages on the shard of the contract in a single batch.
+func (s *ShardedAdapter) BatchPut(contract uint32, topic []byte, payloads [][]byte) error {
+	return s.shard(contract).BatchPut(contract, topic, payloads)
+}
+
+// BatchPutResult stores the messages on the shard of the contract and returns their messageIds.
+func (s *ShardedAdapter) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	return s.shard(contract).BatchPutResult(contract, topic, payloads, atomic)
+}
+
+// Get returns messages of the topic from the shard of the contract.
+func (s *ShardedAdapter) Get(contract uint32, topic []byte, limit int) ([][]byte, error) {
+	return s.shard(contract).Get(contract, topic, limit)
+}
+
+// GetContext returns messages of the topic from the shard of the contract.
+func (s *ShardedAdapter) GetContext(ctx context.Context, contract uint32, topic []byte, limit int) ([][]byte, error) {
+	return s.shard(contract).GetContext(ctx, contract, topic, limit)
+}
+
+// GetInto appends messages of the topic from the shard of the contract to dst.
+func (s *ShardedAdapter) GetInto(contract uint32, topic []byte, limit int, dst [][]byte) ([][]byte, error) {
+	return s.shard(contract).GetInto(contract, topic, limit, dst)
+}
+
+// GetLast returns the last message of the topic from the shard of the contract.
+func (s *ShardedAdapter) GetLast(contract uint32, topic []byte) ([]byte, bool, error) {
+	return s.shard(contract).GetLast(contract, topic)
+}
+
+// GetPage returns a page of messages of the topic from the shard of the contract.
+func (s *ShardedAdapter) GetPage(contract uint32, topic, cursor []byte, limit int) ([][]byte, []byte, error) {
+	return s.shard(contract).GetPage(contract, topic, cursor, limit)
+}
+
+// GetOffset returns messages of the topic from the shard of the contract starting at offset.
+func (s *ShardedAdapter) GetOffset(contract uint32, topic []byte, offset, limit int) ([][]byte, error) {
+	return s.shard(contract).GetOffset(contract, topic, offset, limit)
+}
+
+// Stream streams messages of the topic from the shard of the contract.
+func (s *ShardedAdapter) Stream(ctx context.Context, contract uint32, topic []byte) (<-chan []byte, <-chan error) {
+	return s.shard(contract).Stream(ctx, contract, topic)
+}
+
+// Watch watches messages written to the topic on the shard of the contract.
+func (s *ShardedAdapter) Watch(ctx context.Context, contract uint32, topic []byte) (<-chan Message, error) {
+	return s.shard(contract).Watch(ctx, contract, topic)
+}
+
+// GetMessages returns messages of the topic along with their metadata from the shard of the contract.
+func (s *ShardedAdapter) GetMessages(contract uint32, topic []byte, limit int) ([]Message, error) {
+	return s.shard(contract).GetMessages(contract, topic, limit)
+}
+
+// GetOrdered returns messages of the topic ordered by time from the shard of the contract.
+func (s *ShardedAdapter) GetOrdered(contract uint32, topic []byte, limit int, desc bool) ([][]byte, error) {
+	return s.shard(contract).GetOrdered(contract, topic, limit, desc)
+}
+
+// GetWildcard returns messages of topics matching the pattern from the shard of the contract.
+func (s *ShardedAdapter) GetWildcard(contract uint32, topicPattern []byte, limit int) ([][]byte, error) {
+	return s.shard(contract).GetWildcard(contract, topicPattern, limit)
+}
+
+// GetRange returns messages of the topic stored in the time range from the shard of the contract.
+func (s *ShardedAdapter) GetRange(contract uint32, topic []byte, from, until time.Time, limit int) ([][]byte, error) {
+	return s.shard(contract).GetRange(contract, topic, from, until, limit)
+}
+
+// GetSince returns messages of the topic stored after since from the shard of the contract.
+func (s *ShardedAdapter) GetSince(contract uint32, topic []byte, since time.Time, limit int) ([]Message, error) {
+	return s.shard(contract).GetSince(contract, topic, since, limit)
+}
+
+// CountRange counts messages of the topic stored in the time range on the shard of the contract.
+func (s *ShardedAdapter) CountRange(contract uint32, topic []byte, from, until time.Time) (uint64, error) {
+	return s.shard(contract).CountRange(contract, topic, from, until)
+}
+
+// ScanPrefix returns messages of topics with the prefix from the shard of the contract.
+func (s *ShardedAdapter) ScanPrefix(contract uint32, prefix []byte, limit int) ([]Message, error) {
+	return s.shard(contract).ScanPrefix(contract, prefix, limit)
+}
+
+// GetMulti returns messages of the topics from the shard of the contract.
+func (s *ShardedAdapter) GetMulti(contract uint32, topics [][]byte, limit int) (map[string][][]byte, error) {
+	return s.shard(contract).GetMulti(contract, topics, limit)
+}
+
+// Count counts messages of the topic on the shard of the contract.
+func (s *ShardedAdapter) Count(contract uint32, topic []byte) (uint64, error) {
+	return s.shard(contract).Count(contract, topic)
+}
+
+// Exists reports whether the message is stored on the shard of the contract.
+func (s *ShardedAdapter) Exists(contract uint32, topic, messageId []byte) (bool, error) {
+	return s.shard(contract).Exists(contract, topic, messageId)
+}
+
+// GetByID returns the message with the messageId from the shard of the contract.
+func (s *ShardedAdapter) GetByID(contract uint32, topic, messageId []byte) ([]byte, bool, error) {
+	return s.shard(contract).GetByID(contract, topic, messageId)
+}
+
+// Topics returns topics of the contract from the shard of the contract.
+func (s *ShardedAdapter) Topics(contract uint32) ([][]byte, error) {
+	return s.shard(contract).Topics(contract)
+}
+
+// Contracts returns contracts of all shards in ascending order, a limit greater than zero
+// bounds number of contracts returned.
+func (s *ShardedAdapter) Contracts(limit int) ([]uint32, error) {
+	var contracts []uint32
+	for _, a := range s.shards {
+		matches, err := a.Contracts(limit)
+		if err != nil {
+			return nil, err
+		}
+		contracts = append(contracts, matches...)
+	}
+	sort.Slice(contracts, func(i, j int) bool { return contracts[i] < contracts[j] })
+	if limit > 0 && len(contracts) > limit {
+		contracts = contracts[:limit]
+	}
+	return contracts, nil
+}
+
+// NextSeq returns the next sequence number of the topic from the shard of the contract.
+func (s *ShardedAdapter) NextSeq(contract uint32, topic []byte) (uint64, error) {
+	return s.shard(contract).NextSeq(contract, topic)
+}
+
+// Touch resets the TTL of the message on the shard of the contract.
+func (s *ShardedAdapter) Touch(contract uint32, topic, messageId []byte, ttl time.Duration) error {
+	return s.shard(contract).Touch(contract, topic, messageId, ttl)
+}
+
+// NewID generates a new messageId using the first shard.
+func (s *ShardedAdapter) NewID() ([]byte, error) {
+	return s.shards[0].NewID()
+}
+
+// Delete deletes the message from the shard of the contract.
+func (s *ShardedAdapter) Delete(contract uint32, messageId, topic []byte) error {
+	return s.shard(contract).Delete(contract, messageId, topic)
+}
+
+// DeleteByTopic deletes all messages of the topic from the shard of the contract.
+func (s *ShardedAdapter) DeleteByTopic(contract uint32, topic []byte) (int, error) {
+	return s.shard(contract).DeleteByTopic(contract, topic)
+}
+
+// DropContract deletes all messages of the contract from the shard of the contract.
+func (s *ShardedAdapter) DropContract(contract uint32) (int, error) {
+	return s.shard(contract).DropContract(contract)
+}
+
+// PurgeExpired deletes expired messages of the topic from the shard of the contract.
+func (s *ShardedAdapter) PurgeExpired(contract uint32, topic []byte) (int, error) {
+	return s.shard(contract).PurgeExpired(contract, topic)
+}
+
+// BatchDelete deletes the messages from the shard of the contract.
+func (s *ShardedAdapter) BatchDelete(contract uint32, topic []byte, messageIds [][]byte) error {
+	return s.shard(contract).BatchDelete(contract, topic, messageIds)
+}
+
+// DeleteContext deletes the message from the shard of the contract.
+func (s *ShardedAdapter) DeleteContext(ctx context.Context, contract uint32, messageId, topic []byte) error {
+	return s.shard(contract).DeleteContext(ctx, contract, messageId, topic)
+}
+
+// Sync syncs all shards.
+func (s *ShardedAdapter) Sync() error {
+	return s.each(func(i int, a Adapter) error { return a.Sync() })
+}
+
+// Compact compacts all shards.
+func (s *ShardedAdapter) Compact() error {
+	return s.each(func(i int, a Adapter) error { return a.Compact() })
+}
+
+// Truncate truncates all shards.
+func (s *ShardedAdapter) Truncate() error {
+	return s.each(func(i int, a Adapter) error { return a.Truncate() })
+}
+
+// Stats returns the stats of all shards combined. Version is the lowest version of the shards.
+func (s *ShardedAdapter) Stats() (Stats, error) {
+	var stats Stats
+	for i, a := range s.shards {
+		st, err := a.Stats()
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.Size += st.Size
+		stats.Count += st.Count
+		if i == 0 || st.Version < stats.Version {
+			stats.Version = st.Version
+		}
+		if st.LastReconnect.After(stats.LastReconnect) {
+			stats.LastReconnect = st.LastReconnect
+		}
+	}
+	return stats, nil
+}
+
+// FileSize returns the total size of the shards.
+func (s *ShardedAdapter) FileSize() (int64, error) {
+	var size int64
+	for _, a := range s.shards {
+		n, err := a.FileSize()
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// Migrate migrates each shard.
+func (s *ShardedAdapter) Migrate() error {
+	return s.each(func(i int, a Adapter) error { return a.Migrate() })
+}
+
+// Backup is not supported by the sharded adapter, use Backup of each shard.
+func (s *ShardedAdapter) Backup(w io.Writer) (int64, error) {
+	return 0, errShardedBackup
+}
+
+// Restore is not supported by the sharded adapter, use Restore of each shard.
+func (s *ShardedAdapter) Restore(r io.Reader, force bool) error {
+	return errShardedBackup
+}
+
+// ExportJSON writes messages of the contract from the shard of the contract to w.
+func (s *ShardedAdapter) ExportJSON(contract uint32, w io.Writer) error {
+	return s.shard(contract).ExportJSON(contract, w)
+}
+
+// ImportJSON imports each record into the shard of the record contract. Lines are streamed
+// to the shards, lines without a contract are malformed.
+func (s *ShardedAdapter) ImportJSON(r io.Reader, skipMalformed bool) (imported, skipped int, err error) {
+	type result struct {
+		imported, skipped int
+		err               error
+	}
+	pipes := make([]*io.PipeWriter, len(s.shards))
+	results := make([]result, len(s.shards))
+	var wg sync.WaitGroup
+	for i, a := range s.shards {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		wg.Add(1)
+		go func(i int, a Adapter) {
+			defer wg.Done()
+			n, skip, err := a.ImportJSON(pr, skipMalformed)
+			results[i] = result{n, skip, err}
+			pr.CloseWithError(err)
+		}(i, a)
+	}
+
+	br := bufio.NewReader(r)
+	for line := 1; err == nil; line++ {
+		data, rerr := br.ReadBytes('\n')
+		if rerr != nil && rerr != io.EOF {
+			err = rerr
+			break
+		}
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 {
+			var rec struct {
+				Contract *uint32 `json:"contract"`
+			}
+			if jerr := json.Unmarshal(trimmed, &rec); jerr != nil || rec.Contract == nil {
+				if !skipMalformed {
+					err = fmt.Errorf("sharded adapter malformed record on line %d", line)
+					break
+				}
+				skipped++
+			} else if _, werr := pipes[s.shardIndex(*rec.Contract)].Write(append(trimmed, '\n')); werr != nil {
+				err = werr
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+	}
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	// A shard import error is reported over the error writing to its closed pipe.
+	for _, res := range results {
+		imported += res.imported
+		skipped += res.skipped
+		if res.err != nil && res.err != err {
+			err = res.err
+		}
+	}
+	return imported, skipped, err
+}
+
+// Append appends message to the message log of the first shard.
+func (s *ShardedAdapter) Append(delFlag bool, k uint64, data []byte) error {
+	return s.shards[0].Append(delFlag, k, data)
+}
+
+// PutMessage stores the message in the message log of the first shard.
+func (s *ShardedAdapter) PutMessage(blockId, key uint64, payload []byte) error {
+	return s.shards[0].PutMessage(blockId, key, payload)
+}
+
+// GetMessage returns the message from the message log of the first shard.
+func (s *ShardedAdapter) GetMessage(blockId, key uint64) ([]byte, error) {
+	return s.shards[0].GetMessage(blockId, key)
+}
+
+// Keys returns keys of the block from the message log of the first shard.
+func (s *ShardedAdapter) Keys(blockId uint64) []uint64 {
+	return s.shards[0].Keys(blockId)
+}
+
+// DeleteMessage deletes the message from the message log of the first shard.
+func (s *ShardedAdapter) DeleteMessage(blockId, key uint64) error {
+	return s.shards[0].DeleteMessage(blockId, key)
+}
+
+// Write writes the message log of the first shard.
+func (s *ShardedAdapter) Write() error {
+	return s.shards[0].Write()
+}
+
+// Recovery recovers messages from the message log of the first shard.
+func (s *ShardedAdapter) Recovery(reset bool) (map[uint64][]byte, error) {
+	return s.shards[0].Recovery(reset)
+}
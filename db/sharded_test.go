@@ -0,0 +1,86 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errCloseFailed = errors.New("close failed")
+
+type closeErrAdapter struct {
+	*memAdapter
+}
+
+func (a closeErrAdapter) CloseContext(ctx context.Context) error { return errCloseFailed }
+
+type openAdapter struct {
+	*memAdapter
+	err error
+}
+
+func (a openAdapter) Open(config string) error { return a.err }
+
+func TestShardedAdapter(t *testing.T) {
+	s0, s1 := newMemAdapter(), newMemAdapter()
+	s, err := NewShardedAdapter(func(contract uint32) int {
+		if contract < 100 {
+			return 0
+		}
+		return 1
+	}, s0, s1)
+	assert.NoError(t, err)
+
+	topic := []byte("unit1.sharded")
+	assert.NoError(t, s.PutWithID(1, []byte("id1"), topic, []byte("msg1")))
+	assert.NoError(t, s.PutWithID(200, []byte("id2"), topic, []byte("msg2")))
+
+	ok, _ := s0.Exists(1, topic, []byte("id1"))
+	assert.True(t, ok)
+	ok, _ = s1.Exists(1, topic, []byte("id1"))
+	assert.False(t, ok)
+	ok, _ = s1.Exists(200, topic, []byte("id2"))
+	assert.True(t, ok)
+
+	matches, err := s.Get(1, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg1")}, matches)
+	matches, err = s.Get(200, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg2")}, matches)
+
+	assert.NoError(t, s.Delete(200, []byte("id2"), topic))
+	ok, _ = s.Exists(200, topic, []byte("id2"))
+	assert.False(t, ok)
+
+	assert.NoError(t, s.Close())
+	assert.True(t, s0.closed && s1.closed)
+}
+
+func TestShardedAdapterErrors(t *testing.T) {
+	_, err := NewShardedAdapter(nil)
+	assert.Error(t, err)
+
+	s, err := NewShardedAdapter(nil, newMemAdapter(), newMemAdapter())
+	assert.NoError(t, err)
+	assert.Error(t, s.Open(`[{"dir": "/tmp/shard0"}]`))
+
+	// shards that opened are closed if a shard fails to open
+	s0 := newMemAdapter()
+	s, err = NewShardedAdapter(nil, openAdapter{memAdapter: s0}, openAdapter{memAdapter: newMemAdapter(), err: ErrVersionMismatch})
+	assert.NoError(t, err)
+	err = s.Open(`[{}, {}]`)
+	assert.True(t, errors.Is(err, ErrVersionMismatch))
+	assert.Contains(t, err.Error(), "shard 1")
+	assert.True(t, s0.closed)
+
+	s0 = newMemAdapter()
+	s, err = NewShardedAdapter(nil, s0, closeErrAdapter{newMemAdapter()})
+	assert.NoError(t, err)
+	err = s.Close()
+	assert.True(t, errors.Is(err, errCloseFailed))
+	assert.Contains(t, err.Error(), "shard 1")
+	assert.True(t, s0.closed)
+}
@@ -0,0 +1,1471 @@
+// Package storetest provides an in-memory adapter for tests of code using the store. The
+// adapter keeps messages in maps and lets tests inject errors per method, so callers can
+// test their error handling without a database.
+package storetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitd/pkg/metrics"
+)
+
+var _ dbadapter.Adapter = (*MockAdapter)(nil)
+
+const (
+	adapterName = "mock"
+
+	// Version reported by an open adapter
+	mockVersion = 2
+
+	// Size of messageIds generated by the adapter
+	idSize = 16
+
+	// Maximum number of messages returned by a query
+	maxResults = 1024
+
+	// Number of messages buffered for a watcher
+	watchBuffer = 64
+)
+
+type topicKey struct {
+	contract uint32
+	topic    string
+}
+
+// message is a stored message and the time it expires, a zero expiry means the message
+// never expires.
+type message struct {
+	dbadapter.Message
+	expiry time.Time
+}
+
+func (m *message) expired(now time.Time) bool {
+	return !m.expiry.IsZero() && !now.Before(m.expiry)
+}
+
+type watcher struct {
+	key topicKey
+	ch  chan dbadapter.Message
+}
+
+// record is a message in the newline-delimited JSON format written by ExportJSON and Backup.
+type record struct {
+	Contract  uint32    `json:"contract"`
+	Topic     string    `json:"topic"`
+	ID        []byte    `json:"id"`
+	Payload   []byte    `json:"payload_base64"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MockAdapter is an in-memory dbadapter.Adapter. Messages of each topic are kept ordered by
+// time, queries returning last n messages return them oldest first. Topics are matched
+// literally, except by GetWildcard. Messages expire once their TTL elapses and expired
+// messages are removed by PurgeExpired. messageIds are 16 bytes long.
+//
+// An error set for a method using SetError is returned by each call of the method, so
+// tests can check how callers handle errors such as dbadapter.ErrClosed. The adapter is
+// safe for concurrent use.
+type MockAdapter struct {
+	mu       sync.Mutex
+	open     bool
+	errs     map[string]error
+	topics   map[topicKey][]*message
+	seqs     map[topicKey]uint64
+	counters map[topicKey]int64
+	blocks   map[uint64]map[uint64][]byte
+	pending  map[uint64][]byte
+	log      map[uint64][]byte
+	nextID   uint64
+	onWrite  []func(contract uint32, topic, messageId, payload []byte)
+	onExpire []func(contract uint32, topic, messageId []byte)
+	watchers map[*watcher]struct{}
+	logger   dbadapter.Logger
+	tracer   dbadapter.Tracer
+}
+
+// NewMockAdapter returns an open MockAdapter with no messages.
+func NewMockAdapter() *MockAdapter {
+	m := &MockAdapter{errs: make(map[string]error), watchers: make(map[*watcher]struct{})}
+	m.reset()
+	m.open = true
+	return m
+}
+
+// SetError sets the error returned by each call of the method, the method is the name of the
+// Adapter method such as "Put". A nil err clears the error.
+func (m *MockAdapter) SetError(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.errs, method)
+		return
+	}
+	m.errs[method] = err
+}
+
+// reset drops all data, the caller must hold the lock.
+func (m *MockAdapter) reset() {
+	m.topics = make(map[topicKey][]*message)
+	m.seqs = make(map[topicKey]uint64)
+	m.counters = make(map[topicKey]int64)
+	m.blocks = make(map[uint64]map[uint64][]byte)
+	m.pending = make(map[uint64][]byte)
+	m.log = make(map[uint64][]byte)
+}
+
+// check returns the error set for the method or dbadapter.ErrClosed if the adapter is
+// closed, the caller must hold the lock.
+func (m *MockAdapter) check(method string) error {
+	if err := m.errs[method]; err != nil {
+		return err
+	}
+	if !m.open {
+		return dbadapter.ErrClosed
+	}
+	return nil
+}
+
+// checkTopic is check for operations of a topic.
+func (m *MockAdapter) checkTopic(method string, topic []byte) error {
+	if err := m.check(method); err != nil {
+		return err
+	}
+	if len(topic) == 0 {
+		return dbadapter.ErrEmptyTopic
+	}
+	return nil
+}
+
+// checkQuery is checkTopic for queries with a limit, it returns the limit capped at max results.
+func (m *MockAdapter) checkQuery(method string, topic []byte, limit int) (int, error) {
+	if err := m.checkTopic(method, topic); err != nil {
+		return 0, err
+	}
+	if limit < 1 {
+		return 0, dbadapter.ErrInvalidLimit
+	}
+	if limit > maxResults {
+		limit = maxResults
+	}
+	return limit, nil
+}
+
+func checkMessageID(messageId []byte) error {
+	if len(messageId) != idSize {
+		return dbadapter.ErrInvalidMessageID
+	}
+	return nil
+}
+
+// key returns the key of the topic without topic options.
+func key(contract uint32, topic []byte) topicKey {
+	if i := bytes.IndexByte(topic, '?'); i >= 0 {
+		topic = topic[:i]
+	}
+	return topicKey{contract: contract, topic: string(topic)}
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func copyMessage(msg *message) dbadapter.Message {
+	c := msg.Message
+	c.ID = clone(msg.ID)
+	c.Payload = clone(msg.Payload)
+	return c
+}
+
+// newID returns a new messageId, the caller must hold the lock.
+func (m *MockAdapter) newID() []byte {
+	m.nextID++
+	id := make([]byte, idSize)
+	binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(id[8:], m.nextID)
+	return id
+}
+
+// messages returns messages of the topic that have not expired, the caller must hold the lock.
+func (m *MockAdapter) messages(k topicKey) []*message {
+	now := time.Now()
+	var msgs []*message
+	for _, msg := range m.topics[k] {
+		if !msg.expired(now) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// find returns the index of the message with the messageId or -1, the caller must hold the lock.
+func (m *MockAdapter) find(k topicKey, messageId []byte) int {
+	for i, msg := range m.topics[k] {
+		if bytes.Equal(msg.ID, messageId) {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert stores the message keeping messages of the topic ordered by time, a message
+// with the same messageId is replaced. The caller must hold the lock.
+func (m *MockAdapter) insert(k topicKey, msg *message) {
+	if i := m.find(k, msg.ID); i >= 0 {
+		m.remove(k, i)
+	}
+	msgs := m.topics[k]
+	i := sort.Search(len(msgs), func(i int) bool { return msgs[i].Timestamp.After(msg.Timestamp) })
+	msgs = append(msgs, nil)
+	copy(msgs[i+1:], msgs[i:])
+	msgs[i] = msg
+	m.topics[k] = msgs
+}
+
+// remove removes the message at index i of the topic, the caller must hold the lock.
+func (m *MockAdapter) remove(k topicKey, i int) {
+	msgs := m.topics[k]
+	m.topics[k] = append(msgs[:i], msgs[i+1:]...)
+	if len(m.topics[k]) == 0 {
+		delete(m.topics, k)
+	}
+}
+
+// put stores the message and returns the message as stored, the caller must hold the lock.
+func (m *MockAdapter) put(contract uint32, topic, payload []byte, o dbadapter.WriteOptions) (*message, error) {
+	if len(payload) == 0 {
+		return nil, dbadapter.ErrEmptyPayload
+	}
+	if o.QoS > 2 {
+		return nil, errors.New("mock adapter invalid qos, qos must be 0, 1 or 2")
+	}
+	if o.TTL < 0 {
+		return nil, errors.New("mock adapter invalid ttl, ttl must not be negative")
+	}
+	id := o.ID
+	if id == nil {
+		id = m.newID()
+	} else if err := checkMessageID(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ts := o.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+	msg := &message{Message: dbadapter.Message{ID: clone(id), Timestamp: ts, Qos: o.QoS, Payload: clone(payload)}}
+	if o.TTL > 0 {
+		msg.expiry = now.Add(o.TTL)
+	}
+	m.insert(key(contract, topic), msg)
+	return msg, nil
+}
+
+// written calls the write hooks and notifies watchers of the messages written to the topic.
+// It must be called without holding the lock.
+func (m *MockAdapter) written(contract uint32, topic []byte, msgs ...*message) {
+	m.mu.Lock()
+	hooks := m.onWrite
+	k := key(contract, topic)
+	var chans []chan dbadapter.Message
+	for w := range m.watchers {
+		if w.key == k {
+			chans = append(chans, w.ch)
+		}
+	}
+	for _, msg := range msgs {
+		for _, ch := range chans {
+			select {
+			case ch <- copyMessage(msg):
+			default:
+			}
+		}
+	}
+	m.mu.Unlock()
+	for _, msg := range msgs {
+		for _, hook := range hooks {
+			hook(contract, topic, clone(msg.ID), clone(msg.Payload))
+		}
+	}
+}
+
+// Open opens the adapter, the config is ignored. Data written before the adapter was
+// closed is kept.
+func (m *MockAdapter) Open(config string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.errs["Open"]; err != nil {
+		return err
+	}
+	if m.open {
+		return dbadapter.ErrAlreadyOpen
+	}
+	m.open = true
+	return nil
+}
+
+// Close closes the adapter and the channels of watchers.
+func (m *MockAdapter) Close() error {
+	return m.CloseContext(context.Background())
+}
+
+// CloseContext closes the adapter like Close.
+func (m *MockAdapter) CloseContext(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.errs["CloseContext"]; err != nil {
+		return err
+	}
+	if err := m.errs["Close"]; err != nil {
+		return err
+	}
+	m.open = false
+	for w := range m.watchers {
+		delete(m.watchers, w)
+		close(w.ch)
+	}
+	return nil
+}
+
+// IsOpen returns true if the adapter is open.
+func (m *MockAdapter) IsOpen() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.open
+}
+
+// Ping returns dbadapter.ErrClosed if the adapter is closed.
+func (m *MockAdapter) Ping() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.check("Ping")
+}
+
+// Version returns 2 if the adapter is open and -1 if it is closed.
+func (m *MockAdapter) Version() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.open {
+		return -1
+	}
+	return mockVersion
+}
+
+// GetName returns "mock".
+func (m *MockAdapter) GetName() string {
+	return adapterName
+}
+
+// RegisterMetrics does not register metrics.
+func (m *MockAdapter) RegisterMetrics(r metrics.Metrics) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errs["RegisterMetrics"]
+}
+
+// SetLogger sets the logger, the adapter does not log.
+func (m *MockAdapter) SetLogger(l dbadapter.Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logger = l
+}
+
+// SetTracer sets the tracer, the adapter does not trace.
+func (m *MockAdapter) SetTracer(t dbadapter.Tracer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tracer = t
+}
+
+// OnWrite registers a hook called after each successful write.
+func (m *MockAdapter) OnWrite(hook func(contract uint32, topic, messageId, payload []byte)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onWrite = append(m.onWrite, hook)
+}
+
+// OnExpire registers a hook called for each expired message removed by PurgeExpired.
+func (m *MockAdapter) OnExpire(hook func(contract uint32, topic, messageId []byte)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExpire = append(m.onExpire, hook)
+}
+
+// Put stores the message.
+func (m *MockAdapter) Put(contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	_, err := m.write("Put", contract, topic, payload, dbadapter.NewWriteOptions(opts...))
+	return err
+}
+
+// PutContext stores the message, it returns the context error if the context is done.
+func (m *MockAdapter) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := m.write("PutContext", contract, topic, payload, dbadapter.NewWriteOptions(opts...))
+	return err
+}
+
+// PutWithTTL stores the message that expires after the ttl.
+func (m *MockAdapter) PutWithTTL(contract uint32, topic, payload []byte, ttl time.Duration) error {
+	_, err := m.write("PutWithTTL", contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithTTL(ttl)))
+	return err
+}
+
+// PutReturningID stores the message and returns its messageId.
+func (m *MockAdapter) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	return m.write("PutReturningID", contract, topic, payload, dbadapter.WriteOptions{})
+}
+
+// PutAt stores the message with the timestamp and returns its messageId.
+func (m *MockAdapter) PutAt(contract uint32, topic, payload []byte, ts time.Time) ([]byte, error) {
+	return m.write("PutAt", contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithTimestamp(ts)))
+}
+
+// PutWithQoS stores the message with the QoS level.
+func (m *MockAdapter) PutWithQoS(contract uint32, topic, payload []byte, qos uint8) error {
+	_, err := m.write("PutWithQoS", contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithQoS(qos)))
+	return err
+}
+
+// PutWithID stores the message using the messageId.
+func (m *MockAdapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	if messageId == nil {
+		return dbadapter.ErrInvalidMessageID
+	}
+	_, err := m.write("PutWithID", contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithID(messageId)))
+	return err
+}
+
+// write stores the message and returns its messageId.
+func (m *MockAdapter) write(method string, contract uint32, topic, payload []byte, o dbadapter.WriteOptions) ([]byte, error) {
+	m.mu.Lock()
+	if err := m.checkTopic(method, topic); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	msg, err := m.put(contract, topic, payload, o)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.written(contract, topic, msg)
+	return clone(msg.ID), nil
+}
+
+// PutIfAbsent stores the message using the messageId unless it is already stored for the topic.
+func (m *MockAdapter) PutIfAbsent(contract uint32, topic, messageId, payload []byte) (bool, error) {
+	m.mu.Lock()
+	if err := m.checkTopic("PutIfAbsent", topic); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	if err := checkMessageID(messageId); err != nil {
+		m.mu.Unlock()
+		return false, err
+	}
+	if m.find(key(contract, topic), messageId) >= 0 {
+		m.mu.Unlock()
+		return false, nil
+	}
+	msg, err := m.put(contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithID(messageId)))
+	m.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	m.written(contract, topic, msg)
+	return true, nil
+}
+
+// CompareAndSwap stores the message if the messageId of the most recent message of the
+// topic equals expectedId, a nil expectedId means the topic has no messages.
+func (m *MockAdapter) CompareAndSwap(contract uint32, topic, expectedId, payload []byte) ([]byte, bool, error) {
+	m.mu.Lock()
+	if err := m.checkTopic("CompareAndSwap", topic); err != nil {
+		m.mu.Unlock()
+		return nil, false, err
+	}
+	var o dbadapter.WriteOptions
+	var lastId []byte
+	if msgs := m.messages(key(contract, topic)); len(msgs) > 0 {
+		last := msgs[len(msgs)-1]
+		lastId = last.ID
+		if now := time.Now(); !now.After(last.Timestamp) {
+			// keep the new message the most recent message of the topic
+			o.Timestamp = last.Timestamp.Add(time.Nanosecond)
+		}
+	}
+	if !bytes.Equal(lastId, expectedId) {
+		m.mu.Unlock()
+		return nil, false, nil
+	}
+	msg, err := m.put(contract, topic, payload, o)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	m.written(contract, topic, msg)
+	return clone(msg.ID), true, nil
+}
+
+// Increment adds delta to the counter of the topic and returns the new value.
+func (m *MockAdapter) Increment(contract uint32, topic []byte, delta int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("Increment", topic); err != nil {
+		return 0, err
+	}
+	k := key(contract, topic)
+	m.counters[k] += delta
+	return m.counters[k], nil
+}
+
+// Truncate removes all data keeping the adapter open.
+func (m *MockAdapter) Truncate() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Truncate"); err != nil {
+		return err
+	}
+	m.reset()
+	return nil
+}
+
+// BatchPut stores the messages atomically.
+func (m *MockAdapter) BatchPut(contract uint32, topic []byte, payloads [][]byte) error {
+	_, err := m.batchPut("BatchPut", contract, topic, payloads, true)
+	return err
+}
+
+// BatchPutResult stores the messages and returns their messageIds by index of the payload.
+func (m *MockAdapter) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	return m.batchPut("BatchPutResult", contract, topic, payloads, atomic)
+}
+
+func (m *MockAdapter) batchPut(method string, contract uint32, topic []byte, payloads [][]byte, atomic bool) ([][]byte, error) {
+	m.mu.Lock()
+	if err := m.checkTopic(method, topic); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	errs := make(dbadapter.BatchErrors)
+	for i, payload := range payloads {
+		if len(payload) == 0 {
+			errs[i] = dbadapter.ErrEmptyPayload
+		}
+	}
+	if atomic && len(errs) > 0 {
+		m.mu.Unlock()
+		return nil, errs
+	}
+	ids := make([][]byte, len(payloads))
+	var msgs []*message
+	for i, payload := range payloads {
+		if errs[i] != nil {
+			continue
+		}
+		msg, err := m.put(contract, topic, payload, dbadapter.WriteOptions{})
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		ids[i] = clone(msg.ID)
+		msgs = append(msgs, msg)
+	}
+	m.mu.Unlock()
+	m.written(contract, topic, msgs...)
+	if len(errs) > 0 {
+		return ids, errs
+	}
+	return ids, nil
+}
+
+// Get returns payloads of the last n messages of the topic oldest first, where n is
+// specified by limit argument.
+func (m *MockAdapter) Get(contract uint32, topic []byte, limit int) ([][]byte, error) {
+	return m.get("Get", contract, topic, limit)
+}
+
+// GetContext performs the query like Get, it returns the context error if the context is done.
+func (m *MockAdapter) GetContext(ctx context.Context, contract uint32, topic []byte, limit int) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.get("GetContext", contract, topic, limit)
+}
+
+// GetInto performs the query like Get and copies payloads into the buffers of dst.
+func (m *MockAdapter) GetInto(contract uint32, topic []byte, limit int, dst [][]byte) ([][]byte, error) {
+	matches, err := m.get("GetInto", contract, topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	dst = dst[:0]
+	for _, payload := range matches {
+		var buf []byte
+		if len(dst) < cap(dst) {
+			buf = dst[:len(dst)+1][len(dst)][:0]
+		}
+		dst = append(dst, append(buf, payload...))
+	}
+	return dst, nil
+}
+
+func (m *MockAdapter) get(method string, contract uint32, topic []byte, limit int) ([][]byte, error) {
+	msgs, err := m.last(method, contract, topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	matches := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		matches = append(matches, msg.Payload)
+	}
+	return matches, nil
+}
+
+// last returns copies of the last n messages of the topic oldest first.
+func (m *MockAdapter) last(method string, contract uint32, topic []byte, limit int) ([]dbadapter.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery(method, topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	msgs := m.messages(key(contract, topic))
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	matches := make([]dbadapter.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		matches = append(matches, copyMessage(msg))
+	}
+	return matches, nil
+}
+
+// GetLast returns the payload of the most recent message of the topic.
+func (m *MockAdapter) GetLast(contract uint32, topic []byte) ([]byte, bool, error) {
+	msgs, err := m.last("GetLast", contract, topic, 1)
+	if err != nil || len(msgs) == 0 {
+		return nil, false, err
+	}
+	return msgs[0].Payload, true, nil
+}
+
+// GetPage returns payloads of n messages of the topic after the cursor oldest first, the
+// cursor is the messageId of the last message of the previous page.
+func (m *MockAdapter) GetPage(contract uint32, topic, cursor []byte, limit int) ([][]byte, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery("GetPage", topic, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs := m.messages(key(contract, topic))
+	if cursor != nil {
+		i := 0
+		for i < len(msgs) && !bytes.Equal(msgs[i].ID, cursor) {
+			i++
+		}
+		if i == len(msgs) {
+			return nil, nil, dbadapter.ErrNotFound
+		}
+		msgs = msgs[i+1:]
+	}
+	var next []byte
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+		next = clone(msgs[limit-1].ID)
+	}
+	matches := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		matches = append(matches, clone(msg.Payload))
+	}
+	return matches, next, nil
+}
+
+// GetOffset returns payloads of n messages of the topic oldest first after skipping the first
+// offset messages.
+func (m *MockAdapter) GetOffset(contract uint32, topic []byte, offset, limit int) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery("GetOffset", topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, dbadapter.ErrInvalidLimit
+	}
+	msgs := m.messages(key(contract, topic))
+	if offset > len(msgs) {
+		offset = len(msgs)
+	}
+	msgs = msgs[offset:]
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	matches := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		matches = append(matches, clone(msg.Payload))
+	}
+	return matches, nil
+}
+
+// Stream sends payloads of messages of the topic oldest first to the returned channel.
+func (m *MockAdapter) Stream(ctx context.Context, contract uint32, topic []byte) (<-chan []byte, <-chan error) {
+	out := make(chan []byte)
+	errc := make(chan error, 1)
+	m.mu.Lock()
+	err := m.checkTopic("Stream", topic)
+	var payloads [][]byte
+	for _, msg := range m.messages(key(contract, topic)) {
+		payloads = append(payloads, clone(msg.Payload))
+	}
+	m.mu.Unlock()
+	go func() {
+		defer close(out)
+		defer close(errc)
+		if err != nil {
+			errc <- err
+			return
+		}
+		for _, payload := range payloads {
+			select {
+			case out <- payload:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc
+}
+
+// Watch returns a channel delivering messages written to the topic. Messages are dropped
+// once 64 messages are buffered.
+func (m *MockAdapter) Watch(ctx context.Context, contract uint32, topic []byte) (<-chan dbadapter.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("Watch", topic); err != nil {
+		return nil, err
+	}
+	w := &watcher{key: key(contract, topic), ch: make(chan dbadapter.Message, watchBuffer)}
+	m.watchers[w] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.watchers[w]; ok {
+			delete(m.watchers, w)
+			close(w.ch)
+		}
+	}()
+	return w.ch, nil
+}
+
+// GetMessages returns the last n messages of the topic oldest first.
+func (m *MockAdapter) GetMessages(contract uint32, topic []byte, limit int) ([]dbadapter.Message, error) {
+	return m.last("GetMessages", contract, topic, limit)
+}
+
+// GetOrdered returns payloads of n messages of the topic ordered by time, newest first if
+// desc is set.
+func (m *MockAdapter) GetOrdered(contract uint32, topic []byte, limit int, desc bool) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery("GetOrdered", topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	msgs := m.messages(key(contract, topic))
+	var matches [][]byte
+	for i := range msgs {
+		if len(matches) == limit {
+			break
+		}
+		msg := msgs[i]
+		if desc {
+			msg = msgs[len(msgs)-1-i]
+		}
+		matches = append(matches, clone(msg.Payload))
+	}
+	return matches, nil
+}
+
+// GetWildcard returns payloads of the last n messages of topics matching the MQTT-style
+// topic pattern, where levels are separated by '/', '+' matches a single level and '#'
+// matches any number of levels. Stored topics separate levels by '.'.
+func (m *MockAdapter) GetWildcard(contract uint32, topicPattern []byte, limit int) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery("GetWildcard", topicPattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*message
+	for k := range m.topics {
+		if k.contract == contract && matchTopic(strings.Split(string(topicPattern), "/"), strings.Split(k.topic, ".")) {
+			msgs = append(msgs, m.messages(k)...)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Timestamp.Before(msgs[j].Timestamp) })
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	matches := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		matches = append(matches, clone(msg.Payload))
+	}
+	return matches, nil
+}
+
+// matchTopic reports whether the topic levels match the pattern levels.
+func matchTopic(pattern, topic []string) bool {
+	for i, level := range pattern {
+		if level == "#" {
+			return true
+		}
+		if i == len(topic) || (level != "+" && level != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
+
+// GetRange returns payloads of the last n messages of the topic stored between from and until
+// times oldest first.
+func (m *MockAdapter) GetRange(contract uint32, topic []byte, from, until time.Time, limit int) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery("GetRange", topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	msgs := inRange(m.messages(key(contract, topic)), from, until)
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	matches := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		matches = append(matches, clone(msg.Payload))
+	}
+	return matches, nil
+}
+
+// inRange returns the messages stored between from and until times, both inclusive. A zero
+// until time means now.
+func inRange(msgs []*message, from, until time.Time) []*message {
+	if until.IsZero() {
+		until = time.Now()
+	}
+	var matches []*message
+	for _, msg := range msgs {
+		if !msg.Timestamp.Before(from) && !msg.Timestamp.After(until) {
+			matches = append(matches, msg)
+		}
+	}
+	return matches
+}
+
+// GetSince returns the first n messages of the topic stored after the since time oldest first.
+func (m *MockAdapter) GetSince(contract uint32, topic []byte, since time.Time, limit int) ([]dbadapter.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit, err := m.checkQuery("GetSince", topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	var matches []dbadapter.Message
+	for _, msg := range m.messages(key(contract, topic)) {
+		if len(matches) == limit {
+			break
+		}
+		if msg.Timestamp.After(since) {
+			matches = append(matches, copyMessage(msg))
+		}
+	}
+	return matches, nil
+}
+
+// CountRange returns number of messages of the topic stored between from and until times.
+func (m *MockAdapter) CountRange(contract uint32, topic []byte, from, until time.Time) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("CountRange", topic); err != nil {
+		return 0, err
+	}
+	return uint64(len(inRange(m.messages(key(contract, topic)), from, until))), nil
+}
+
+// ScanPrefix returns n messages of topics starting with the prefix ordered by topic.
+func (m *MockAdapter) ScanPrefix(contract uint32, prefix []byte, limit int) ([]dbadapter.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("ScanPrefix"); err != nil {
+		return nil, err
+	}
+	if limit < 1 {
+		return nil, dbadapter.ErrInvalidLimit
+	}
+	if limit > maxResults {
+		limit = maxResults
+	}
+	var matches []dbadapter.Message
+	for _, topic := range m.topicNames(contract) {
+		if !strings.HasPrefix(topic, string(prefix)) {
+			continue
+		}
+		for _, msg := range m.messages(topicKey{contract: contract, topic: topic}) {
+			if len(matches) == limit {
+				return matches, nil
+			}
+			matches = append(matches, copyMessage(msg))
+		}
+	}
+	return matches, nil
+}
+
+// GetMulti returns payloads of the last n messages of each topic keyed by topic.
+func (m *MockAdapter) GetMulti(contract uint32, topics [][]byte, limit int) (map[string][][]byte, error) {
+	m.mu.Lock()
+	err := m.check("GetMulti")
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[string][][]byte, len(topics))
+	errs := make(dbadapter.TopicErrors)
+	for _, topic := range topics {
+		msgs, err := m.get("GetMulti", contract, topic, limit)
+		if err != nil {
+			errs[string(topic)] = err
+			continue
+		}
+		matches[string(topic)] = msgs
+	}
+	if len(errs) > 0 {
+		return matches, errs
+	}
+	return matches, nil
+}
+
+// Count returns number of messages stored for the topic.
+func (m *MockAdapter) Count(contract uint32, topic []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("Count", topic); err != nil {
+		return 0, err
+	}
+	return uint64(len(m.messages(key(contract, topic)))), nil
+}
+
+// Exists checks if a message with the messageId is stored for the topic.
+func (m *MockAdapter) Exists(contract uint32, topic, messageId []byte) (bool, error) {
+	_, ok, err := m.getByID("Exists", contract, topic, messageId)
+	return ok, err
+}
+
+// GetByID returns the payload of the message with the messageId.
+func (m *MockAdapter) GetByID(contract uint32, topic, messageId []byte) ([]byte, bool, error) {
+	return m.getByID("GetByID", contract, topic, messageId)
+}
+
+func (m *MockAdapter) getByID(method string, contract uint32, topic, messageId []byte) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic(method, topic); err != nil {
+		return nil, false, err
+	}
+	for _, msg := range m.messages(key(contract, topic)) {
+		if bytes.Equal(msg.ID, messageId) {
+			return clone(msg.Payload), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// topicNames returns sorted topics of the contract with messages, the caller must hold the lock.
+func (m *MockAdapter) topicNames(contract uint32) []string {
+	var names []string
+	for k := range m.topics {
+		if k.contract == contract && len(m.messages(k)) > 0 {
+			names = append(names, k.topic)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Topics returns sorted topics that have at least one message stored under the contract.
+func (m *MockAdapter) Topics(contract uint32) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Topics"); err != nil {
+		return nil, err
+	}
+	topics := [][]byte{}
+	for _, name := range m.topicNames(contract) {
+		topics = append(topics, []byte(name))
+	}
+	return topics, nil
+}
+
+// Contracts returns sorted contracts that have at least one message stored.
+func (m *MockAdapter) Contracts(limit int) ([]uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Contracts"); err != nil {
+		return nil, err
+	}
+	seen := make(map[uint32]bool)
+	contracts := []uint32{}
+	for k := range m.topics {
+		if !seen[k.contract] && len(m.messages(k)) > 0 {
+			seen[k.contract] = true
+			contracts = append(contracts, k.contract)
+		}
+	}
+	sort.Slice(contracts, func(i, j int) bool { return contracts[i] < contracts[j] })
+	if limit > 0 && len(contracts) > limit {
+		contracts = contracts[:limit]
+	}
+	return contracts, nil
+}
+
+// NextSeq returns the next sequence number of the topic starting at one.
+func (m *MockAdapter) NextSeq(contract uint32, topic []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("NextSeq", topic); err != nil {
+		return 0, err
+	}
+	k := key(contract, topic)
+	m.seqs[k]++
+	return m.seqs[k], nil
+}
+
+// Touch resets the TTL of the message, it returns dbadapter.ErrNotFound if the message was not found.
+func (m *MockAdapter) Touch(contract uint32, topic, messageId []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("Touch", topic); err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return errors.New("mock adapter invalid ttl, ttl must not be negative")
+	}
+	for _, msg := range m.messages(key(contract, topic)) {
+		if bytes.Equal(msg.ID, messageId) {
+			msg.expiry = time.Time{}
+			if ttl > 0 {
+				msg.expiry = time.Now().Add(ttl)
+			}
+			return nil
+		}
+	}
+	return dbadapter.ErrNotFound
+}
+
+// NewID returns a new 16 bytes long messageId.
+func (m *MockAdapter) NewID() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("NewID"); err != nil {
+		return nil, err
+	}
+	return m.newID(), nil
+}
+
+// Delete deletes the message, it is not an error if the message was not found.
+func (m *MockAdapter) Delete(contract uint32, messageId, topic []byte) error {
+	return m.delete("Delete", contract, messageId, topic)
+}
+
+// DeleteContext deletes the message, it returns the context error if the context is done.
+func (m *MockAdapter) DeleteContext(ctx context.Context, contract uint32, messageId, topic []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.delete("DeleteContext", contract, messageId, topic)
+}
+
+func (m *MockAdapter) delete(method string, contract uint32, messageId, topic []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic(method, topic); err != nil {
+		return err
+	}
+	if err := checkMessageID(messageId); err != nil {
+		return err
+	}
+	k := key(contract, topic)
+	if i := m.find(k, messageId); i >= 0 {
+		m.remove(k, i)
+	}
+	return nil
+}
+
+// BatchDelete deletes the messages, malformed messageIds are reported in BatchErrors.
+func (m *MockAdapter) BatchDelete(contract uint32, topic []byte, messageIds [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("BatchDelete", topic); err != nil {
+		return err
+	}
+	errs := make(dbadapter.BatchErrors)
+	k := key(contract, topic)
+	for i, messageId := range messageIds {
+		if err := checkMessageID(messageId); err != nil {
+			errs[i] = err
+			continue
+		}
+		if j := m.find(k, messageId); j >= 0 {
+			m.remove(k, j)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// DeleteByTopic deletes all messages of the topic and returns number of messages deleted.
+func (m *MockAdapter) DeleteByTopic(contract uint32, topic []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTopic("DeleteByTopic", topic); err != nil {
+		return 0, err
+	}
+	k := key(contract, topic)
+	deleted := len(m.messages(k))
+	delete(m.topics, k)
+	return deleted, nil
+}
+
+// DropContract deletes all messages, sequences and counters of the contract and returns
+// number of messages deleted.
+func (m *MockAdapter) DropContract(contract uint32) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("DropContract"); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for k := range m.topics {
+		if k.contract == contract {
+			deleted += len(m.messages(k))
+			delete(m.topics, k)
+		}
+	}
+	for k := range m.seqs {
+		if k.contract == contract {
+			delete(m.seqs, k)
+		}
+	}
+	for k := range m.counters {
+		if k.contract == contract {
+			delete(m.counters, k)
+		}
+	}
+	return deleted, nil
+}
+
+// PurgeExpired deletes expired messages of the topic, or of all topics of the contract if
+// topic is nil, and calls the expire hooks for them.
+func (m *MockAdapter) PurgeExpired(contract uint32, topic []byte) (int, error) {
+	type expired struct {
+		topic, id []byte
+	}
+	m.mu.Lock()
+	if err := m.check("PurgeExpired"); err != nil {
+		m.mu.Unlock()
+		return 0, err
+	}
+	if topic != nil && len(topic) == 0 {
+		m.mu.Unlock()
+		return 0, dbadapter.ErrEmptyTopic
+	}
+	now := time.Now()
+	var purged []expired
+	for k, msgs := range m.topics {
+		if k.contract != contract || (topic != nil && k != key(contract, topic)) {
+			continue
+		}
+		kept := msgs[:0]
+		for _, msg := range msgs {
+			if msg.expired(now) {
+				purged = append(purged, expired{topic: []byte(k.topic), id: msg.ID})
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		m.topics[k] = kept
+		if len(kept) == 0 {
+			delete(m.topics, k)
+		}
+	}
+	hooks := m.onExpire
+	m.mu.Unlock()
+	for _, e := range purged {
+		for _, hook := range hooks {
+			hook(contract, e.topic, e.id)
+		}
+	}
+	return len(purged), nil
+}
+
+// Sync returns dbadapter.ErrClosed if the adapter is closed.
+func (m *MockAdapter) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.check("Sync")
+}
+
+// Compact returns dbadapter.ErrClosed if the adapter is closed.
+func (m *MockAdapter) Compact() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.check("Compact")
+}
+
+// Stats returns number of messages stored and the version, the size is zero.
+func (m *MockAdapter) Stats() (dbadapter.Stats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Stats"); err != nil {
+		return dbadapter.Stats{}, err
+	}
+	var count uint64
+	for k := range m.topics {
+		count += uint64(len(m.messages(k)))
+	}
+	return dbadapter.Stats{Count: count, Version: mockVersion}, nil
+}
+
+// FileSize returns zero as the adapter has no files.
+func (m *MockAdapter) FileSize() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return 0, m.check("FileSize")
+}
+
+// Migrate does nothing, the adapter is always up to date.
+func (m *MockAdapter) Migrate() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.check("Migrate")
+}
+
+// Backup writes all messages to w in the format written by ExportJSON.
+func (m *MockAdapter) Backup(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Backup"); err != nil {
+		return 0, err
+	}
+	cw := &countWriter{w: w}
+	contracts := make(map[uint32]struct{})
+	for k := range m.topics {
+		contracts[k.contract] = struct{}{}
+	}
+	for contract := range contracts {
+		if err := m.export(contract, cw); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Restore replaces all messages with the messages read from r, it is rejected if
+// messages are stored unless force is set.
+func (m *MockAdapter) Restore(r io.Reader, force bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Restore"); err != nil {
+		return err
+	}
+	if len(m.topics) > 0 && !force {
+		return errors.New("mock adapter restore into a non-empty adapter, set force to overwrite")
+	}
+	m.reset()
+	_, _, err := m.importJSON(r, false)
+	return err
+}
+
+// ExportJSON writes all messages of the contract to w, one JSON record per line.
+func (m *MockAdapter) ExportJSON(contract uint32, w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("ExportJSON"); err != nil {
+		return err
+	}
+	return m.export(contract, w)
+}
+
+// export writes messages of the contract to w, the caller must hold the lock.
+func (m *MockAdapter) export(contract uint32, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, topic := range m.topicNames(contract) {
+		for _, msg := range m.messages(topicKey{contract: contract, topic: topic}) {
+			if err := enc.Encode(record{Contract: contract, Topic: topic, ID: msg.ID, Payload: msg.Payload, Timestamp: msg.Timestamp}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportJSON stores messages read from r in the format written by ExportJSON.
+func (m *MockAdapter) ImportJSON(r io.Reader, skipMalformed bool) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("ImportJSON"); err != nil {
+		return 0, 0, err
+	}
+	return m.importJSON(r, skipMalformed)
+}
+
+// importJSON stores messages read from r, the caller must hold the lock.
+func (m *MockAdapter) importJSON(r io.Reader, skipMalformed bool) (imported, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<26)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil || rec.Topic == "" || checkMessageID(rec.ID) != nil || len(rec.Payload) == 0 {
+			if skipMalformed {
+				skipped++
+				continue
+			}
+			return imported, skipped, errors.New("mock adapter malformed record")
+		}
+		m.insert(topicKey{contract: rec.Contract, topic: rec.Topic}, &message{Message: dbadapter.Message{ID: rec.ID, Timestamp: rec.Timestamp, Payload: rec.Payload}})
+		imported++
+	}
+	return imported, skipped, scanner.Err()
+}
+
+// Append appends the message to the buffer written to the log by Write, a message appended
+// with delFlag set removes the key from the buffer.
+func (m *MockAdapter) Append(delFlag bool, k uint64, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Append"); err != nil {
+		return err
+	}
+	if delFlag {
+		delete(m.pending, k)
+		return nil
+	}
+	m.pending[k] = clone(data)
+	return nil
+}
+
+// PutMessage stores the message of the block.
+func (m *MockAdapter) PutMessage(blockId, key uint64, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("PutMessage"); err != nil {
+		return err
+	}
+	if m.blocks[blockId] == nil {
+		m.blocks[blockId] = make(map[uint64][]byte)
+	}
+	m.blocks[blockId][key] = clone(payload)
+	return nil
+}
+
+// GetMessage returns the message of the block, it returns dbadapter.ErrNotFound if the message
+// was not found.
+func (m *MockAdapter) GetMessage(blockId, key uint64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("GetMessage"); err != nil {
+		return nil, err
+	}
+	payload, ok := m.blocks[blockId][key]
+	if !ok {
+		return nil, dbadapter.ErrNotFound
+	}
+	return clone(payload), nil
+}
+
+// Keys returns sorted keys of messages of the block.
+func (m *MockAdapter) Keys(blockId uint64) []uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []uint64
+	for key := range m.blocks[blockId] {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// DeleteMessage deletes the message of the block.
+func (m *MockAdapter) DeleteMessage(blockId, key uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("DeleteMessage"); err != nil {
+		return err
+	}
+	delete(m.blocks[blockId], key)
+	return nil
+}
+
+// Write writes the appended messages to the log.
+func (m *MockAdapter) Write() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Write"); err != nil {
+		return err
+	}
+	for k, data := range m.pending {
+		m.log[k] = data
+	}
+	m.pending = make(map[uint64][]byte)
+	return nil
+}
+
+// Recovery returns the messages written to the log, the log is cleared if reset is set.
+func (m *MockAdapter) Recovery(reset bool) (map[uint64][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check("Recovery"); err != nil {
+		return nil, err
+	}
+	msgs := make(map[uint64][]byte, len(m.log))
+	for k, data := range m.log {
+		msgs[k] = clone(data)
+	}
+	if reset {
+		m.log = make(map[uint64][]byte)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,90 @@
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// lastReading is the code under test, it returns the last reading of the sensor or an
+// empty reading if the store is unavailable.
+func lastReading(adp dbadapter.Adapter, sensor string) (string, error) {
+	payload, ok, err := adp.GetLast(3376684800, []byte("sensors."+sensor))
+	switch {
+	case errors.Is(err, dbadapter.ErrClosed):
+		return "", nil
+	case err != nil:
+		return "", err
+	case !ok:
+		return "none", nil
+	}
+	return string(payload), nil
+}
+
+func ExampleMockAdapter() {
+	adp := NewMockAdapter()
+	adp.Put(3376684800, []byte("sensors.temp"), []byte("21.5"))
+	fmt.Println(lastReading(adp, "temp"))
+	fmt.Println(lastReading(adp, "humidity"))
+
+	adp.SetError("GetLast", errors.New("disk failure"))
+	fmt.Println(lastReading(adp, "temp"))
+
+	adp.SetError("GetLast", nil)
+	adp.Close()
+	fmt.Println(lastReading(adp, "temp"))
+	// Output:
+	// 21.5 <nil>
+	// none <nil>
+	//  disk failure
+	//  <nil>
+}
+
+func TestMockAdapter(t *testing.T) {
+	adp := NewMockAdapter()
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+
+	var written int
+	adp.OnWrite(func(contract uint32, topic, messageId, payload []byte) { written++ })
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, adp.Put(contract, topic, []byte(fmt.Sprintf("msg%d", i))))
+	}
+	id, err := adp.PutReturningID(contract, topic, []byte("msg3"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, written)
+
+	matches, err := adp.Get(contract, topic, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg2"), []byte("msg3")}, matches)
+	ok, err := adp.Exists(contract, topic, id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, adp.Delete(contract, id, topic))
+	count, err := adp.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	assert.True(t, errors.Is(adp.Put(contract, nil, []byte("msg")), dbadapter.ErrEmptyTopic))
+	_, err = adp.Get(contract, topic, 0)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidLimit))
+	assert.True(t, errors.Is(adp.Touch(contract, topic, id, 0), dbadapter.ErrNotFound))
+
+	// injected errors are returned until cleared
+	adp.SetError("Put", dbadapter.ErrReadOnly)
+	assert.True(t, errors.Is(adp.Put(contract, topic, []byte("msg")), dbadapter.ErrReadOnly))
+	adp.SetError("Put", nil)
+	assert.NoError(t, adp.Put(contract, topic, []byte("msg")))
+
+	// data is kept across Close and Open
+	assert.NoError(t, adp.Close())
+	_, err = adp.Count(contract, topic)
+	assert.True(t, errors.Is(err, dbadapter.ErrClosed))
+	assert.NoError(t, adp.Open(""))
+	count, err = adp.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), count)
+}
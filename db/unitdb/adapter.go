@@ -1,176 +1,1728 @@
 package adapter
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/unit-io/bpool"
-	"github.com/unit-io/unitd/pkg/log"
+	dbadapter "github.com/unit-io/unitd/db"
 	"github.com/unit-io/unitd/store"
 	"github.com/unit-io/unitdb"
 	"github.com/unit-io/unitdb/memdb"
 	"github.com/unit-io/unitdb/wal"
 )
 
+var _ dbadapter.Adapter = (*adapter)(nil)
+
 const (
 	defaultDatabase     = "unitd"
 	defaultMessageStore = "messages"
 
-	dbVersion = 2.0
+	// dbVersion is the on-disk format version, it is bumped whenever the message envelope
+	// layout changes. Version 1 databases store raw payloads.
+	dbVersion = 4.0
+
+	adapterName = "unitdb"
+
+	logPostfix = ".log"
+
+	// Default permissions of the db dir and the value dir
+	defaultDirPerm = 0700
+
+	// Maximum QoS level of a message
+	maxQoS = 2
+
+	// Default wait before first retry of a failed write
+	defaultRetryBackoff = 10 * time.Millisecond
+
+	// Maximum wait between retries of a failed write, retries wait holding the adapter
+	// read lock so the wait is capped to not hold up Close and Backup.
+	maxRetryBackoff = 100 * time.Millisecond
+
+	// Default number of topics queried concurrently by GetMulti
+	defaultGetMultiWorkers = 8
+
+	// ID modes
+	idModeDefault  = "default"
+	idModeSequence = "sequence"
+)
+
+// Options represents the adapter configuration, it mirrors the JSON config of the adapter.
+// Go callers can open the adapter with Options using OpenWithOptions instead of marshaling
+// the JSON config. Dir is required, there is no default location for the database so that
+// a missing dir cannot silently put data in the working directory. With InMemory the
+// database is opened in a new temp dir, under Dir if it is set, and the dir is removed
+// on close, so nothing is kept across Close and Open.
+type Options struct {
+	Dir               string `json:"dir,omitempty"`
+	ValueDir          string `json:"value_dir,omitempty"`
+	Size              int64  `json:"mem_size"`
+	LogReleaseDur     string `json:"log_release_duration,omitempty"`
+	MaxResults        int    `json:"max_results,omitempty"`
+	MaxTTL            string `json:"max_ttl,omitempty"`
+	DefaultTTL        string `json:"default_ttl,omitempty"`
+	MaxTimestampSkew  string `json:"max_timestamp_skew,omitempty"`
+	EncryptionKey     string `json:"encryption_key,omitempty"`
+	ReadOnly          bool   `json:"read_only,omitempty"`
+	DirPerm           string `json:"dir_perm,omitempty"`
+	Name              string `json:"database,omitempty"`
+	WriteRetries      int    `json:"write_retries,omitempty"`
+	WriteRetryBackoff string `json:"write_retry_backoff,omitempty"`
+	Compression       string `json:"compression,omitempty"`
+	IDMode            string `json:"id_mode,omitempty"`
+	SyncWrites        bool   `json:"sync_writes,omitempty"`
+	AutoReconnect     bool   `json:"auto_reconnect,omitempty"`
+	Shards            int    `json:"shards,omitempty"`
+	GetMultiWorkers   int    `json:"get_multi_workers,omitempty"`
+	AllowEmptyPayload bool   `json:"allow_empty_payload,omitempty"`
+	MaxPayloadBytes   int    `json:"max_payload_bytes,omitempty"`
+	MaxTopicBytes     int    `json:"max_topic_bytes,omitempty"`
+	WatchBuffer       int    `json:"watch_buffer,omitempty"`
+	WatchPolicy       string `json:"watch_policy,omitempty"`
+	InMemory          bool   `json:"in_memory,omitempty"`
+}
+
+type configType struct {
+	Options
+	dur          time.Duration
+	maxTTL       time.Duration
+	defaultTTL   time.Duration
+	maxSkew      time.Duration
+	retryBackoff time.Duration
+	dirPerm      os.FileMode
+	codec        uint8
+}
+
+const (
+	// Default maximum number of records to return
+	maxResults = 1024
+	// Default maximum TTL for message
+	maxTTL = "24h"
+)
+
+var (
+	// maxTTLDur is maxTTL parsed once at init.
+	maxTTLDur time.Duration
+)
+
+// Store represents an SSD-optimized storage store.
+type adapter struct {
+	db         *unitdb.DB   // The underlying database to store messages, it is the first shard and holds metadata.
+	shards     []*unitdb.DB // Databases of shards other than the first shard.
+	mem        *memdb.DB    // The underlying memdb to store messages.
+	config     *configType
+	writeLockC chan struct{}
+	bufPool    *bpool.BufferPool
+	//tiny Batch
+	tinyBatch *tinyBatch
+	wal       *wal.WAL
+	version   int
+	// idSize is the size of messageIds generated by the database.
+	idSize int
+
+	meter  *meter
+	logger dbadapter.Logger
+	tracer dbadapter.Tracer
+
+	// index tracks topics written under each contract.
+	index *topicIndex
+	// seqs holds sequence counters of topics in sequence ID mode.
+	seqs *sequences
+	// expiring holds messageIds of expired messages being deleted.
+	expiring *expiring
+	// counters holds counters of topics.
+	counters *counters
+	// watchers holds subscriptions to messages written to topics.
+	watchers *watchers
+	// hooks holds callbacks registered by callers.
+	hooks hooks
+	// topicLocks serializes writes of topics with conditional writes.
+	topicLocks topicLocks
+
+	// reconnecting is set while the database is reopened after a failure.
+	reconnecting int32
+	// lastReconnect is the time the database was last reopened after a failure.
+	lastReconnect time.Time
+
+	// now returns the current time used to expire messages.
+	now func() time.Time
+
+	// putEntry writes the entry to the database, tests replace it to inject write failures.
+	putEntry func(db *unitdb.DB, entry *unitdb.Entry) error
+
+	// mu guards the adapter state, Open and Close take the write lock and operations on the
+	// database take the read lock. Backup takes the write lock while it copies the database
+	// files, so reads and writes of the database are blocked until the files are copied.
+	mu sync.RWMutex
+
+	// close
+	closer io.Closer
+
+	// tempDir is the dir created for an in memory database, it is removed on close.
+	tempDir string
+}
+
+// Open initializes database connection
+func (a *adapter) Open(jsonconfig string) error {
+	var opts Options
+	dec := json.NewDecoder(strings.NewReader(jsonconfig))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&opts); err != nil {
+		return errors.New("unitdb adapter failed to parse config: " + err.Error())
+	}
+	return a.OpenWithOptions(opts)
+}
+
+// OpenWithOptions initializes database connection using the options. The adapter is
+// registered with store, so callers holding the store adapter reach it by asserting
+// the adapter to interface{ OpenWithOptions(Options) error }.
+func (a *adapter) OpenWithOptions(opts Options) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db != nil {
+		return fmt.Errorf("%w: unitdb adapter is already connected", dbadapter.ErrAlreadyOpen)
+	}
+
+	var err error
+	config := configType{Options: opts}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.InMemory {
+		// the database is opened in a temp dir under dir, it is removed on close
+		if config.Dir, err = ioutil.TempDir(config.Dir, "unitdb-"); err != nil {
+			return fmt.Errorf("unitdb adapter failed to create in memory db dir: %w", err)
+		}
+		a.tempDir = config.Dir
+		a.logger.Debug("adapter.Open", "Opening in memory db in "+config.Dir)
+	}
+
+	// Make sure we have a directory
+	if err := os.MkdirAll(config.Dir, config.dirPerm); err != nil {
+		a.logger.Error("adapter.Open", "Unable to create db dir")
+		return fmt.Errorf("unitdb adapter failed to create db dir: %w", err)
+	}
+
+	// Value dir falls back to the db dir if it is not set
+	if config.ValueDir == "" {
+		config.ValueDir = config.Dir
+	} else if config.ValueDir != config.Dir {
+		if err := os.MkdirAll(config.ValueDir, config.dirPerm); err != nil {
+			return fmt.Errorf("unitdb adapter failed to create value dir: %w", err)
+		}
+	}
+
+	// Attempt to open the database
+	a.config = &config
+	a.db, err = openDB(&config)
+	if err != nil {
+		a.db = nil
+		a.logger.Error("adapter.Open", "Unable to open db")
+		a.removeTempDir()
+		return err
+	}
+	if err := a.checkVersion(); err != nil {
+		return a.abortOpen(err)
+	}
+	a.idSize = len(a.db.NewID())
+	if err := a.checkShards(); err != nil {
+		return a.abortOpen(err)
+	}
+	if a.shards, err = openShards(&config); err != nil {
+		return a.abortOpen(err)
+	}
+	// Attempt to open the memdb
+	a.mem, err = memdb.Open(config.Size, &memdb.Options{MaxElapsedTime: 2 * time.Second})
+	if err != nil {
+		a.mem = nil
+		a.logger.Error("adapter.Open", "Unable to open memdb")
+		return a.abortOpen(err)
+	}
+	a.logger.Debug("adapter.Open", "Opened db "+filepath.Join(config.Dir, config.Name))
+
+	a.bufPool = bpool.NewBufferPool(config.Size, nil)
+	a.tinyBatch.buffer = a.bufPool.Get()
+	return nil
+}
+
+// abortOpen closes the databases opened by a failed Open and resets the adapter, so the
+// adapter can be opened again. It returns err.
+func (a *adapter) abortOpen(err error) error {
+	closeShards(a.shards)
+	a.db.Close()
+	a.db, a.shards, a.version = nil, nil, -1
+	a.index.reset()
+	a.seqs.reset()
+	a.counters.reset()
+	a.removeTempDir()
+	return err
+}
+
+// removeTempDir removes the dir of an in memory database, the caller must hold the lock.
+func (a *adapter) removeTempDir() error {
+	if a.tempDir == "" {
+		return nil
+	}
+	dir := a.tempDir
+	a.tempDir = ""
+	return os.RemoveAll(dir)
+}
+
+// resolvePath expands a leading ~ to the user home dir and returns the absolute path.
+func resolvePath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
+// openDB opens the underlying database using the config.
+func openDB(config *configType) (*unitdb.DB, error) {
+	opts := []unitdb.Options{unitdb.WithMutable(), unitdb.WithLogFilePath(config.ValueDir)}
+	if config.EncryptionKey != "" {
+		opts = append(opts, unitdb.WithEncryption(), unitdb.WithEncryptionKey([]byte(config.EncryptionKey)))
+	}
+	return unitdb.Open(filepath.Join(config.Dir, config.Name), nil, opts...)
+}
+
+// Close closes the underlying database connection. Close is idempotent, closing an adapter
+// that is already closed or was never opened returns nil.
+func (a *adapter) Close() error {
+	return a.CloseContext(context.Background())
+}
+
+// CloseContext closes the underlying database connection. The adapter is marked closed
+// before the database is closed, so if the context is done before the close completes
+// CloseContext returns the context error and the close continues in background.
+func (a *adapter) CloseContext(ctx context.Context) error {
+	// cancel watchers first, so writers blocked on slow watchers release the read lock
+	a.watchers.cancel()
+	a.mu.Lock()
+	db, shards, mem, closer, tempDir := a.db, a.shards, a.mem, a.closer, a.tempDir
+	a.db, a.shards, a.mem, a.closer, a.tempDir = nil, nil, nil, nil, ""
+	a.version = -1
+	if db != nil {
+		a.index.reset()
+		a.seqs.reset()
+		a.counters.reset()
+	}
+	a.mu.Unlock()
+
+	if db == nil && mem == nil && closer == nil {
+		// already closed
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if db != nil {
+			err = db.Close()
+		}
+		if err1 := closeShards(shards); err == nil {
+			err = err1
+		}
+		if mem != nil {
+			if err1 := mem.Close(); err == nil {
+				err = err1
+			}
+		}
+		if closer != nil {
+			if err1 := closer.Close(); err == nil {
+				err = err1
+			}
+		}
+		if tempDir != "" {
+			if err1 := os.RemoveAll(tempDir); err == nil {
+				err = err1
+			}
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsOpen returns true if connection to database has been established. It does not check if
+// connection is actually live.
+func (a *adapter) IsOpen() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.db != nil
+}
+
+// rlock takes the read lock of the adapter state, it returns ErrClosed without
+// holding the lock if the database is not open.
+func (a *adapter) rlock() error {
+	a.mu.RLock()
+	if a.db == nil {
+		a.mu.RUnlock()
+		return dbadapter.ErrClosed
+	}
+	return nil
+}
+
+// Ping checks the connection to database is live by reading the database version stamp.
+func (a *adapter) Ping() error {
+	if err := a.rlock(); err != nil {
+		return err
+	}
+	defer a.mu.RUnlock()
+	_, err := a.getMeta(metaVersion)
+	return err
+}
+
+// Version returns the on-disk format version of the database. It returns 0 if the version
+// is unknown, as for a read-only database that was never stamped, and -1 if the database
+// is not open.
+func (a *adapter) Version() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.version
+}
+
+// GetName returns string that adapter uses to register itself with store.
+func (a *adapter) GetName() string {
+	return adapterName
+}
+
+// Put appends the messages to the store. The write options set the messageId, TTL and
+// QoS level of the message, without options a messageId is generated and the message
+// expires after the configured default TTL, or never if there is no default TTL.
+func (a *adapter) Put(contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) error {
+	return a.PutContext(context.Background(), contract, topic, payload, opts...)
+}
+
+// PutContext appends the messages to the store. The write is aborted if the context
+// is already done before the message is written.
+func (a *adapter) PutContext(ctx context.Context, contract uint32, topic, payload []byte, opts ...dbadapter.WriteOption) (err error) {
+	_, span := a.tracer.Start(ctx, "unitdb.Put", contract, topic)
+	defer func() { span.End(len(payload), err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err = a.put(ctx, contract, topic, payload, dbadapter.NewWriteOptions(opts...))
+	return err
+}
+
+// PutWithTTL appends the messages to the store, the message expires after the ttl duration.
+// A zero ttl means the message never expires and the ttl is capped at configured max TTL.
+func (a *adapter) PutWithTTL(contract uint32, topic, payload []byte, ttl time.Duration) error {
+	return a.Put(contract, topic, payload, dbadapter.WithTTL(ttl))
+}
+
+// PutReturningID appends the messages to the store and returns the generated messageId.
+func (a *adapter) PutReturningID(contract uint32, topic, payload []byte) ([]byte, error) {
+	return a.put(context.Background(), contract, topic, payload, dbadapter.NewWriteOptions())
+}
+
+// PutAt appends the messages to the store recording the timestamp as the time of the message
+// and returns the generated messageId, so backfilled messages keep their original event time.
+// A zero timestamp means now. If max timestamp skew is configured timestamps later than now
+// plus the skew are rejected.
+func (a *adapter) PutAt(contract uint32, topic, payload []byte, ts time.Time) ([]byte, error) {
+	return a.put(context.Background(), contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithTimestamp(ts)))
+}
+
+// PutWithQoS appends the messages to the store recording the QoS level of the message.
+// QoS levels are 0, 1 and 2 as in MQTT, messages written without QoS have QoS level 0.
+func (a *adapter) PutWithQoS(contract uint32, topic, payload []byte, qos uint8) error {
+	return a.Put(contract, topic, payload, dbadapter.WithQoS(qos))
+}
+
+// PutWithID appends the messages to the store using a pre generated messageId.
+func (a *adapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
+	return a.Put(contract, topic, payload, dbadapter.WithID(messageId))
+}
+
+// put appends the message to the store using the messageId of write options or a generated
+// messageId, the message expires after the ttl duration. It returns the messageId of the message.
+// The write holds the lock of the topic, so it is serialized with conditional writes.
+func (a *adapter) put(ctx context.Context, contract uint32, topic, payload []byte, o dbadapter.WriteOptions) ([]byte, error) {
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	unlock := a.topicLocks.lock(contract, topic)
+	defer unlock()
+	return a.write(ctx, contract, topic, payload, o)
+}
+
+// write appends the message to the store as put, the caller holds the lock of the topic.
+func (a *adapter) write(ctx context.Context, contract uint32, topic, payload []byte, o dbadapter.WriteOptions) (messageId []byte, err error) {
+	defer a.meter.Puts.done(time.Now(), &err)
+	ttl := o.TTL
+	if ttl < 0 {
+		return nil, errors.New("unitdb adapter invalid ttl " + ttl.String() + ", ttl must not be negative")
+	}
+	if o.QoS > maxQoS {
+		return nil, errors.New("unitdb adapter invalid qos " + strconv.Itoa(int(o.QoS)) + ", qos must be 0, 1 or 2")
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return nil, dbadapter.ErrReadOnly
+	}
+	if err := a.checkTopicSize(topic); err != nil {
+		return nil, err
+	}
+	if err := a.checkPayload(payload); err != nil {
+		return nil, err
+	}
+	if !o.HasTTL {
+		ttl = a.config.defaultTTL
+	}
+	if a.config.maxSkew > 0 && o.Timestamp.After(time.Now().Add(a.config.maxSkew)) {
+		return nil, errors.New("unitdb adapter invalid timestamp " + o.Timestamp.String() + ", timestamp is later than max timestamp skew " + a.config.maxSkew.String() + " from now")
+	}
+	if ttl > a.config.maxTTL {
+		ttl = a.config.maxTTL
+	}
+	if err := a.indexTopic(contract, topic); err != nil {
+		return nil, err
+	}
+	messageId = o.ID
+	if messageId == nil {
+		if messageId, err = a.newID(); err != nil {
+			return nil, err
+		}
+	} else if err := a.checkMessageID(messageId); err != nil {
+		return nil, err
+	}
+	var seq uint64
+	if a.config.IDMode == idModeSequence {
+		if seq, err = a.reserveSeq(contract, topic, 1); err != nil {
+			return nil, err
+		}
+	}
+	env, err := a.wrap(messageId, payload, seq)
+	if err != nil {
+		if seq > 0 {
+			a.releaseSeq(contract, topic, seq, 1)
+		}
+		return nil, err
+	}
+	env.qos = o.QoS
+	if !o.Timestamp.IsZero() {
+		env.timestamp = o.Timestamp
+	}
+	if ttl > 0 {
+		env.expiry = a.now().Add(ttl).UnixNano()
+	}
+	entry := newEntry(contract, topic, env)
+	if ttl > 0 {
+		entry.WithTTL(ttl.String())
+	}
+	db := a.shardOf(contract, topic)
+	err = a.retry(ctx, func() error { return a.putEntry(db, entry) })
+	if seq > 0 {
+		a.commitSeq(contract, topic, seq, 1, err == nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := a.syncWrites(); err != nil {
+		return nil, err
+	}
+	a.written(contract, topic, messageId, payload)
+	if a.watchers.active() {
+		a.notifyWatchers(contract, topic, watchMessage(env, payload))
+	}
+	return messageId, nil
+}
+
+// retry calls write and retries it on a transient failure up to configured write retries,
+// waiting with exponential backoff capped at maxRetryBackoff between retries. Retries stop
+// once the context is done. It returns the error of the last attempt.
+func (a *adapter) retry(ctx context.Context, write func() error) error {
+	backoff := a.config.retryBackoff
+	err := write()
+	for i := 0; err != nil && transient(err) && i < a.config.WriteRetries; i++ {
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return a.failed(err)
+		case <-t.C:
+		}
+		backoff *= 2
+		err = write()
+	}
+	return a.failed(err)
+}
+
+// transient reports whether a failed write may succeed if it is retried. Errors reporting
+// themselves as temporary and interrupted or busy system calls are transient, other errors
+// such as a full disk or a closed database fail the same way when retried.
+func transient(err error) bool {
+	var t interface{ Temporary() bool }
+	if errors.As(err, &t) {
+		return t.Temporary()
+	}
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EBUSY)
+}
+
+// BatchPut appends the messages to the store in a single batch, the messages expire after
+// the configured default TTL if any. The batch is atomic, if any entry fails no messages are
+// stored and the failed entries are reported in dbadapter.BatchErrors.
+func (a *adapter) BatchPut(contract uint32, topic []byte, payloads [][]byte) error {
+	_, err := a.BatchPutResult(contract, topic, payloads, true)
+	return err
+}
+
+// BatchPutResult appends the messages to the store in a single batch like BatchPut and returns
+// the messageIds of the messages by index of the payload. Entries that fail are reported in
+// dbadapter.BatchErrors keyed by index and have a nil messageId. If atomic is set the batch
+// is aborted if any entry fails and no messages are stored, otherwise the other entries are
+// stored. Errors that fail the whole batch, such as a failure to write the batch, are not
+// reported per entry. The batch holds the lock of the topic, so it is serialized with
+// conditional writes.
+func (a *adapter) BatchPutResult(contract uint32, topic []byte, payloads [][]byte, atomic bool) (messageIds [][]byte, err error) {
+	defer a.meter.Puts.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	unlock := a.topicLocks.lock(contract, topic)
+	defer unlock()
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return nil, dbadapter.ErrReadOnly
+	}
+	if err := a.checkTopicSize(topic); err != nil {
+		return nil, err
+	}
+	errs := make(dbadapter.BatchErrors)
+	for i, payload := range payloads {
+		if err := a.checkPayload(payload); err != nil {
+			errs[i] = err
+		}
+	}
+	if atomic && len(errs) > 0 {
+		return nil, errs
+	}
+	if err := a.indexTopic(contract, topic); err != nil {
+		return nil, err
+	}
+	// sequence numbers are reserved before the batch opens and persisted once it commits
+	var seq uint64
+	n := len(payloads) - len(errs)
+	if a.config.IDMode == idModeSequence && n > 0 {
+		if seq, err = a.reserveSeq(contract, topic, n); err != nil {
+			return nil, err
+		}
+	}
+	watched := a.watchers.active()
+	var msgs []dbadapter.Message
+	messageIds = make([][]byte, len(payloads))
+	err = a.shardOf(contract, topic).Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+		next := seq
+		for i, payload := range payloads {
+			if errs[i] != nil {
+				continue
+			}
+			messageId, err := a.newID()
+			if err != nil {
+				return err
+			}
+			entry, env, err := a.batchEntry(contract, topic, messageId, payload, next)
+			if next > 0 {
+				next++
+			}
+			if err == nil {
+				err = b.PutEntry(entry)
+			}
+			if err != nil {
+				errs[i] = err
+				if atomic {
+					// abort the batch
+					return errs
+				}
+				continue
+			}
+			messageIds[i] = messageId
+			if watched {
+				msgs = append(msgs, watchMessage(env, payload))
+			}
+		}
+		return nil
+	})
+	if seq > 0 {
+		a.commitSeq(contract, topic, seq, n, err == nil)
+	}
+	if err != nil {
+		if atomic && len(errs) > 0 {
+			return nil, errs
+		}
+		return nil, a.failed(err)
+	}
+	if err := a.syncWrites(); err != nil {
+		return nil, err
+	}
+	for i, messageId := range messageIds {
+		if messageId != nil {
+			a.written(contract, topic, messageId, payloads[i])
+		}
+	}
+	if len(msgs) > 0 {
+		a.notifyWatchers(contract, topic, msgs...)
+	}
+	if len(errs) > 0 {
+		return messageIds, errs
+	}
+	return messageIds, nil
+}
+
+// batchEntry creates the entry of a message written by a batch, the message expires after
+// the configured default TTL if any.
+func (a *adapter) batchEntry(contract uint32, topic, messageId, payload []byte, seq uint64) (*unitdb.Entry, envelope, error) {
+	env, err := a.wrap(messageId, payload, seq)
+	if err != nil {
+		return nil, env, err
+	}
+	ttl := a.config.defaultTTL
+	if ttl > 0 {
+		env.expiry = a.now().Add(ttl).UnixNano()
+	}
+	entry := newEntry(contract, topic, env)
+	if ttl > 0 {
+		entry.WithTTL(ttl.String())
+	}
+	return entry, env, nil
+}
+
+// syncWrites syncs the writes to disk before a write returns if sync writes are configured.
+// With sync writes an acknowledged write survives a crash, otherwise writes are synced in
+// background and writes acknowledged since the last sync are lost on a crash.
+func (a *adapter) syncWrites() error {
+	if !a.config.SyncWrites {
+		return nil
+	}
+	return a.sync()
+}
+
+// sync syncs all shards to disk, the caller must hold the lock.
+func (a *adapter) sync() error {
+	for _, db := range a.allShards() {
+		if err := db.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newQuery creates a query for messages of the topic stored under the contract. Queries
+// are always scoped to the contract, so messages of other contracts are never returned.
+func newQuery(contract uint32, topic []byte, limit int) *unitdb.Query {
+	query := unitdb.NewQuery(topic)
+	query.WithContract(contract)
+	query.WithLimit(limit)
+	return query
+}
+
+// deleteEntry creates an entry to delete the message with the messageId stored under the contract.
+func deleteEntry(contract uint32, topic, messageId []byte) *unitdb.Entry {
+	entry := unitdb.NewEntry(topic, nil)
+	entry.WithContract(contract)
+	return entry.WithID(messageId)
+}
+
+// checkContract rejects the contract reserved to store adapter metadata, so metadata
+// cannot be read or overwritten through the adapter API.
+func checkContract(contract uint32) error {
+	if contract == metaContract {
+		return errReservedContract
+	}
+	return nil
+}
+
+// checkTopic returns dbadapter.ErrEmptyTopic if the topic is empty, an empty topic cannot
+// be addressed by queries so messages written to it could never be read.
+func checkTopic(topic []byte) error {
+	if len(topic) == 0 {
+		return dbadapter.ErrEmptyTopic
+	}
+	return nil
+}
+
+// checkTopicSize returns dbadapter.ErrTopicTooLong if the topic name is longer than the
+// configured max topic bytes, topic options are not counted as they are not stored in keys.
+func (a *adapter) checkTopicSize(topic []byte) error {
+	if n := len(topicName(topic)); a.config.MaxTopicBytes > 0 && n > a.config.MaxTopicBytes {
+		return fmt.Errorf("%w: unitdb adapter topic is %d bytes long, max topic bytes is %d", dbadapter.ErrTopicTooLong, n, a.config.MaxTopicBytes)
+	}
+	return nil
+}
+
+// checkMessageID returns dbadapter.ErrInvalidMessageID if the size of the messageId does not
+// match the size of messageIds generated by the database.
+func (a *adapter) checkMessageID(messageId []byte) error {
+	if len(messageId) != a.idSize {
+		return fmt.Errorf("%w: unitdb adapter messageId is %d bytes long, expected %d bytes", dbadapter.ErrInvalidMessageID, len(messageId), a.idSize)
+	}
+	return nil
+}
+
+// checkPayload returns dbadapter.ErrEmptyPayload if the payload is empty, unless empty
+// payloads are allowed by the config, and dbadapter.ErrPayloadTooLarge if the payload is
+// larger than the configured max payload bytes. The size is checked before compression.
+func (a *adapter) checkPayload(payload []byte) error {
+	if len(payload) == 0 && !a.config.AllowEmptyPayload {
+		return dbadapter.ErrEmptyPayload
+	}
+	if a.config.MaxPayloadBytes > 0 && len(payload) > a.config.MaxPayloadBytes {
+		return fmt.Errorf("%w: unitdb adapter payload is %d bytes long, max payload bytes is %d", dbadapter.ErrPayloadTooLarge, len(payload), a.config.MaxPayloadBytes)
+	}
+	return nil
+}
+
+// wrap creates the envelope for the message with the sequence number reserved for it in
+// sequence ID mode, and compresses the payload using the configured codec.
+func (a *adapter) wrap(messageId, payload []byte, seq uint64) (envelope, error) {
+	env := newEnvelope(messageId, payload)
+	env.seq = seq
+	return a.compress(env)
+}
+
+// newEntry creates an entry for the message encoding the message envelope as entry payload.
+// Entries and encoded envelopes are not pooled, unitdb has no way to reset an entry and it
+// buffers writes, so it may still reference the entry payload after PutEntry returns.
+func newEntry(contract uint32, topic []byte, env envelope) *unitdb.Entry {
+	entry := unitdb.NewEntry(topic, env.encode())
+	entry.WithContract(contract)
+	return entry.WithID(env.id)
+}
+
+// Get performs a query and attempts to fetch last n messages where
+// n is specified by limit argument. The limit is capped at configured max results and
+// ErrInvalidLimit is returned if the limit is less than one. Messages are returned in
+// the order unitdb iterates them, use GetOrdered to get messages ordered by time. Expired
+// messages unitdb has not dropped yet are dropped and deleted, and reported to expire hooks.
+func (a *adapter) Get(contract uint32, topic []byte, limit int) (matches [][]byte, err error) {
+	return a.GetContext(context.Background(), contract, topic, limit)
+}
+
+// GetMessages performs a query and attempts to fetch last n messages along with the
+// messageId and the time message was stored, where n is specified by limit argument. Expired
+// messages are dropped like Get.
+func (a *adapter) GetMessages(contract uint32, topic []byte, limit int) (matches []dbadapter.Message, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	matches = make([]dbadapter.Message, 0, limit)
+	now := a.now()
+	var expired [][]byte
+	err = a.topicItems(context.Background(), contract, topic, limit, func(env envelope) bool {
+		if env.expired(now) {
+			expired = append(expired, env.id)
+			return true
+		}
+		matches = append(matches, env.message())
+		return len(matches) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.expireRead(contract, topic, expired)
+	return matches, nil
+}
+
+// GetOrdered performs a query and attempts to fetch n messages ordered by the time
+// messages were stored, where n is specified by limit argument. If desc is set then
+// last n messages are returned newest first, otherwise first n messages are returned
+// oldest first. All messages of the topic are read to sort them by time.
+func (a *adapter) GetOrdered(contract uint32, topic []byte, limit int, desc bool) (matches [][]byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	var envs []envelope
+	if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		envs = append(envs, env)
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(envs, func(i, j int) bool {
+		if desc {
+			return envs[i].timestamp.After(envs[j].timestamp)
+		}
+		return envs[i].timestamp.Before(envs[j].timestamp)
+	})
+	if len(envs) > limit {
+		envs = envs[:limit]
+	}
+	for _, env := range envs {
+		matches = append(matches, env.payload)
+	}
+	return matches, nil
+}
+
+// GetWildcard performs a query for topics matching the MQTT-style topic pattern and attempts
+// to fetch last n messages where n is specified by limit argument. Topic levels are separated
+// by '/', '+' matches a single topic level and '#' as the last level matches any number of
+// levels. The pattern is translated to the unitdb topic syntax where levels are separated by
+// '.', '*' matches a single level and '...' matches multiple levels.
+func (a *adapter) GetWildcard(contract uint32, topicPattern []byte, limit int) ([][]byte, error) {
+	return a.GetContext(context.Background(), contract, wildcardTopic(topicPattern), limit)
+}
+
+// wildcardTopic translates the MQTT-style topic pattern to the unitdb topic syntax.
+func wildcardTopic(pattern []byte) []byte {
+	parts := bytes.Split(pattern, []byte{'/'})
+	for i, part := range parts {
+		switch string(part) {
+		case "+":
+			parts[i] = []byte("*")
+		case "#":
+			parts[i] = []byte("...")
+		}
+	}
+	topic := bytes.Join(parts, []byte{'.'})
+	// multi-level wildcard follows the parent level without separator
+	return bytes.Replace(topic, []byte("...."), []byte("..."), 1)
+}
+
+// GetRange performs a query and attempts to fetch last n messages stored between
+// from and until times, where n is specified by limit argument. The limit is capped
+// at configured max results. A zero from time
+// fetches messages from the beginning and a zero until time means now. Messages are
+// returned oldest first by the time of the message, which is the time set by PutAt for
+// backfilled messages, so all messages in the range are read to order them. It returns
+// ErrInvalidArgument if from is after until.
+func (a *adapter) GetRange(contract uint32, topic []byte, from, until time.Time, limit int) (matches [][]byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if from.After(until) {
+		return nil, fmt.Errorf("%w: unitdb adapter range from %s is after until %s", dbadapter.ErrInvalidArgument, from, until)
+	}
+	// messages backfilled with a time in the future are not found by a last duration query
+	if d := time.Since(from); !from.IsZero() && d > 0 {
+		topic = withLast(topic, d)
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	var envs []envelope
+	if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if !env.timestamp.Before(from) && !env.timestamp.After(until) {
+			envs = append(envs, env)
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(envs, func(i, j int) bool {
+		return envs[i].timestamp.Before(envs[j].timestamp)
+	})
+	if len(envs) > limit {
+		envs = envs[len(envs)-limit:]
+	}
+	matches = make([][]byte, 0, len(envs))
+	for _, env := range envs {
+		matches = append(matches, env.payload)
+	}
+	return matches, nil
+}
+
+// GetSince performs a query and attempts to fetch first n messages stored after the since
+// time, where n is specified by limit argument. Messages are returned oldest first, so
+// consumers can resume from the timestamp of the last message returned. The limit is
+// capped at configured max results. A zero since time fetches messages from the beginning.
+func (a *adapter) GetSince(contract uint32, topic []byte, since time.Time, limit int) (matches []dbadapter.Message, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	if !since.IsZero() {
+		if !since.Before(time.Now()) {
+			return nil, nil
+		}
+		topic = withLast(topic, time.Since(since))
+	}
+	var envs []envelope
+	if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if env.timestamp.After(since) {
+			envs = append(envs, env)
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(envs, func(i, j int) bool {
+		return envs[i].timestamp.Before(envs[j].timestamp)
+	})
+	if len(envs) > limit {
+		envs = envs[:limit]
+	}
+	matches = make([]dbadapter.Message, 0, len(envs))
+	for _, env := range envs {
+		matches = append(matches, env.message())
+	}
+	return matches, nil
+}
+
+// GetContext performs a query and attempts to fetch last n messages where
+// n is specified by limit argument. The limit is capped at configured max results. The iteration
+// is stopped and context error is returned if the context is done.
+func (a *adapter) GetContext(ctx context.Context, contract uint32, topic []byte, limit int) (matches [][]byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	ctx, span := a.tracer.Start(ctx, "unitdb.Get", contract, topic)
+	defer func() { span.End(len(matches), err) }()
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	// the limit is capped at max results, so matches are allocated once
+	matches = make([][]byte, 0, limit)
+	now := a.now()
+	var expired [][]byte
+	err = a.topicItems(ctx, contract, topic, limit, func(env envelope) bool {
+		if env.expired(now) {
+			expired = append(expired, env.id)
+			return true
+		}
+		matches = append(matches, env.payload)
+		return len(matches) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.expireRead(contract, topic, expired)
+	return matches, nil
+}
+
+// GetInto performs a query and attempts to fetch last n messages where n is specified by limit
+// argument, like Get. Payloads are copied into the buffers of dst reusing their capacity and
+// the matches are returned as dst resliced, so callers reading a topic repeatedly can avoid
+// allocating payloads. The buffers of dst must not be used by the caller while GetInto runs.
+func (a *adapter) GetInto(contract uint32, topic []byte, limit int, dst [][]byte) (matches [][]byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	matches = dst[:0]
+	err = a.scanTopic(context.Background(), contract, topic, limit, false, func(env envelope) bool {
+		if n := len(matches); n < cap(matches) {
+			matches = matches[:n+1]
+			matches[n] = append(matches[n][:0], env.payload...)
+		} else {
+			matches = append(matches, append([]byte(nil), env.payload...))
+		}
+		return len(matches) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// GetMulti performs a query for each topic and attempts to fetch last n messages of each topic,
+// where n is specified by limit argument. Topics are queried concurrently by up to configured
+// get multi workers. Matches are keyed by topic, a failed topic does not abort queries of other
+// topics and it is reported in the returned dbadapter.TopicErrors along with matches of the other topics.
+func (a *adapter) GetMulti(contract uint32, topics [][]byte, limit int) (matches map[string][][]byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
 
-	adapterName = "unitdb"
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	matches = make(map[string][][]byte, len(topics))
+	errs := make(dbadapter.TopicErrors)
+	workers := a.config.GetMultiWorkers
+	if workers > len(topics) {
+		workers = len(topics)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for topic := range jobs {
+				var payloads [][]byte
+				err := checkTopic([]byte(topic))
+				if err == nil {
+					err = a.topicItems(context.Background(), contract, []byte(topic), limit, func(env envelope) bool {
+						payloads = append(payloads, env.payload)
+						return len(payloads) < limit
+					})
+				}
+				mu.Lock()
+				if err != nil {
+					errs[topic] = err
+				} else {
+					matches[topic] = payloads
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	seen := make(map[string]struct{}, len(topics))
+	for _, topic := range topics {
+		if _, ok := seen[string(topic)]; ok {
+			continue
+		}
+		seen[string(topic)] = struct{}{}
+		jobs <- string(topic)
+	}
+	close(jobs)
+	wg.Wait()
+	if len(errs) > 0 {
+		return matches, errs
+	}
+	return matches, nil
+}
 
-	logPostfix = ".log"
-)
+// GetLast performs a query and attempts to fetch the most recent message for the topic,
+// it returns false if no messages were found. The most recent message is chosen by the
+// time the message was stored, so it does not depend on the order unitdb iterates messages.
+func (a *adapter) GetLast(contract uint32, topic []byte) (payload []byte, ok bool, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, false, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, false, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, false, err
+	}
+	defer a.mu.RUnlock()
+	var last time.Time
+	err = a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if !ok || env.timestamp.After(last) {
+			payload, last, ok = env.payload, env.timestamp, true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, ok, nil
+}
 
-type configType struct {
-	Dir           string `json:"dir,omitempty"`
-	Size          int64  `json:"mem_size"`
-	LogReleaseDur string `json:"log_release_duration,omitempty"`
-	dur           time.Duration
+// GetPage performs a query and attempts to fetch n messages after the cursor where n is
+// specified by limit argument. The cursor is the messageId of the last message of the
+// previous page, a nil cursor fetches the first page. It returns the cursor to fetch
+// the next page, the next cursor is nil if there are no more messages. Version 1 messages
+// carry no messageId a cursor can address, so they are not paged. It returns ErrNotFound
+// if the cursor matches no message of the topic, for example if the message was deleted
+// since the previous page was fetched.
+func (a *adapter) GetPage(contract uint32, topic, cursor []byte, limit int) (matches [][]byte, next []byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, nil, err
+	}
+	skip := len(cursor) > 0
+	var lastId []byte
+	err = a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if env.id == nil {
+			return true
+		}
+		if skip {
+			skip = !bytes.Equal(env.id, cursor)
+			return true
+		}
+		if len(matches) == limit {
+			next = lastId
+			return false
+		}
+		matches = append(matches, env.payload)
+		lastId = append(lastId[:0], env.id...)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if skip {
+		return nil, nil, fmt.Errorf("%w: unitdb adapter cursor matches no message of the topic", dbadapter.ErrNotFound)
+	}
+	return matches, next, nil
 }
 
-const (
-	// Maximum number of records to return
-	maxResults = 1024
-	// Maximum TTL for message
-	maxTTL = "24h"
-)
+// GetOffset performs a query and attempts to fetch n messages after skipping the first
+// offset messages, where n is specified by limit argument. The limit is capped at configured
+// max results. Skipped messages are still iterated, so the cost of a query grows with the
+// offset, use GetPage for large topics.
+func (a *adapter) GetOffset(contract uint32, topic []byte, offset, limit int) (matches [][]byte, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: offset %d must not be negative", dbadapter.ErrInvalidLimit, offset)
+	}
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	err = a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		matches = append(matches, env.payload)
+		return len(matches) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
 
-// Store represents an SSD-optimized storage store.
-type adapter struct {
-	db         *unitdb.DB // The underlying database to store messages.
-	mem        *memdb.DB  // The underlying memdb to store messages.
-	config     *configType
-	writeLockC chan struct{}
-	bufPool    *bpool.BufferPool
-	//tiny Batch
-	tinyBatch *tinyBatch
-	wal       *wal.WAL
-	version   int
+// Stream performs a query and sends messages to the returned channel as it iterates,
+// the channel is closed when iteration completes or the context is done. An error
+// if any is sent to the error channel before it is closed.
+func (a *adapter) Stream(ctx context.Context, contract uint32, topic []byte) (<-chan []byte, <-chan error) {
+	payloads := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(payloads)
+		defer close(errc)
+		if err := checkContract(contract); err != nil {
+			errc <- err
+			return
+		}
+		if err := checkTopic(topic); err != nil {
+			errc <- err
+			return
+		}
+		if err := a.rlock(); err != nil {
+			errc <- err
+			return
+		}
+		defer a.mu.RUnlock()
+		err := a.topicItems(ctx, contract, topic, math.MaxInt32, func(env envelope) bool {
+			select {
+			case payloads <- append([]byte(nil), env.payload...):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return payloads, errc
+}
 
-	// close
-	closer io.Closer
+// Count returns number of messages stored for the topic. The count is not capped
+// at max results and no payloads are returned to the caller.
+func (a *adapter) Count(contract uint32, topic []byte) (count uint64, err error) {
+	if err := checkContract(contract); err != nil {
+		return 0, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return 0, err
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	err = a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		count++
+		return true
+	})
+	return count, err
 }
 
-// Open initializes database connection
-func (a *adapter) Open(jsonconfig string) error {
-	if a.db != nil {
-		return errors.New("unitdb adapter is already connected")
+// CountRange returns number of messages of the topic stored between from and until times,
+// both times are inclusive. A zero from time counts messages from the beginning and a zero
+// until time means now. Like Count the count is not capped at max results, item values
+// are not copied and no payloads are returned to the caller.
+func (a *adapter) CountRange(contract uint32, topic []byte, from, until time.Time) (count uint64, err error) {
+	if err := checkContract(contract); err != nil {
+		return 0, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return 0, err
 	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if from.After(until) {
+		return 0, nil
+	}
+	if !from.IsZero() {
+		topic = withLast(topic, time.Since(from))
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	err = a.scanTopic(context.Background(), contract, topic, math.MaxInt32, false, func(env envelope) bool {
+		if !env.timestamp.Before(from) && !env.timestamp.After(until) {
+			count++
+		}
+		return true
+	})
+	return count, err
+}
 
-	var err error
-	var config configType
+// Exists checks if a message with the messageId is stored for the topic.
+func (a *adapter) Exists(contract uint32, topic, messageId []byte) (bool, error) {
+	_, ok, err := a.GetByID(contract, topic, messageId)
+	return ok, err
+}
 
-	if err = json.Unmarshal([]byte(jsonconfig), &config); err != nil {
-		return errors.New("unitdb adapter failed to parse config: " + err.Error())
+// GetByID performs a query and attempts to fetch the message with the messageId, it
+// returns false if the message was not found. unitdb does not index messages by
+// messageId, so messages of the topic are scanned until the messageId is found.
+func (a *adapter) GetByID(contract uint32, topic, messageId []byte) (payload []byte, ok bool, err error) {
+	if err := checkContract(contract); err != nil {
+		return nil, false, err
 	}
-
-	// Make sure we have a directory
-	if err := os.MkdirAll(config.Dir, 0777); err != nil {
-		log.Error("adapter.Open", "Unable to create db dir")
+	if err := checkTopic(topic); err != nil {
+		return nil, false, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, false, err
+	}
+	defer a.mu.RUnlock()
+	err = a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if ok = bytes.Equal(env.id, messageId); ok {
+			payload = env.payload
+		}
+		return !ok
+	})
+	if err != nil {
+		return nil, false, err
 	}
+	return payload, ok, nil
+}
 
-	// Attempt to open the database
-	a.db, err = unitdb.Open(config.Dir+"/"+defaultDatabase, nil, unitdb.WithMutable())
+// items iterates over metadata matching the query and calls fn for each envelope.
+func (a *adapter) items(ctx context.Context, query *unitdb.Query, fn func(env envelope) bool) error {
+	return a.dbItems(ctx, a.db, query, fn)
+}
+
+// dbItems iterates over messages of the database matching the query and calls fn for each
+// message envelope. The iteration stops if fn returns false or the context is done. The
+// unitdb item iterator holds no resources other than query results, so it is released on return.
+func (a *adapter) dbItems(ctx context.Context, db *unitdb.DB, query *unitdb.Query, fn func(env envelope) bool) error {
+	return a.scanItems(ctx, db, query, true, fn)
+}
+
+// scanItems iterates over messages of the database matching the query. unitdb does not document
+// whether an item value remains valid once the iterator advances, so unless retain is false the
+// value is copied before it is decoded. If retain is false the envelope id and payload may alias
+// the iterator buffer and must not be used after fn returns.
+func (a *adapter) scanItems(ctx context.Context, db *unitdb.DB, query *unitdb.Query, retain bool, fn func(env envelope) bool) error {
+	it, err := db.Items(query)
 	if err != nil {
-		log.Error("adapter.Open", "Unable to open db")
+		return a.failed(err)
+	}
+	for it.First(); it.Valid(); it.Next() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := it.Error(); err != nil {
+			return a.failed(err)
+		}
+		value := it.Item().Value()
+		if retain {
+			value = append([]byte(nil), value...)
+		}
+		env, err := decodeEnvelope(value)
+		if err != nil {
+			return err
+		}
+		if !fn(env) {
+			break
+		}
+	}
+	return nil
+}
+
+// checkLimit validates the query limit and caps it at configured max results.
+func (a *adapter) checkLimit(limit int) (int, error) {
+	if limit < 1 {
+		return 0, fmt.Errorf("%w: limit %d", dbadapter.ErrInvalidLimit, limit)
+	}
+	if limit > a.config.MaxResults {
+		return a.config.MaxResults, nil
+	}
+	return limit, nil
+}
+
+// withLast replaces the topic options with the last duration option used
+// by unitdb for time-series retrieval.
+func withLast(topic []byte, dur time.Duration) []byte {
+	if i := bytes.IndexByte(topic, '?'); i >= 0 {
+		topic = topic[:i]
+	}
+	last := (dur + time.Second).Truncate(time.Second)
+	return append(append(topic[:len(topic):len(topic)], "?last="...), last.String()...)
+}
+
+// NewID generates a new messageId.
+func (a *adapter) NewID() ([]byte, error) {
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	return a.newID()
+}
+
+// newID generates a new messageId, the caller must hold the read lock.
+func (a *adapter) newID() ([]byte, error) {
+	id := a.db.NewID()
+	if id == nil {
+		return nil, errors.New("Key is empty.")
+	}
+	return id, nil
+}
+
+// Delete deletes the message from the store.
+func (a *adapter) Delete(contract uint32, messageId, topic []byte) error {
+	return a.DeleteContext(context.Background(), contract, messageId, topic)
+}
+
+// DeleteContext deletes the message from the store. The delete is aborted if the context
+// is already done before the message is deleted.
+func (a *adapter) DeleteContext(ctx context.Context, contract uint32, messageId, topic []byte) (err error) {
+	defer a.meter.Dels.done(time.Now(), &err)
+	_, span := a.tracer.Start(ctx, "unitdb.Delete", contract, topic)
+	defer func() { span.End(0, err) }()
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	// Attempt to open the memdb
-	a.mem, err = memdb.Open(config.Size, &memdb.Options{MaxElapsedTime: 2 * time.Second})
-	if err != nil {
+	if err := checkContract(contract); err != nil {
 		return err
 	}
-
-	a.bufPool = bpool.NewBufferPool(config.Size, nil)
-	a.tinyBatch.buffer = a.bufPool.Get()
-	dur, err := time.ParseDuration(config.LogReleaseDur)
-	if err != nil {
+	if err := checkTopic(topic); err != nil {
 		return err
 	}
-	a.config = &config
-	a.config.dur = dur
-	return nil
+	if err := a.rlock(); err != nil {
+		return err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return dbadapter.ErrReadOnly
+	}
+	if err := a.checkMessageID(messageId); err != nil {
+		return err
+	}
+	if err := a.shardOf(contract, topic).DeleteEntry(deleteEntry(contract, topic, messageId)); err != nil {
+		return err
+	}
+	return a.syncWrites()
 }
 
-// Close closes the underlying database connection
-func (a *adapter) Close() error {
-	var err error
-	if a.db != nil {
-		err = a.db.Close()
-		a.db = nil
-		a.version = -1
+// DeleteByTopic deletes all messages stored for the topic and returns number of messages deleted.
+// Messages are deleted in batches of max results messages.
+func (a *adapter) DeleteByTopic(contract uint32, topic []byte) (deleted int, err error) {
+	defer a.meter.Dels.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return 0, err
 	}
-	if a.mem != nil {
-		err = a.mem.Close()
-		a.mem = nil
+	if err := checkTopic(topic); err != nil {
+		return 0, err
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return 0, dbadapter.ErrReadOnly
+	}
+	if deleted, err = a.deleteTopic(contract, topic); err != nil {
+		return deleted, err
+	}
+	return deleted, a.syncWrites()
+}
 
-		var err error
-		if a.closer != nil {
-			if err1 := a.closer.Close(); err == nil {
-				err = err1
+// deleteTopic deletes all messages stored for the topic in batches of max results messages
+// and returns number of messages deleted, the caller must hold the read lock. Version 1
+// messages carry no messageId to delete them by and are skipped.
+func (a *adapter) deleteTopic(contract uint32, topic []byte) (deleted int, err error) {
+	var ids [][]byte
+	if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if env.id == nil {
+			return true
+		}
+		ids = append(ids, append([]byte(nil), env.id...))
+		return true
+	}); err != nil {
+		return 0, err
+	}
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > a.config.MaxResults {
+			n = a.config.MaxResults
+		}
+		if err := a.shardOf(contract, topic).Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+			for _, id := range ids[:n] {
+				if err := b.DeleteEntry(deleteEntry(contract, topic, id)); err != nil {
+					return err
+				}
 			}
-			a.closer = nil
+			return nil
+		}); err != nil {
+			return deleted, err
 		}
+		deleted += n
+		ids = ids[n:]
 	}
-	return err
+	return deleted, nil
 }
 
-// IsOpen returns true if connection to database has been established. It does not check if
-// connection is actually live.
-func (a *adapter) IsOpen() bool {
-	return a.db != nil
+// BatchDelete deletes messages for the messageIds in a single batch. Malformed messageIds
+// are skipped and reported in the returned error after valid messageIds are deleted.
+func (a *adapter) BatchDelete(contract uint32, topic []byte, messageIds [][]byte) (err error) {
+	defer a.meter.Dels.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	if err := a.rlock(); err != nil {
+		return err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return dbadapter.ErrReadOnly
+	}
+	var errs []string
+	if err := a.shardOf(contract, topic).Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+		for i, messageId := range messageIds {
+			if err := a.checkMessageID(messageId); err != nil {
+				errs = append(errs, "messageId at index "+strconv.Itoa(i)+": "+err.Error())
+				continue
+			}
+			if err := b.DeleteEntry(deleteEntry(contract, topic, messageId)); err != nil {
+				errs = append(errs, "messageId at index "+strconv.Itoa(i)+": "+err.Error())
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := a.syncWrites(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.New("unitdb adapter batch delete failed: " + strings.Join(errs, "; "))
+	}
+	return nil
 }
 
-// GetName returns string that adapter uses to register itself with store.
-func (a *adapter) GetName() string {
-	return adapterName
+// Sync flushes the buffered writes and deletes of the underlying database to disk.
+// Unless sync writes are configured Put and Delete do not fsync, unitdb buffers writes and
+// syncs them in background, so Sync is required to guarantee that messages written so far are durable.
+func (a *adapter) Sync() error {
+	if err := a.rlock(); err != nil {
+		return err
+	}
+	defer a.mu.RUnlock()
+	return a.sync()
 }
 
-// Put appends the messages to the store.
-func (a *adapter) Put(contract uint32, topic, payload []byte) error {
-	entry := unitdb.NewEntry(topic, payload)
-	entry.WithContract(contract)
-	return a.db.PutEntry(entry)
+// Compact forces the underlying database to sync pending writes and deletes to disk
+// and blocks until it completes. unitdb has no separate compaction, the space of deleted
+// and expired messages is released to free blocks on sync and reused by later writes.
+func (a *adapter) Compact() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db == nil {
+		return dbadapter.ErrClosed
+	}
+	return a.sync()
 }
 
-// PutWithID appends the messages to the store using a pre generated messageId.
-func (a *adapter) PutWithID(contract uint32, messageId, topic, payload []byte) error {
-	entry := unitdb.NewEntry(topic, payload)
-	entry.WithContract(contract)
-	return a.db.PutEntry(entry.WithID(messageId))
+// Stats returns the database stats.
+func (a *adapter) Stats() (dbadapter.Stats, error) {
+	if err := a.rlock(); err != nil {
+		return dbadapter.Stats{}, err
+	}
+	defer a.mu.RUnlock()
+	size, err := a.fileSize()
+	if err != nil {
+		return dbadapter.Stats{}, err
+	}
+	var count uint64
+	for _, db := range a.allShards() {
+		count += db.Count()
+	}
+	return dbadapter.Stats{
+		Size:          size,
+		Count:         count,
+		Version:       a.version,
+		LastReconnect: a.lastReconnect,
+	}, nil
 }
 
-// Get performs a query and attempts to fetch last n messages where
-// n is specified by limit argument. From and until times can also be specified
-// for time-series retrieval.
-func (a *adapter) Get(contract uint32, topic []byte) (matches [][]byte, err error) {
-	// Iterating over key/value pairs.
-	query := unitdb.NewQuery(topic)
-	query.WithContract(contract)
-	return a.db.Get(query)
+// FileSize returns total size of the database files in the db dir and the value dir.
+func (a *adapter) FileSize() (int64, error) {
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	return a.fileSize()
 }
 
-// NewID generates a new messageId.
-func (a *adapter) NewID() ([]byte, error) {
-	id := a.db.NewID()
-	if id == nil {
-		return nil, errors.New("Key is empty.")
+// fileSize returns total size of the database files, the caller must hold the read lock.
+func (a *adapter) fileSize() (int64, error) {
+	var files []string
+	for i := 0; i < a.config.Shards; i++ {
+		dbFiles, err := a.config.dbFiles(shardDir(a.config.Dir, i))
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, dbFiles...)
+		if a.config.ValueDir != a.config.Dir {
+			valueFiles, err := a.config.dbFiles(shardDir(a.config.ValueDir, i))
+			if err != nil {
+				return 0, err
+			}
+			files = append(files, valueFiles...)
+		}
 	}
-	return id, nil
+	var size int64
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
 }
 
-// Put appends the messages to the store.
-func (a *adapter) Delete(contract uint32, messageId, topic []byte) error {
-	entry := unitdb.NewEntry(topic, nil)
-	entry.WithContract(contract)
-	return a.db.DeleteEntry(entry.WithID(messageId))
+// dbFiles returns paths of the database files in the dir.
+func (c *configType) dbFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, c.Name+"*"))
 }
 
 type (
@@ -199,6 +1751,9 @@ func (b *tinyBatch) incount() uint32 {
 
 // append appends message to tinyBatch for writing to log file.
 func (a *adapter) Append(delFlag bool, k uint64, data []byte) error {
+	if a.tinyBatch.buffer == nil {
+		return dbadapter.ErrClosed
+	}
 	var dBit uint8
 	if delFlag {
 		dBit = 1
@@ -229,6 +1784,9 @@ func (a *adapter) Append(delFlag bool, k uint64, data []byte) error {
 
 // PutMessage appends the messages to the store.
 func (a *adapter) PutMessage(blockId, key uint64, payload []byte) error {
+	if a.mem == nil {
+		return dbadapter.ErrClosed
+	}
 	if err := a.mem.Set(blockId, key, payload); err != nil {
 		return err
 	}
@@ -237,6 +1795,9 @@ func (a *adapter) PutMessage(blockId, key uint64, payload []byte) error {
 
 // GetMessage performs a query and attempts to fetch message for the given blockId and key
 func (a *adapter) GetMessage(blockId, key uint64) (matches []byte, err error) {
+	if a.mem == nil {
+		return nil, dbadapter.ErrClosed
+	}
 	matches, err = a.mem.Get(blockId, key)
 	if err != nil {
 		return nil, err
@@ -246,11 +1807,17 @@ func (a *adapter) GetMessage(blockId, key uint64) (matches []byte, err error) {
 
 // Keys performs a query and attempts to fetch all keys for given blockId.
 func (a *adapter) Keys(blockId uint64) []uint64 {
+	if a.mem == nil {
+		return nil
+	}
 	return a.mem.Keys(blockId)
 }
 
 // DeleteMessage deletes message from memdb store.
 func (a *adapter) DeleteMessage(blockId, key uint64) error {
+	if a.mem == nil {
+		return dbadapter.ErrClosed
+	}
 	if err := a.mem.Remove(blockId, key); err != nil {
 		return err
 	}
@@ -260,7 +1827,10 @@ func (a *adapter) DeleteMessage(blockId, key uint64) error {
 // Recovery recovers pending messages from log file.
 func (a *adapter) Recovery(reset bool) (map[uint64][]byte, error) {
 	m := make(map[uint64][]byte) // map[key]msg
-	logOpts := wal.Options{Path: a.config.Dir + "/" + defaultMessageStore + logPostfix, TargetSize: a.config.Size, BufferSize: a.config.Size, Reset: reset}
+	if a.config == nil {
+		return m, dbadapter.ErrClosed
+	}
+	logOpts := wal.Options{Path: filepath.Join(a.config.Dir, defaultMessageStore+logPostfix), TargetSize: a.config.Size, BufferSize: a.config.Size, Reset: reset}
 	wal, needLogRecovery, err := wal.New(logOpts)
 	if err != nil {
 		wal.Close()
@@ -309,6 +1879,9 @@ func (a *adapter) Write() error {
 	if a.tinyBatch.count() == 0 {
 		return nil
 	}
+	if a.wal == nil {
+		return dbadapter.ErrClosed
+	}
 
 	logWriter, err := a.wal.NewWriter()
 	if err != nil {
@@ -347,10 +1920,29 @@ func nextTimeID(dur time.Duration) int64 {
 	return time.Now().UTC().Truncate(dur).Add(dur).Round(time.Millisecond).Unix()
 }
 
-func init() {
-	adp := &adapter{
+func newAdapter() *adapter {
+	return &adapter{
 		writeLockC: make(chan struct{}),
 		tinyBatch:  &tinyBatch{},
+		meter:      newMeter(),
+		logger:     dbadapter.DefaultLogger{},
+		tracer:     dbadapter.NopTracer{},
+		index:      newTopicIndex(),
+		seqs:       newSequences(),
+		expiring:   newExpiring(),
+		counters:   newCounters(),
+		watchers:   newWatchers(),
+		now:        time.Now,
+		putEntry:   (*unitdb.DB).PutEntry,
+		version:    -1,
+	}
+}
+
+func init() {
+	var err error
+	if maxTTLDur, err = time.ParseDuration(maxTTL); err != nil {
+		panic("unitdb adapter failed to parse maxTTL: " + err.Error())
 	}
-	store.RegisterAdapter(adapterName, adp)
+
+	store.RegisterAdapter(adapterName, newAdapter())
 }
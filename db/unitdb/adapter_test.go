@@ -0,0 +1,3586 @@
+package adapter
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitd/pkg/metrics"
+	"github.com/unit-io/unitdb"
+)
+
+func testConfig(dir string) string {
+	return `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m"}`
+}
+
+func newTestAdapter(t *testing.T) (*adapter, func()) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newAdapter()
+	if err := a.Open(testConfig(dir)); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return a, func() {
+		a.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.range")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("before")))
+	}
+	time.Sleep(10 * time.Millisecond)
+	from := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("inside")))
+	}
+	until := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, a.Put(contract, topic, []byte("after")))
+
+	matches, err := a.GetRange(contract, topic, from, until, 100)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 5)
+	for _, payload := range matches {
+		assert.Equal(t, []byte("inside"), payload)
+	}
+
+	// zero until time means now
+	matches, err = a.GetRange(contract, topic, from, time.Time{}, 100)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 6)
+
+	// from must not be after until
+	_, err = a.GetRange(contract, topic, until, from, 100)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidArgument))
+	_, err = a.GetRange(contract, topic, time.Now().Add(time.Hour), time.Time{}, 100)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidArgument))
+
+	// a from time in the future finds messages backfilled with a time in the future
+	future := time.Now().Add(time.Minute)
+	_, err = a.PutAt(contract, topic, []byte("future"), future)
+	assert.NoError(t, err)
+	matches, err = a.GetRange(contract, topic, future, future.Add(time.Second), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("future")}, matches)
+}
+
+func TestGetSince(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.since")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("before")))
+	}
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, a.Put(contract, topic, []byte("after"+strconv.Itoa(i))))
+	}
+
+	msgs, err := a.GetSince(contract, topic, since, 100)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 5)
+	for i, m := range msgs {
+		assert.Equal(t, []byte("after"+strconv.Itoa(i)), m.Payload)
+		assert.True(t, m.Timestamp.After(since))
+	}
+
+	// resume from the timestamp of the last message returned
+	msgs, err = a.GetSince(contract, topic, since, 2)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	msgs, err = a.GetSince(contract, topic, msgs[1].Timestamp, 100)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.Equal(t, []byte("after2"), msgs[0].Payload)
+
+	// zero since time fetches messages from the beginning
+	msgs, err = a.GetSince(contract, topic, time.Time{}, 100)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 8)
+
+	msgs, err = a.GetSince(contract, topic, time.Now().Add(time.Minute), 100)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestCountRange(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.countrange")
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	msgs, err := a.GetSince(contract, topic, time.Time{}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 5)
+
+	// both ends of the window are inclusive
+	count, err := a.CountRange(contract, topic, msgs[1].Timestamp, msgs[3].Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+	count, err = a.CountRange(contract, topic, msgs[2].Timestamp, msgs[2].Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+	count, err = a.CountRange(contract, topic, msgs[1].Timestamp.Add(time.Nanosecond), msgs[3].Timestamp.Add(-time.Nanosecond))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	// zero from time counts from the beginning and zero until time means now
+	count, err = a.CountRange(contract, topic, time.Time{}, msgs[1].Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	count, err = a.CountRange(contract, topic, msgs[3].Timestamp, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+	count, err = a.CountRange(contract, topic, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), count)
+
+	count, err = a.CountRange(contract, topic, msgs[3].Timestamp, msgs[1].Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestBatchPut(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.batch")
+	payloads := make([][]byte, 10000)
+	for i := range payloads {
+		payloads[i] = []byte("msg")
+	}
+	assert.NoError(t, a.BatchPut(contract, topic, payloads))
+
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(payloads)), count)
+}
+
+func TestGetLimit(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.limit")
+	payloads := make([][]byte, maxResults+10)
+	for i := range payloads {
+		payloads[i] = []byte("msg")
+	}
+	assert.NoError(t, a.BatchPut(contract, topic, payloads))
+
+	matches, err := a.Get(contract, topic, 1000000)
+	assert.NoError(t, err)
+	assert.Len(t, matches, maxResults)
+}
+
+func TestCheckLimit(t *testing.T) {
+	a := &adapter{config: &configType{Options: Options{MaxResults: maxResults}}}
+	tests := []struct {
+		limit int
+		want  int
+		err   error
+	}{
+		{-1, 0, dbadapter.ErrInvalidLimit},
+		{0, 0, dbadapter.ErrInvalidLimit},
+		{1, 1, nil},
+		{maxResults + 1, maxResults, nil},
+	}
+	for _, tt := range tests {
+		limit, err := a.checkLimit(tt.limit)
+		if tt.err == nil {
+			assert.NoError(t, err)
+		} else {
+			assert.True(t, errors.Is(err, tt.err))
+		}
+		assert.Equal(t, tt.want, limit)
+	}
+}
+
+func TestGetItemsError(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+
+	// closing the underlying database behind the adapter fails the unitdb query
+	assert.NoError(t, a.db.Close())
+	_, err := a.Get(contract, topic, 10)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, dbadapter.ErrClosed))
+	_, err = a.Count(contract, topic)
+	assert.Error(t, err)
+}
+
+func TestVersionMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a newer version and an older envelope layout are both refused.
+	for _, version := range []uint32{uint32(dbVersion) + 1, uint32(dbVersion) - 1} {
+		versionDir := filepath.Join(dir, strconv.Itoa(int(version)))
+		assert.NoError(t, os.MkdirAll(versionDir, 0777))
+		db, err := openDB(&configType{Options: Options{Dir: versionDir, ValueDir: versionDir, Name: defaultDatabase}})
+		assert.NoError(t, err)
+		var scratch [4]byte
+		binary.LittleEndian.PutUint32(scratch[:], version)
+		assert.NoError(t, (&adapter{db: db}).putMeta(metaVersion, scratch[:]))
+		assert.NoError(t, db.Close())
+
+		a := newAdapter()
+		err = a.Open(testConfig(versionDir))
+		assert.True(t, errors.Is(err, dbadapter.ErrVersionMismatch))
+		assert.False(t, a.IsOpen())
+	}
+}
+
+func TestVersionStamp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.Equal(t, -1, a.Version())
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.Equal(t, int(dbVersion), a.Version())
+	assert.NoError(t, a.Close())
+	assert.Equal(t, -1, a.Version())
+
+	// reopen the database stamped with the current version.
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.Equal(t, int(dbVersion), a.Version())
+	assert.NoError(t, a.Close())
+}
+
+func TestEncryptionKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	config := `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m", "encryption_key": "short"}`
+	assert.Error(t, a.Open(config))
+
+	config = `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m", "encryption_key": "4BWm1vZletvrCDGWsF6mex8oBSd59m6I"}`
+	assert.NoError(t, a.Open(config))
+	contract := uint32(3376684800)
+	topic := []byte("unit1.secret")
+	assert.NoError(t, a.Put(contract, topic, []byte("secret")))
+	assert.NoError(t, a.Close())
+
+	assert.NoError(t, a.Open(config))
+	defer a.Close()
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("secret")}, matches)
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.readonly")
+	a := newAdapter()
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	assert.NoError(t, a.Close())
+
+	config := `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m", "read_only": true}`
+	assert.NoError(t, a.Open(config))
+	defer a.Close()
+	assert.True(t, errors.Is(a.Put(contract, topic, []byte("msg")), dbadapter.ErrReadOnly))
+	assert.True(t, errors.Is(a.PutWithID(contract, a.db.NewID(), topic, []byte("msg")), dbadapter.ErrReadOnly))
+	assert.True(t, errors.Is(a.Delete(contract, a.db.NewID(), topic), dbadapter.ErrReadOnly))
+
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestGetPage(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.page")
+	payloads := make([][]byte, 2500)
+	for i := range payloads {
+		payloads[i] = []byte("msg")
+	}
+	assert.NoError(t, a.BatchPut(contract, topic, payloads))
+
+	var cursor []byte
+	var pages []int
+	for {
+		matches, next, err := a.GetPage(contract, topic, cursor, 1000)
+		assert.NoError(t, err)
+		pages = append(pages, len(matches))
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, []int{1000, 1000, 500}, pages)
+
+	// a cursor that matches no message is rejected
+	matches, next, err := a.GetPage(contract, topic, a.db.NewID(), 1000)
+	assert.True(t, errors.Is(err, dbadapter.ErrNotFound))
+	assert.Nil(t, matches)
+	assert.Nil(t, next)
+}
+
+func TestBatchDelete(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.delete")
+	var ids [][]byte
+	for i := 0; i < 3; i++ {
+		id := a.db.NewID()
+		assert.NoError(t, a.PutWithID(contract, id, topic, []byte("msg")))
+		ids = append(ids, id)
+	}
+
+	// delete two existing, one nonexistent and one malformed messageId.
+	err := a.BatchDelete(contract, topic, [][]byte{ids[0], ids[1], a.db.NewID(), nil})
+	assert.Error(t, err)
+
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+	ok, err := a.Exists(contract, topic, ids[2])
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestResolvePath(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	home, err := os.UserHomeDir()
+	assert.NoError(t, err)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/tmp/unitdb/", "/tmp/unitdb"},
+		{"/tmp//unitdb", "/tmp/unitdb"},
+		{"unitdb", filepath.Join(wd, "unitdb")},
+		{"./data/../unitdb", filepath.Join(wd, "unitdb")},
+		{"~/unitdb", filepath.Join(home, "unitdb")},
+	}
+	for _, tt := range tests {
+		path, err := resolvePath(tt.path)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, path)
+	}
+}
+
+// temporaryError is a transient write failure.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary error" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestWriteRetry(t *testing.T) {
+	a := &adapter{config: &configType{Options: Options{WriteRetries: 3}, retryBackoff: time.Millisecond}}
+
+	attempts := 0
+	err := a.retry(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return temporaryError{}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// errors that are not transient are not retried
+	attempts = 0
+	err = a.retry(context.Background(), func() error {
+		attempts++
+		return errors.New("disk full")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// retries stop once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	err = a.retry(ctx, func() error {
+		attempts++
+		return temporaryError{}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	// no retries by default
+	a.config.WriteRetries = 0
+	attempts = 0
+	err = a.retry(context.Background(), func() error {
+		attempts++
+		return temporaryError{}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestPutRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", WriteRetries: 3, WriteRetryBackoff: "1ms"}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	// the first two writes fail transiently and the third is written to the database
+	attempts := 0
+	a.putEntry = func(db *unitdb.DB, entry *unitdb.Entry) error {
+		if attempts++; attempts < 3 {
+			return temporaryError{}
+		}
+		return db.PutEntry(entry)
+	}
+	contract := uint32(3376684800)
+	topic := []byte("unit1.retry")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg1")))
+	assert.Equal(t, 3, attempts)
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg1")}, matches)
+
+	attempts = 0
+	a.putEntry = func(db *unitdb.DB, entry *unitdb.Entry) error {
+		attempts++
+		return errors.New("disk full")
+	}
+	assert.Error(t, a.Put(contract, topic, []byte("msg2")))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestGetWildcard(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	for _, topic := range []string{"sensors.room1.temp", "sensors.room2.temp", "sensors.room1.hum"} {
+		assert.NoError(t, a.Put(contract, []byte(topic), []byte(topic)))
+	}
+
+	assert.Equal(t, []byte("sensors.*.temp"), wildcardTopic([]byte("sensors/+/temp")))
+	assert.Equal(t, []byte("sensors..."), wildcardTopic([]byte("sensors/#")))
+
+	matches, err := a.GetWildcard(contract, []byte("sensors/+/temp"), 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+
+	matches, err = a.GetWildcard(contract, []byte("sensors/#"), 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 3)
+}
+
+func TestGetOrdered(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.ordered")
+	for _, payload := range []string{"1", "2", "3", "4"} {
+		assert.NoError(t, a.Put(contract, topic, []byte(payload)))
+		time.Sleep(time.Millisecond)
+	}
+
+	matches, err := a.GetOrdered(contract, topic, 3, false)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), []byte("3")}, matches)
+
+	matches, err = a.GetOrdered(contract, topic, 3, true)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("4"), []byte("3"), []byte("2")}, matches)
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	env := newEnvelope([]byte("id"), []byte("msg"))
+	env.qos = 1
+	decoded, err := decodeEnvelope(env.encode())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("id"), decoded.id)
+	assert.Equal(t, []byte("msg"), decoded.payload)
+	assert.Equal(t, uint8(1), decoded.qos)
+	assert.True(t, env.timestamp.Equal(decoded.timestamp))
+
+	// data without the envelope magic is the raw payload of a version 1 message
+	decoded, err = decodeEnvelope([]byte("v1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), decoded.payload)
+	assert.Nil(t, decoded.id)
+	assert.True(t, decoded.timestamp.IsZero())
+
+	_, err = decodeEnvelope(env.encode()[:envelopeHeaderSize-1])
+	assert.Equal(t, errInvalidEnvelope, err)
+}
+
+func TestCompression(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"temp": 21.5, "unit": "C"}`), 100)
+	for name, codec := range codecs {
+		a := &adapter{config: &configType{codec: codec}}
+		env, err := a.compress(newEnvelope([]byte("id"), payload))
+		assert.NoError(t, err, name)
+		decoded, err := decodeEnvelope(env.encode())
+		assert.NoError(t, err, name)
+		assert.Equal(t, payload, decoded.payload, name)
+		assert.Equal(t, []byte("id"), decoded.id, name)
+	}
+}
+
+func BenchmarkCompression(b *testing.B) {
+	payload := bytes.Repeat([]byte(`{"temp": 21.5, "unit": "C"}`), 100)
+	for _, name := range []string{"none", "snappy", "gzip"} {
+		a := &adapter{config: &configType{codec: codecs[name]}}
+		b.Run(name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				env, err := a.compress(newEnvelope(nil, payload))
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(env.payload)
+			}
+			b.ReportMetric(float64(size)/float64(len(payload)), "ratio")
+		})
+	}
+}
+
+type testLogger struct {
+	errors []string
+}
+
+func (l *testLogger) Error(context, msg string) { l.errors = append(l.errors, context+": "+msg) }
+func (l *testLogger) Info(context, msg string)  {}
+func (l *testLogger) Debug(context, msg string) {}
+
+func TestSetLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a file in place of the db dir fails to create the db dir
+	path := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(path, nil, 0600))
+
+	l := &testLogger{}
+	a := newAdapter()
+	a.SetLogger(l)
+	assert.Error(t, a.Open(testConfig(filepath.Join(path, "db"))))
+	assert.Equal(t, []string{"adapter.Open: Unable to create db dir"}, l.errors)
+
+	a.SetLogger(nil)
+	assert.Equal(t, dbadapter.DefaultLogger{}, a.logger)
+}
+
+func TestTopics(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topics, err := a.Topics(contract)
+	assert.NoError(t, err)
+	assert.NotNil(t, topics)
+	assert.Len(t, topics, 0)
+
+	for _, topic := range []string{"unit1.b", "unit1.a", "unit1.b", "unit1.c"} {
+		assert.NoError(t, a.Put(contract, []byte(topic), []byte("msg")))
+	}
+	_, err = a.DeleteByTopic(contract, []byte("unit1.c"))
+	assert.NoError(t, err)
+
+	topics, err = a.Topics(contract)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("unit1.a"), []byte("unit1.b")}, topics)
+
+	// topics are loaded from the index on reopen
+	a.index.reset()
+	topics, err = a.Topics(contract)
+	assert.NoError(t, err)
+	assert.Len(t, topics, 2)
+}
+
+func TestContracts(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contracts, err := a.Contracts(0)
+	assert.NoError(t, err)
+	assert.Len(t, contracts, 0)
+
+	for _, contract := range []uint32{3376684800, 1, 3376684800, 42} {
+		assert.NoError(t, a.Put(contract, []byte("unit1.contracts"), []byte("msg")))
+	}
+
+	contracts, err = a.Contracts(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 42, 3376684800}, contracts)
+
+	contracts, err = a.Contracts(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 42}, contracts)
+
+	// contracts are loaded from the index on reopen
+	a.index.reset()
+	contracts, err = a.Contracts(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{1, 42, 3376684800}, contracts)
+}
+
+func TestContractIsolation(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract1, contract2 := uint32(3376684800), uint32(3376684801)
+	topic := []byte("unit1.isolation")
+	id1, err := a.PutReturningID(contract1, topic, []byte("contract1"))
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, a.Put(contract2, topic, []byte("contract2")))
+	}
+
+	matches, err := a.Get(contract1, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("contract1")}, matches)
+	matches, err = a.Get(contract2, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("contract2"), []byte("contract2")}, matches)
+
+	count, err := a.Count(contract2, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// deleting the messageId under the other contract does not delete the message
+	assert.NoError(t, a.Delete(contract2, id1, topic))
+	ok, err := a.Exists(contract1, topic, id1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	deleted, err := a.DeleteByTopic(contract2, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	count, err = a.Count(contract1, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	// metadata contract is not reachable through the adapter API
+	_, err = a.Get(metaContract, []byte(metaVersion), 1)
+	assert.Equal(t, errReservedContract, err)
+	assert.Equal(t, errReservedContract, a.Put(metaContract, []byte(metaVersion), []byte("msg")))
+}
+
+func TestSequenceIDMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	config := `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m", "id_mode": "random"}`
+	assert.Error(t, a.Open(config))
+
+	config = `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m", "id_mode": "sequence"}`
+	assert.NoError(t, a.Open(config))
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.seq")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	}
+	messages, err := a.GetMessages(contract, topic, 10)
+	assert.NoError(t, err)
+	var seqs []uint64
+	for _, m := range messages {
+		seqs = append(seqs, m.Seq)
+	}
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, seqs)
+
+	// counters of other topics are independent
+	seq, err := a.NextSeq(contract, []byte("unit1.other"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+
+	// concurrent callers get distinct sequence numbers
+	var wg sync.WaitGroup
+	results := make(chan uint64, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seq, err := a.NextSeq(contract, topic)
+			assert.NoError(t, err)
+			results <- seq
+		}()
+	}
+	wg.Wait()
+	close(results)
+	seen := make(map[uint64]bool)
+	for seq := range results {
+		assert.False(t, seen[seq])
+		seen[seq] = true
+	}
+	assert.Len(t, seen, 10)
+
+	// counters are loaded from the store
+	a.seqs.reset()
+	seq, err = a.NextSeq(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(14), seq)
+}
+
+func TestBatchPutResultSequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.Open(`{"dir": "`+dir+`", "mem_size": 1000000, "log_release_duration": "1m", "id_mode": "sequence"}`))
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.seqbatch")
+	seqs := func() []uint64 {
+		messages, err := a.GetMessages(contract, topic, 10)
+		assert.NoError(t, err)
+		var seqs []uint64
+		for _, m := range messages {
+			seqs = append(seqs, m.Seq)
+		}
+		return seqs
+	}
+
+	// a failed atomic batch burns no sequence numbers
+	_, err = a.BatchPutResult(contract, topic, [][]byte{[]byte("msg1"), nil}, true)
+	assert.True(t, errors.Is(err, dbadapter.ErrEmptyPayload))
+	_, err = a.BatchPutResult(contract, topic, [][]byte{[]byte("msg1"), []byte("msg2"), []byte("msg3")}, true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{1, 2, 3}, seqs())
+
+	// numbers reserved for a batch that aborts are released
+	first, err := a.reserveSeq(contract, topic, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4), first)
+	a.commitSeq(contract, topic, first, 2, false)
+	_, err = a.BatchPutResult(contract, topic, [][]byte{[]byte("msg4")}, true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{1, 2, 3, 4}, seqs())
+
+	// the counter is persisted once the batch commits
+	a.seqs.reset()
+	assert.NoError(t, a.Put(contract, topic, []byte("msg5")))
+	assert.ElementsMatch(t, []uint64{1, 2, 3, 4, 5}, seqs())
+}
+
+func TestPutOptions(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.options")
+	id := a.db.NewID()
+	assert.NoError(t, a.Put(contract, topic, []byte("msg"), dbadapter.WithID(id), dbadapter.WithQoS(1), dbadapter.WithTTL(time.Hour)))
+	assert.NoError(t, a.Put(contract, topic, []byte("plain")))
+
+	messages, err := a.GetMessages(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	for _, m := range messages {
+		if bytes.Equal(m.ID, id) {
+			assert.Equal(t, uint8(1), m.Qos)
+			assert.Equal(t, []byte("msg"), m.Payload)
+		} else {
+			assert.Equal(t, uint8(0), m.Qos)
+			assert.Equal(t, []byte("plain"), m.Payload)
+		}
+	}
+
+	assert.Error(t, a.Put(contract, topic, []byte("msg"), dbadapter.WithQoS(3)))
+	assert.Error(t, a.Put(contract, topic, []byte("msg"), dbadapter.WithID(a.db.NewID()), dbadapter.WithTTL(-time.Second)))
+}
+
+// expiryOf returns the expiry of the single message stored on the topic.
+func expiryOf(t *testing.T, a *adapter, contract uint32, topic []byte) int64 {
+	var expiries []int64
+	assert.NoError(t, a.topicItems(context.Background(), contract, topic, 10, func(env envelope) bool {
+		expiries = append(expiries, env.expiry)
+		return true
+	}))
+	if !assert.Len(t, expiries, 1) {
+		return 0
+	}
+	return expiries[0]
+}
+
+func TestPutWithTTL(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.ttl")
+	assert.NoError(t, a.PutWithTTL(contract, topic, []byte("msg"), time.Hour))
+	assert.Equal(t, now.Add(time.Hour).UnixNano(), expiryOf(t, a, contract, topic))
+
+	// a ttl longer than max ttl is clamped to max ttl
+	topic = []byte("unit1.ttl.max")
+	assert.NoError(t, a.PutWithTTL(contract, topic, []byte("msg"), 2*maxTTLDur))
+	assert.Equal(t, now.Add(maxTTLDur).UnixNano(), expiryOf(t, a, contract, topic))
+
+	// a negative ttl is rejected and nothing is stored
+	topic = []byte("unit1.ttl.negative")
+	assert.Error(t, a.PutWithTTL(contract, topic, []byte("msg"), -time.Second))
+	messages, err := a.GetMessages(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestPutWhileClose(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.close")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := a.Put(contract, topic, []byte("msg")); err != nil {
+					assert.True(t, errors.Is(err, dbadapter.ErrClosed))
+				}
+				if _, err := a.Get(contract, topic, 10); err != nil {
+					assert.True(t, errors.Is(err, dbadapter.ErrClosed))
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, a.Close())
+	wg.Wait()
+
+	assert.True(t, errors.Is(a.Put(contract, topic, []byte("msg")), dbadapter.ErrClosed))
+	_, err := a.Count(contract, topic)
+	assert.True(t, errors.Is(err, dbadapter.ErrClosed))
+}
+
+func TestPurgeExpired(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	contract := uint32(3376684800)
+	topic1, topic2 := []byte("unit1.purge1"), []byte("unit1.purge2")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.PutWithTTL(contract, topic1, []byte("expiring"), time.Minute))
+		assert.NoError(t, a.PutWithTTL(contract, topic2, []byte("expiring"), time.Minute))
+	}
+	assert.NoError(t, a.PutWithTTL(contract, topic1, []byte("later"), time.Hour))
+	assert.NoError(t, a.Put(contract, topic1, []byte("never")))
+
+	purged, err := a.PurgeExpired(contract, topic1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	// advance the clock past the TTL of expiring messages
+	now = now.Add(2 * time.Minute)
+	purged, err = a.PurgeExpired(contract, topic1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+	matches, err := a.Get(contract, topic1, 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("later"), []byte("never")}, matches)
+
+	// nil topic purges all topics of the contract
+	purged, err = a.PurgeExpired(contract, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+	count, err := a.Count(contract, topic2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestTouch(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.touch")
+	id, err := a.PutReturningID(contract, topic, []byte("session"))
+	assert.NoError(t, err)
+	assert.NoError(t, a.Touch(contract, topic, id, time.Minute))
+	assert.True(t, errors.Is(a.Touch(contract, topic, a.db.NewID(), time.Minute), dbadapter.ErrNotFound))
+
+	// touching again before expiry slides the expiry
+	now = now.Add(45 * time.Second)
+	assert.NoError(t, a.Touch(contract, topic, id, time.Minute))
+	now = now.Add(45 * time.Second)
+	purged, err := a.PurgeExpired(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+
+	payload, ok, err := a.GetByID(contract, topic, id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("session"), payload)
+
+	now = now.Add(time.Minute)
+	purged, err = a.PurgeExpired(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+}
+
+func TestGetOffset(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.offset")
+	payloads := make([][]byte, 25)
+	for i := range payloads {
+		payloads[i] = []byte("msg")
+	}
+	assert.NoError(t, a.BatchPut(contract, topic, payloads))
+
+	var pages []int
+	for offset := 0; offset < 30; offset += 10 {
+		matches, err := a.GetOffset(contract, topic, offset, 10)
+		assert.NoError(t, err)
+		pages = append(pages, len(matches))
+	}
+	assert.Equal(t, []int{10, 10, 5}, pages)
+
+	matches, err := a.GetOffset(contract, topic, 100, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 0)
+
+	_, err = a.GetOffset(contract, topic, -1, 10)
+	assert.Error(t, err)
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxResults: -1}))
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxResults: 10}))
+	defer a.Close()
+	assert.Equal(t, 10, a.config.MaxResults)
+	assert.Equal(t, defaultDatabase, a.config.Name)
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.options")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg")}, matches)
+
+	// topics are deleted in batches of max results messages
+	for i := 0; i < 24; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	deleted, err := a.DeleteByTopic(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 25, deleted)
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestSyncWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", SyncWrites: true}))
+	contract := uint32(3376684800)
+	topic := []byte("unit1.sync")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	assert.NoError(t, a.BatchPut(contract, topic, [][]byte{[]byte("msg"), []byte("msg")}))
+	assert.NoError(t, a.Close())
+
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m"}))
+	defer a.Close()
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+}
+
+func TestCloseContext(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.CloseContext(ctx); err != nil {
+		assert.Equal(t, context.Canceled, err)
+	}
+	// the adapter is closed even if the context is done before the close completes
+	assert.False(t, a.IsOpen())
+	assert.Equal(t, -1, a.version)
+	assert.True(t, errors.Is(a.Ping(), dbadapter.ErrClosed))
+}
+
+func TestAutoReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", AutoReconnect: true}))
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.reconnect")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+
+	// close the underlying database to fail the next operation
+	assert.NoError(t, a.db.Close())
+	assert.Error(t, a.Put(contract, topic, []byte("msg")))
+
+	var stats dbadapter.Stats
+	for i := 0; i < 100; i++ {
+		if stats, err = a.Stats(); err == nil && !stats.LastReconnect.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, stats.LastReconnect.IsZero())
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+// writeV1Fixture writes a version 1 database and returns messageIds of the messages of
+// each topic, version 1 databases store raw payloads and have no version stamp.
+func writeV1Fixture(t *testing.T, dir string, contract uint32, topics [][]byte) map[string][][]byte {
+	db, err := openDB(&configType{Options: Options{Dir: dir, ValueDir: dir, Name: defaultDatabase}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ids := make(map[string][][]byte)
+	for _, topic := range topics {
+		for i := 0; i < 3; i++ {
+			id := db.NewID()
+			entry := unitdb.NewEntry(topic, []byte("v1"))
+			entry.WithContract(contract)
+			if err := db.PutEntry(entry.WithID(id)); err != nil {
+				t.Fatal(err)
+			}
+			ids[string(topic)] = append(ids[string(topic)], id)
+		}
+	}
+	return ids
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contract := uint32(3376684800)
+	topics := [][]byte{[]byte("unit1.v1a"), []byte("unit1.v1b")}
+	ids := writeV1Fixture(t, dir, contract, topics)
+
+	// a read-only database is read in place and not migrated
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", ReadOnly: true}))
+	assert.Equal(t, 0, a.Version())
+	matches, err := a.Get(contract, topics[0], 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v1"), []byte("v1")}, matches)
+	assert.Equal(t, dbadapter.ErrReadOnly, a.Migrate())
+	assert.NoError(t, a.Close())
+
+	// the database is migrated on open
+	assert.NoError(t, a.Open(testConfig(dir)))
+	defer a.Close()
+	assert.Equal(t, int(dbVersion), a.Version())
+	for _, topic := range topics {
+		msgs, err := a.GetMessages(contract, topic, 10)
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 3)
+		for _, m := range msgs {
+			assert.Equal(t, []byte("v1"), m.Payload)
+			assert.Nil(t, m.ID)
+			assert.True(t, m.Timestamp.IsZero())
+		}
+	}
+
+	// version 1 messages keep their keys, so messageIds held by clients delete them
+	assert.NoError(t, a.Delete(contract, ids[string(topics[0])][0], topics[0]))
+	count, err := a.Count(contract, topics[0])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// messages written after the migration are read along with version 1 messages
+	assert.NoError(t, a.Put(contract, topics[0], []byte("v4")))
+	payload, ok, err := a.GetLast(contract, topics[0])
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v4"), payload)
+
+	// version 1 messages have no messageId a cursor can address, so they are not paged
+	page, next, err := a.GetPage(contract, topics[0], nil, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("v4")}, page)
+	assert.Nil(t, next)
+
+	// migrating an up to date database is a no-op
+	assert.NoError(t, a.Migrate())
+	count, err = a.Count(contract, topics[0])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	// the version is stamped on completion
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.Equal(t, int(dbVersion), a.Version())
+}
+
+func TestSentinelErrors(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.errors")
+	assert.True(t, errors.Is(a.Open(testConfig(a.config.Dir)), dbadapter.ErrAlreadyOpen))
+	_, err := a.Get(contract, topic, 0)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidLimit))
+	_, err = a.GetOffset(contract, topic, -1, 10)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidLimit))
+	assert.True(t, errors.Is(a.Touch(contract, topic, a.db.NewID(), time.Minute), dbadapter.ErrNotFound))
+
+	assert.NoError(t, a.Close())
+	_, err = a.Get(contract, topic, 10)
+	assert.True(t, errors.Is(err, dbadapter.ErrClosed))
+	assert.True(t, errors.Is(a.Delete(contract, nil, topic), dbadapter.ErrClosed))
+}
+
+func TestClosedAdapter(t *testing.T) {
+	opened, cleanup := newTestAdapter(t)
+	defer cleanup()
+	assert.NoError(t, opened.Close())
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.closed")
+	for name, a := range map[string]*adapter{"never opened": newAdapter(), "closed": opened} {
+		closed := func(err error) {
+			assert.True(t, errors.Is(err, dbadapter.ErrClosed), name+": %v", err)
+		}
+		closed(a.Put(contract, topic, []byte("msg")))
+		closed(a.PutWithID(contract, []byte("id"), topic, []byte("msg")))
+		closed(a.BatchPut(contract, topic, [][]byte{[]byte("msg")}))
+		_, err := a.Get(contract, topic, 10)
+		closed(err)
+		_, err = a.GetOffset(contract, topic, 0, 10)
+		closed(err)
+		_, _, err = a.GetLast(contract, topic)
+		closed(err)
+		_, err = a.Count(contract, topic)
+		closed(err)
+		_, err = a.Exists(contract, topic, []byte("id"))
+		closed(err)
+		_, err = a.NewID()
+		closed(err)
+		closed(a.Delete(contract, []byte("id"), topic))
+		_, err = a.DeleteByTopic(contract, topic)
+		closed(err)
+		closed(a.BatchDelete(contract, topic, [][]byte{[]byte("id")}))
+		closed(a.Sync())
+		closed(a.Compact())
+		_, err = a.Stats()
+		closed(err)
+		closed(a.Ping())
+		closed(a.PutMessage(1, 1, []byte("msg")))
+		_, err = a.GetMessage(1, 1)
+		closed(err)
+		closed(a.DeleteMessage(1, 1))
+		assert.Nil(t, a.Keys(1))
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+	h := NewHTTPHandler(a)
+
+	do := func(method, target, contentType string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/v1/messages?contract=3376684800&topic=unit1.http", "text/plain", []byte("raw"))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var put map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &put))
+	assert.NotEmpty(t, put["id"])
+
+	body, _ := json.Marshal(httpPutRequest{Contract: 3376684800, Topic: "unit1.http", Payload: []byte("json")})
+	w = do(http.MethodPost, "/v1/messages", contentTypeJSON, body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = do(http.MethodGet, "/v1/messages?contract=3376684800&topic=unit1.http&limit=10", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var msgs []httpMessage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+	assert.Len(t, msgs, 2)
+	// limit defaults to maxResults
+	w = do(http.MethodGet, "/v1/messages?contract=3376684800&topic=unit1.http", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+	assert.Len(t, msgs, 2)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/messages?contract=3376684800&topic=unit1.http", nil)
+	r.Header.Set("Accept", contentTypeRaw)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, contentTypeRaw, w.Header().Get("Content-Type"))
+	assert.NotEmpty(t, w.Header().Get("X-Message-Id"))
+
+	w = do(http.MethodGet, "/v1/messages?contract=3376684800&topic=unit1.http&limit=0", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do(http.MethodGet, "/v1/messages?topic=unit1.http", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(http.MethodDelete, "/v1/messages/"+put["id"]+"?contract=3376684800&topic=unit1.http", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	// deleting a message that is not stored succeeds
+	w = do(http.MethodDelete, "/v1/messages/"+put["id"]+"?contract=3376684800&topic=unit1.http", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do(http.MethodGet, "/v1/messages?contract=3376684800&topic=unit1.http", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+	assert.Len(t, msgs, 1)
+
+	w = do(http.MethodPut, "/v1/messages", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	a.Close()
+	w = do(http.MethodGet, "/v1/messages?contract=3376684800&topic=unit1.http", "", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestExportJSON(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	id1 := a.db.NewID()
+	assert.NoError(t, a.PutWithID(contract, id1, []byte("unit1.export"), []byte("msg1")))
+	assert.NoError(t, a.PutWithID(contract, a.db.NewID(), []byte("unit2.export"), []byte("msg2")))
+	assert.NoError(t, a.Put(contract+1, []byte("unit1.export"), []byte("other")))
+
+	var buf bytes.Buffer
+	assert.NoError(t, a.ExportJSON(contract, &buf))
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	var rec jsonRecord
+	assert.NoError(t, json.Unmarshal(lines[0], &rec))
+	assert.Equal(t, contract, rec.Contract)
+	assert.Equal(t, "unit1.export", rec.Topic)
+	assert.Equal(t, id1, rec.ID)
+	assert.Equal(t, []byte("msg1"), rec.Payload)
+	assert.False(t, rec.Timestamp.IsZero())
+}
+
+func TestImportJSON(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.import")
+	for i := 0; i < importBatchSize+5; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, a.ExportJSON(contract, &buf))
+	dump := buf.Bytes()
+
+	b, cleanupB := newTestAdapter(t)
+	defer cleanupB()
+	imported, skipped, err := b.ImportJSON(bytes.NewReader(dump), false)
+	assert.NoError(t, err)
+	assert.Equal(t, importBatchSize+5, imported)
+	assert.Equal(t, 0, skipped)
+	count, err := b.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(importBatchSize+5), count)
+
+	var exported bytes.Buffer
+	assert.NoError(t, b.ExportJSON(contract, &exported))
+	assert.Equal(t, len(dump), exported.Len())
+
+	malformed := append([]byte("{not json\n"), dump...)
+	c, cleanupC := newTestAdapter(t)
+	defer cleanupC()
+	imported, _, err = c.ImportJSON(bytes.NewReader(malformed), false)
+	assert.Error(t, err)
+	assert.Equal(t, 0, imported)
+	imported, skipped, err = c.ImportJSON(bytes.NewReader(malformed), true)
+	assert.NoError(t, err)
+	assert.Equal(t, importBatchSize+5, imported)
+	assert.Equal(t, 1, skipped)
+}
+
+func TestImportJSONValidation(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.import")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	var buf bytes.Buffer
+	assert.NoError(t, a.ExportJSON(contract, &buf))
+
+	b, cleanupB := newTestAdapter(t)
+	defer cleanupB()
+	var written int
+	b.OnWrite(func(contract uint32, topic, messageId, payload []byte) { written++ })
+
+	invalid := []byte(`{"contract": 3376684800, "topic": "unit1.import", "id": "aWQ=", "payload_base64": "bXNn"}` + "\n")
+	_, _, err := b.ImportJSON(bytes.NewReader(invalid), false)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidMessageID))
+	imported, skipped, err := b.ImportJSON(bytes.NewReader(append(invalid, buf.Bytes()...)), true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, 1, written)
+}
+
+func TestImportJSONSizeLimits(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	assert.NoError(t, a.Put(contract, []byte("unit1.import"), make([]byte, 8)))
+	assert.NoError(t, a.Put(contract, []byte("unit1.import"), make([]byte, 9)))
+	assert.NoError(t, a.Put(contract, []byte("unit1.import.long"), make([]byte, 8)))
+	var buf bytes.Buffer
+	assert.NoError(t, a.ExportJSON(contract, &buf))
+
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := newAdapter()
+	if err := b.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxPayloadBytes: 8, MaxTopicBytes: 12}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	_, _, err = b.ImportJSON(bytes.NewReader(buf.Bytes()), false)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, dbadapter.ErrPayloadTooLarge) || errors.Is(err, dbadapter.ErrTopicTooLong))
+	imported, skipped, err := b.ImportJSON(bytes.NewReader(buf.Bytes()), true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 2, skipped)
+	count, err := b.Count(contract, []byte("unit1.import"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Shards: -1}))
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Shards: 4}))
+	for i := 1; i < 4; i++ {
+		_, err := os.Stat(shardDir(dir, i))
+		assert.NoError(t, err)
+	}
+
+	contract := uint32(3376684800)
+	used := make(map[*unitdb.DB]bool)
+	for i := 0; i < 20; i++ {
+		topic := []byte("unit1.shard" + strconv.Itoa(i))
+		used[a.shardOf(contract, topic)] = true
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	assert.True(t, len(used) > 1)
+	for i := 0; i < 20; i++ {
+		matches, err := a.Get(contract, []byte("unit1.shard"+strconv.Itoa(i)), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("msg" + strconv.Itoa(i))}, matches)
+	}
+	matches, err := a.GetWildcard(contract, []byte("unit1/+"), 100)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 20)
+	topics, err := a.Topics(contract)
+	assert.NoError(t, err)
+	assert.Len(t, topics, 20)
+	assert.NoError(t, a.Sync())
+	_, err = a.Backup(ioutil.Discard)
+	assert.Error(t, err)
+	assert.NoError(t, a.Close())
+
+	// A sharded database cannot be opened with a different number of shards.
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Shards: 2}))
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Shards: 4}))
+	defer a.Close()
+	matches, err = a.Get(contract, []byte("unit1.shard7"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg7")}, matches)
+}
+
+func BenchmarkShardedPut(b *testing.B) {
+	for _, shards := range []int{1, 4} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "unitdb-adapter")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			a := newAdapter()
+			if err := a.OpenWithOptions(Options{Dir: dir, Size: 1 << 26, LogReleaseDur: "1m", Shards: shards}); err != nil {
+				b.Fatal(err)
+			}
+			defer a.Close()
+			payload := bytes.Repeat([]byte("x"), 256)
+			contract := uint32(3376684800)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					topic := []byte("unit1.bench" + strconv.Itoa(i%64))
+					if err := a.Put(contract, topic, payload); err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	var topics [][]byte
+	for i := 0; i < 20; i++ {
+		topic := []byte("unit1.multi" + strconv.Itoa(i))
+		topics = append(topics, topic)
+		for j := 0; j <= i%3; j++ {
+			assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+		}
+	}
+	topics = append(topics, topics[0], []byte("unit1.empty"))
+	matches, err := a.GetMulti(contract, topics, 2)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 21)
+	assert.Len(t, matches["unit1.multi0"], 1)
+	assert.Len(t, matches["unit1.multi2"], 2)
+	assert.Empty(t, matches["unit1.empty"])
+
+	_, err = a.GetMulti(contract, topics, 0)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidLimit))
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newAdapter()
+	if err := a.Open(testConfig(dir)); err != nil {
+		b.Fatal(err)
+	}
+	defer a.Close()
+	contract := uint32(3376684800)
+	var topics [][]byte
+	for i := 0; i < 32; i++ {
+		topic := []byte("unit1.multi" + strconv.Itoa(i))
+		topics = append(topics, topic)
+		for j := 0; j < 100; j++ {
+			if err := a.Put(contract, topic, []byte("msg")); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, topic := range topics {
+				if _, err := a.Get(contract, topic, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("multi", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := a.GetMulti(contract, topics, 10); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestGetPayloadsRetained(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.retain")
+	want := make(map[string]bool)
+	for i := 0; i < 500; i++ {
+		payload := bytes.Repeat([]byte(strconv.Itoa(i)), 10)
+		want[string(payload)] = true
+		assert.NoError(t, a.Put(contract, topic, payload))
+	}
+	check := func(matches [][]byte) {
+		assert.Len(t, matches, 500)
+		seen := make(map[string]bool)
+		for _, m := range matches {
+			assert.True(t, want[string(m)], "corrupted payload %q", m)
+			seen[string(m)] = true
+		}
+		assert.Len(t, seen, 500)
+	}
+	matches, err := a.Get(contract, topic, 1000)
+	assert.NoError(t, err)
+	check(matches)
+
+	dst := make([][]byte, 500)
+	for i := range dst {
+		dst[i] = make([]byte, 0, 64)
+	}
+	buf := &dst[0][:1][0]
+	matches, err = a.GetInto(contract, topic, 1000, dst)
+	assert.NoError(t, err)
+	check(matches)
+	// buffers of dst are reused
+	assert.True(t, buf == &matches[0][0])
+}
+
+func BenchmarkGet(b *testing.B) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newAdapter()
+	if err := a.Open(testConfig(dir)); err != nil {
+		b.Fatal(err)
+	}
+	defer a.Close()
+	contract := uint32(3376684800)
+	topic := []byte("unit1.bench")
+	for i := 0; i < maxResults; i++ {
+		if err := a.Put(contract, topic, []byte("msg")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matches, err := a.Get(contract, topic, maxResults)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(matches) != maxResults {
+			b.Fatalf("got %d matches", len(matches))
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	payload := bytes.Repeat([]byte(`{"temp": 21.5, "unit": "C"}`), 10)
+	for _, codec := range []string{"none", "gzip"} {
+		b.Run(codec, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "unitdb-adapter")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			a := newAdapter()
+			if err := a.OpenWithOptions(Options{Dir: dir, Size: 1 << 26, LogReleaseDur: "1m", Compression: codec}); err != nil {
+				b.Fatal(err)
+			}
+			defer a.Close()
+			contract := uint32(3376684800)
+			topic := []byte("unit1.bench")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := a.Put(contract, topic, payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyTopic(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	for _, topic := range [][]byte{nil, {}} {
+		err := a.Put(contract, topic, []byte("msg"))
+		assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+		err = a.PutWithID(contract, id, topic, []byte("msg"))
+		assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+		_, err = a.Get(contract, topic, 10)
+		assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+		err = a.Delete(contract, id, topic)
+		assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+		_, err = a.GetMulti(contract, [][]byte{topic}, 10)
+		assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+		_, err = a.GetRange(contract, topic, time.Now().Add(-time.Minute), time.Time{}, 10)
+		assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+	}
+	count, err := a.Count(contract, []byte("unit1.test"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestEmptyPayload(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	for _, payload := range [][]byte{nil, {}} {
+		assert.True(t, errors.Is(a.Put(contract, topic, payload), dbadapter.ErrEmptyPayload))
+		assert.True(t, errors.Is(a.PutWithID(contract, id, topic, payload), dbadapter.ErrEmptyPayload))
+		assert.True(t, errors.Is(a.BatchPut(contract, topic, [][]byte{[]byte("msg"), payload}), dbadapter.ErrEmptyPayload))
+	}
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := newAdapter()
+	if err := b.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", AllowEmptyPayload: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	assert.NoError(t, b.Put(contract, topic, nil))
+	assert.NoError(t, b.PutWithID(contract, id, topic, []byte{}))
+	matches, err := b.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+	for _, m := range matches {
+		assert.Empty(t, m)
+	}
+}
+
+func TestMaxPayloadBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxPayloadBytes: 8}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	assert.NoError(t, a.Put(contract, topic, make([]byte, 8)))
+	assert.NoError(t, a.PutWithID(contract, id, topic, make([]byte, 8)))
+	assert.True(t, errors.Is(a.Put(contract, topic, make([]byte, 9)), dbadapter.ErrPayloadTooLarge))
+	assert.True(t, errors.Is(a.PutWithID(contract, id, topic, make([]byte, 9)), dbadapter.ErrPayloadTooLarge))
+	assert.True(t, errors.Is(a.BatchPut(contract, topic, [][]byte{[]byte("msg"), make([]byte, 9)}), dbadapter.ErrPayloadTooLarge))
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	// 0 means unlimited
+	b, cleanup := newTestAdapter(t)
+	defer cleanup()
+	assert.NoError(t, b.Put(contract, topic, make([]byte, 1<<16)))
+
+	c := newAdapter()
+	assert.Error(t, c.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxPayloadBytes: -1}))
+}
+
+func TestMaxTopicBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxTopicBytes: 10}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic, long := []byte("unit1.test"), []byte("unit1.test1")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	// topic options are not counted
+	assert.NoError(t, a.Put(contract, []byte("unit1.test?ttl=1m"), []byte("msg")))
+	assert.NoError(t, a.BatchPut(contract, topic, [][]byte{[]byte("msg")}))
+	_, err = a.Increment(contract, topic, 1)
+	assert.NoError(t, err)
+
+	assert.True(t, errors.Is(a.Put(contract, long, []byte("msg")), dbadapter.ErrTopicTooLong))
+	assert.True(t, errors.Is(a.BatchPut(contract, long, [][]byte{[]byte("msg")}), dbadapter.ErrTopicTooLong))
+	_, err = a.Increment(contract, long, 1)
+	assert.True(t, errors.Is(err, dbadapter.ErrTopicTooLong))
+	count, err := a.Count(contract, long)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	c := newAdapter()
+	assert.Error(t, c.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxTopicBytes: -1}))
+}
+
+func TestInvalidMessageID(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	for _, messageId := range [][]byte{id[:len(id)-1], append(append([]byte(nil), id...), 0), []byte("6ba7b810-9dad-11d1-80b4-00c04fd430c8")} {
+		assert.True(t, errors.Is(a.PutWithID(contract, messageId, topic, []byte("msg")), dbadapter.ErrInvalidMessageID))
+		assert.True(t, errors.Is(a.Delete(contract, messageId, topic), dbadapter.ErrInvalidMessageID))
+	}
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	assert.NoError(t, a.PutWithID(contract, id, topic, []byte("msg")))
+	assert.NoError(t, a.Delete(contract, id, topic))
+}
+
+func TestCloseTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.Close())
+	assert.Equal(t, -1, a.Version())
+
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.CloseContext(context.Background()))
+	assert.False(t, a.IsOpen())
+	assert.Equal(t, -1, a.Version())
+
+	// concurrent closes from multiple shutdown paths
+	assert.NoError(t, a.Open(testConfig(dir)))
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- a.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, -1, a.Version())
+
+	// the adapter can be opened again after it is closed twice
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.Equal(t, int(dbVersion), a.Version())
+	assert.NoError(t, a.Close())
+}
+
+func TestOpenMkdirFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a file in place of a parent dir fails to create the db dir for any user
+	file := filepath.Join(dir, "file")
+	assert.NoError(t, ioutil.WriteFile(file, nil, 0600))
+	a := newAdapter()
+	err = a.Open(testConfig(filepath.Join(file, "db")))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to create db dir")
+	assert.False(t, a.IsOpen())
+	assert.Equal(t, -1, a.Version())
+
+	if os.Geteuid() != 0 {
+		readOnly := filepath.Join(dir, "readonly")
+		assert.NoError(t, os.Mkdir(readOnly, 0500))
+		err = a.Open(testConfig(filepath.Join(readOnly, "db")))
+		assert.True(t, errors.Is(err, os.ErrPermission))
+		assert.False(t, a.IsOpen())
+	}
+
+	// the adapter is reusable after a failed open
+	assert.NoError(t, a.Open(testConfig(filepath.Join(dir, "db"))))
+	assert.True(t, a.IsOpen())
+	assert.NoError(t, a.Close())
+}
+
+func TestWatch(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.watch")
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, err := a.Watch(ctx, contract, topic)
+	assert.NoError(t, err)
+	receive := func() (dbadapter.Message, bool) {
+		select {
+		case m, ok := <-msgs:
+			return m, ok
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for message")
+			return dbadapter.Message{}, false
+		}
+	}
+
+	payload := []byte("msg1")
+	id, err := a.PutReturningID(contract, topic, payload)
+	assert.NoError(t, err)
+	payload[0] = 'x'
+	m, ok := receive()
+	assert.True(t, ok)
+	assert.Equal(t, id, m.ID)
+	assert.Equal(t, []byte("msg1"), m.Payload)
+
+	// messages of other topics and contracts are not delivered
+	assert.NoError(t, a.Put(contract, []byte("unit2.watch"), []byte("other")))
+	assert.NoError(t, a.Put(contract+1, topic, []byte("other")))
+	assert.NoError(t, a.BatchPut(contract, topic, [][]byte{[]byte("msg2"), []byte("msg3")}))
+	m, _ = receive()
+	assert.Equal(t, []byte("msg2"), m.Payload)
+	m, _ = receive()
+	assert.Equal(t, []byte("msg3"), m.Payload)
+
+	cancel()
+	_, ok = receive()
+	assert.False(t, ok)
+
+	_, err = a.Watch(context.Background(), contract, []byte("unit1.*"))
+	assert.Error(t, err)
+
+	// the channel is closed when the adapter is closed
+	msgs, err = a.Watch(context.Background(), contract, topic)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Close())
+	_, ok = receive()
+	assert.False(t, ok)
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", WatchBuffer: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.watch")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs, err := a.Watch(ctx, contract, topic)
+	assert.NoError(t, err)
+
+	// writers are not blocked by a watcher that does not receive, messages past the buffer are dropped
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	assert.Equal(t, []byte("msg0"), (<-msgs).Payload)
+	select {
+	case m := <-msgs:
+		t.Fatalf("unexpected message %s", m.Payload)
+	default:
+	}
+}
+
+func TestWatchersBlockedSend(t *testing.T) {
+	ws := newWatchers()
+	contract := uint32(3376684800)
+	topic := []byte("unit1.watch")
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{contract: contract, topic: string(topicName(topic)), ch: make(chan dbadapter.Message), ctx: ctx, cancel: cancel}
+	ws.add(w)
+
+	sent := make(chan struct{})
+	go func() {
+		ws.notify(contract, topic, true, dbadapter.Message{Payload: []byte("msg")})
+		close(sent)
+	}()
+
+	// watchers are added and removed while a send is blocked on a watcher that does not receive
+	other := &watcher{contract: contract, topic: "unit1.other", ch: make(chan dbadapter.Message, 1)}
+	done := make(chan struct{})
+	go func() {
+		ws.add(other)
+		ws.remove(other)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchers blocked by a pending send")
+	}
+
+	// cancelling the watcher aborts the send before its channel is closed
+	cancel()
+	<-sent
+	ws.remove(w)
+	_, ok := <-w.ch
+	assert.False(t, ok)
+}
+
+func TestOnWrite(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.hook")
+	var calls []string
+	a.OnWrite(func(contract uint32, topic, messageId, payload []byte) {
+		calls = append(calls, "first "+string(payload))
+		panic("hook failed")
+	})
+	a.OnWrite(func(contract uint32, topic, messageId, payload []byte) {
+		calls = append(calls, "second "+string(payload))
+	})
+
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	assert.NoError(t, a.PutWithID(contract, id, topic, []byte("msg1")))
+	assert.Equal(t, []string{"first msg1", "second msg1"}, calls)
+
+	calls = nil
+	assert.NoError(t, a.BatchPut(contract, topic, [][]byte{[]byte("msg2"), []byte("msg3")}))
+	assert.Equal(t, []string{"first msg2", "second msg2", "first msg3", "second msg3"}, calls)
+
+	// hooks are not called for failed writes
+	calls = nil
+	assert.Error(t, a.PutWithID(contract, []byte("id"), topic, []byte("msg4")))
+	assert.Error(t, a.Put(contract, topic, nil))
+	assert.Empty(t, calls)
+}
+
+func TestOnExpire(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.expire")
+	var ids [][]byte
+	for i := 0; i < 2; i++ {
+		id, err := a.NewID()
+		assert.NoError(t, err)
+		assert.NoError(t, a.Put(contract, topic, []byte("expiring"), dbadapter.WithID(id), dbadapter.WithTTL(time.Minute)))
+		ids = append(ids, id)
+	}
+	assert.NoError(t, a.Put(contract, topic, []byte("never")))
+
+	var expired [][]byte
+	a.OnExpire(func(contract uint32, topic, messageId []byte) {
+		panic("hook failed")
+	})
+	a.OnExpire(func(c uint32, tp, messageId []byte) {
+		assert.Equal(t, contract, c)
+		assert.Equal(t, topic, tp)
+		expired = append(expired, messageId)
+	})
+
+	now = now.Add(2 * time.Minute)
+	purged, err := a.PurgeExpired(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.ElementsMatch(t, ids, expired)
+
+	// purged messages are reported once
+	expired = nil
+	purged, err = a.PurgeExpired(contract, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	assert.Empty(t, expired)
+
+	// reads drop expired messages and report them once
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	assert.NoError(t, a.Put(contract, topic, []byte("expiring"), dbadapter.WithID(id), dbadapter.WithTTL(time.Minute)))
+	now = now.Add(2 * time.Minute)
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("never")}, matches)
+	assert.Equal(t, [][]byte{id}, expired)
+	matches, err = a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("never")}, matches)
+	assert.Len(t, expired, 1)
+	purged, err = a.PurgeExpired(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+}
+
+func TestDefaultTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", DefaultTTL: "1x"}))
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", DefaultTTL: "48h"}))
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", DefaultTTL: "-1m"}))
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", DefaultTTL: "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.ttl")
+	assert.NoError(t, a.Put(contract, topic, []byte("default")))
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	assert.NoError(t, a.PutWithID(contract, id, topic, []byte("default")))
+	assert.NoError(t, a.BatchPut(contract, topic, [][]byte{[]byte("default")}))
+	// an explicit TTL overrides the default TTL
+	assert.NoError(t, a.PutWithTTL(contract, topic, []byte("never"), 0))
+	assert.NoError(t, a.PutWithTTL(contract, topic, []byte("later"), time.Hour))
+
+	now = now.Add(2 * time.Minute)
+	purged, err := a.PurgeExpired(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, purged)
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("never"), []byte("later")}, matches)
+}
+
+func TestMaxTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the default TTL must not be larger than max TTL
+	a := newAdapter()
+	assert.Error(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxTTL: "1h", DefaultTTL: "2h"}))
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxTTL: "1h", DefaultTTL: "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.maxttl.default")
+	assert.NoError(t, a.Put(contract, topic, []byte("default")))
+	assert.Equal(t, now.Add(time.Hour).UnixNano(), expiryOf(t, a, contract, topic))
+
+	// a TTL longer than max TTL expires at max TTL
+	topic = []byte("unit1.maxttl.longer")
+	assert.NoError(t, a.PutWithTTL(contract, topic, []byte("longer"), 2*time.Hour))
+	assert.Equal(t, now.Add(time.Hour).UnixNano(), expiryOf(t, a, contract, topic))
+	topic = []byte("unit1.maxttl.touched")
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	assert.NoError(t, a.PutWithID(contract, id, topic, []byte("touched")))
+	assert.NoError(t, a.Touch(contract, topic, id, 2*time.Hour))
+	assert.Equal(t, now.Add(time.Hour).UnixNano(), expiryOf(t, a, contract, topic))
+}
+
+func TestScanPrefix(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	assert.NoError(t, a.Put(contract, []byte("a.c"), []byte("ac1")))
+	assert.NoError(t, a.Put(contract, []byte("a.b"), []byte("ab1")))
+	assert.NoError(t, a.Put(contract, []byte("a.b"), []byte("ab2")))
+	assert.NoError(t, a.Put(contract, []byte("x.y"), []byte("xy1")))
+	assert.NoError(t, a.Put(contract+1, []byte("a.d"), []byte("other")))
+
+	msgs, err := a.ScanPrefix(contract, []byte("a."), 10)
+	assert.NoError(t, err)
+	var payloads []string
+	for _, m := range msgs {
+		payloads = append(payloads, string(m.Payload))
+	}
+	// messages are ordered by topic
+	assert.Len(t, payloads, 3)
+	assert.ElementsMatch(t, []string{"ab1", "ab2"}, payloads[:2])
+	assert.Equal(t, "ac1", payloads[2])
+
+	msgs, err = a.ScanPrefix(contract, []byte("a."), 2)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+
+	msgs, err = a.ScanPrefix(contract, nil, 10)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+
+	msgs, err = a.ScanPrefix(contract, []byte("z."), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	_, err = a.ScanPrefix(contract, []byte("a."), 0)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidLimit))
+}
+
+func TestPutAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxTimestampSkew: "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.backfill")
+	base := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	// backfill events out of order
+	for _, h := range []int{3, 1, 4, 0, 2} {
+		id, err := a.PutAt(contract, topic, []byte("event"+strconv.Itoa(h)), base.Add(time.Duration(h)*time.Hour))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, id)
+	}
+	assert.NoError(t, a.Put(contract, topic, []byte("live")))
+
+	matches, err := a.GetRange(contract, topic, base.Add(time.Hour), base.Add(3*time.Hour), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("event1"), []byte("event2"), []byte("event3")}, matches)
+
+	// the limit keeps the last messages of the range
+	matches, err = a.GetRange(contract, topic, base, time.Time{}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("event4"), []byte("live")}, matches)
+
+	msgs, err := a.GetMessages(contract, topic, 10)
+	assert.NoError(t, err)
+	for _, m := range msgs {
+		if string(m.Payload) == "event0" {
+			assert.True(t, base.Equal(m.Timestamp))
+		}
+	}
+
+	// timestamps too far in the future are rejected
+	_, err = a.PutAt(contract, topic, []byte("future"), time.Now().Add(time.Hour))
+	assert.Error(t, err)
+	_, err = a.PutAt(contract, topic, []byte("skewed"), time.Now().Add(time.Second))
+	assert.NoError(t, err)
+}
+
+func TestBatchPutResult(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	payloads := [][]byte{[]byte("msg1"), nil, []byte("msg3")}
+
+	// atomic batch stores no messages if an entry fails
+	topic := []byte("unit1.atomic")
+	ids, err := a.BatchPutResult(contract, topic, payloads, true)
+	assert.Nil(t, ids)
+	var batchErrs dbadapter.BatchErrors
+	assert.True(t, errors.As(err, &batchErrs))
+	assert.Len(t, batchErrs, 1)
+	assert.True(t, errors.Is(batchErrs[1], dbadapter.ErrEmptyPayload))
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+	assert.True(t, errors.Is(a.BatchPut(contract, topic, payloads), dbadapter.ErrEmptyPayload))
+
+	// non atomic batch stores the valid entries
+	topic = []byte("unit1.partial")
+	ids, err = a.BatchPutResult(contract, topic, payloads, false)
+	assert.True(t, errors.As(err, &batchErrs))
+	assert.Len(t, batchErrs, 1)
+	assert.True(t, errors.Is(batchErrs[1], dbadapter.ErrEmptyPayload))
+	assert.Len(t, ids, 3)
+	assert.NotNil(t, ids[0])
+	assert.Nil(t, ids[1])
+	assert.NotNil(t, ids[2])
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("msg1"), []byte("msg3")}, matches)
+	for _, i := range []int{0, 2} {
+		ok, err := a.Exists(contract, topic, ids[i])
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	ids, err = a.BatchPutResult(contract, topic, [][]byte{[]byte("msg4")}, false)
+	assert.NoError(t, err)
+	assert.Len(t, ids, 1)
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.dedupe")
+	id, err := a.NewID()
+	assert.NoError(t, err)
+
+	written, err := a.PutIfAbsent(contract, topic, id, []byte("msg"))
+	assert.NoError(t, err)
+	assert.True(t, written)
+	written, err = a.PutIfAbsent(contract, topic, id, []byte("retry"))
+	assert.NoError(t, err)
+	assert.False(t, written)
+	payload, ok, err := a.GetByID(contract, topic, id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("msg"), payload)
+
+	_, err = a.PutIfAbsent(contract, topic, []byte("id"), []byte("msg"))
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidMessageID))
+
+	// concurrent duplicate submissions write the message once
+	id, err = a.NewID()
+	assert.NoError(t, err)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	writes := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			written, err := a.PutIfAbsent(contract, topic, id, []byte("concurrent"))
+			assert.NoError(t, err)
+			if written {
+				mu.Lock()
+				writes++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, writes)
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.cas")
+
+	// nil expectedId writes the first message of the topic
+	id1, ok, err := a.CompareAndSwap(contract, topic, nil, []byte("v1"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = a.CompareAndSwap(contract, topic, nil, []byte("v1"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	id2, ok, err := a.CompareAndSwap(contract, topic, id1, []byte("v2"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	// stale expectedId fails the precondition
+	_, ok, err = a.CompareAndSwap(contract, topic, id1, []byte("stale"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	payload, ok, err := a.GetLast(contract, topic)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v2"), payload)
+
+	// of concurrent writers expecting the same message exactly one succeeds
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var swapped [][]byte
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			newId, ok, err := a.CompareAndSwap(contract, topic, id2, []byte("v3."+strconv.Itoa(i)))
+			assert.NoError(t, err)
+			if ok {
+				mu.Lock()
+				swapped = append(swapped, newId)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, swapped, 1)
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+	_, ok, err = a.CompareAndSwap(contract, topic, swapped[0], []byte("v4"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestPutSerializedWithConditionalWrites(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.cas")
+
+	// Put and BatchPut wait for the lock of the topic held by a conditional write
+	unlock := a.topicLocks.lock(contract, topic)
+	done := make(chan error, 2)
+	go func() { done <- a.Put(contract, topic, []byte("put")) }()
+	go func() { done <- a.BatchPut(contract, topic, [][]byte{[]byte("batch")}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("write did not wait for the topic lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestIncrement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.Open(testConfig(dir)))
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.counter")
+	value, err := a.Increment(contract, topic, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), value)
+	value, err = a.Increment(contract, topic, -7)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2), value)
+
+	// concurrent increments of the same counter do not lose updates
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := a.Increment(contract, topic, 1)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	value, err = a.Increment(contract, topic, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(198), value)
+
+	// counters are independent of messages and persisted across opens
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.Open(testConfig(dir)))
+	defer a.Close()
+	value, err = a.Increment(contract, topic, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(200), value)
+	value, err = a.Increment(contract, []byte("unit2.counter"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+}
+
+func TestTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Shards: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, a.Put(contract, []byte("unit"+strconv.Itoa(i)+".truncate"), []byte("msg")))
+	}
+	_, err = a.Increment(contract, []byte("unit1.truncate"), 5)
+	assert.NoError(t, err)
+
+	// concurrent reads see either the old or the empty database
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			matches, err := a.Get(contract, []byte("unit1.truncate"), 10)
+			assert.NoError(t, err)
+			assert.True(t, len(matches) <= 1)
+		}
+	}()
+	assert.NoError(t, a.Truncate())
+	close(stop)
+	wg.Wait()
+
+	assert.True(t, a.IsOpen())
+	assert.Equal(t, int(dbVersion), a.Version())
+	for i := 0; i < 10; i++ {
+		count, err := a.Count(contract, []byte("unit"+strconv.Itoa(i)+".truncate"))
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), count)
+	}
+	topics, err := a.Topics(contract)
+	assert.NoError(t, err)
+	assert.Empty(t, topics)
+	value, err := a.Increment(contract, []byte("unit1.truncate"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+
+	// the truncated database is writable and reopens with the same shards
+	assert.NoError(t, a.Put(contract, []byte("unit1.truncate"), []byte("msg")))
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Shards: 2}))
+	count, err := a.Count(contract, []byte("unit1.truncate"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestDropContract(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	dropped, kept := uint32(3376684800), uint32(3376684801)
+	for _, contract := range []uint32{dropped, kept} {
+		for i := 0; i < 3; i++ {
+			topic := []byte("unit" + strconv.Itoa(i) + ".drop")
+			assert.NoError(t, a.BatchPut(contract, topic, [][]byte{[]byte("msg1"), []byte("msg2")}))
+			_, err := a.NextSeq(contract, topic)
+			assert.NoError(t, err)
+			_, err = a.Increment(contract, topic, 2)
+			assert.NoError(t, err)
+		}
+	}
+
+	deleted, err := a.DropContract(dropped)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, deleted)
+
+	topics, err := a.Topics(dropped)
+	assert.NoError(t, err)
+	assert.Empty(t, topics)
+	contracts, err := a.Contracts(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{kept}, contracts)
+	seq, err := a.NextSeq(dropped, []byte("unit1.drop"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+	value, err := a.Increment(dropped, []byte("unit1.drop"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+
+	topics, err = a.Topics(kept)
+	assert.NoError(t, err)
+	assert.Len(t, topics, 3)
+	for _, topic := range topics {
+		count, err := a.Count(kept, topic)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(2), count)
+	}
+	seq, err = a.NextSeq(kept, []byte("unit1.drop"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), seq)
+
+	// dropping a contract without messages deletes nothing
+	deleted, err = a.DropContract(3376684802)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+}
+
+func TestConfigValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := func() Options {
+		return Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m"}
+	}
+	tests := []struct {
+		field string
+		opts  func(o *Options)
+	}{
+		{"dir", func(o *Options) { o.Dir = "" }},
+		{"mem_size", func(o *Options) { o.Size = -1 }},
+		{"max_results", func(o *Options) { o.MaxResults = -1 }},
+		{"max_ttl", func(o *Options) { o.MaxTTL = "-1h" }},
+		{"max_ttl", func(o *Options) { o.MaxTTL = "1x" }},
+		{"write_retries", func(o *Options) { o.WriteRetries = -1 }},
+		{"write_retry_backoff", func(o *Options) { o.WriteRetryBackoff = "-10ms" }},
+		{"log_release_duration", func(o *Options) { o.LogReleaseDur = "" }},
+		{"log_release_duration", func(o *Options) { o.LogReleaseDur = "-1m" }},
+		{"get_multi_workers", func(o *Options) { o.GetMultiWorkers = -1 }},
+		{"max_payload_bytes", func(o *Options) { o.MaxPayloadBytes = -1 }},
+		{"max_topic_bytes", func(o *Options) { o.MaxTopicBytes = -1 }},
+		{"watch_buffer", func(o *Options) { o.WatchBuffer = -1 }},
+		{"watch_policy", func(o *Options) { o.WatchPolicy = "wait" }},
+		{"shards", func(o *Options) { o.Shards = -1 }},
+		{"encryption_key", func(o *Options) { o.EncryptionKey = "short" }},
+		{"database", func(o *Options) { o.Name = "../unitd" }},
+		{"dir_perm", func(o *Options) { o.DirPerm = "0999" }},
+	}
+	a := newAdapter()
+	for _, tt := range tests {
+		opts := valid()
+		tt.opts(&opts)
+		err := a.OpenWithOptions(opts)
+		if assert.Error(t, err, tt.field) {
+			assert.Contains(t, err.Error(), tt.field)
+		}
+		assert.False(t, a.IsOpen())
+	}
+
+	// unknown keys are rejected
+	config := `{"dir": "` + dir + `", "mem_size": 1000000, "log_release_duration": "1m", "max_result": 10}`
+	err = a.Open(config)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "max_result")
+	}
+	assert.NoError(t, a.OpenWithOptions(valid()))
+	assert.NoError(t, a.Close())
+}
+
+func TestEmptyDir(t *testing.T) {
+	wd, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(wd); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+
+	a := newAdapter()
+	assert.Error(t, a.Open(`{"mem_size": 1000000, "log_release_duration": "1m"}`))
+	assert.Error(t, a.OpenWithOptions(Options{Size: 1000000, LogReleaseDur: "1m"}))
+	assert.False(t, a.IsOpen())
+
+	// nothing is written to the working directory
+	files, err := ioutil.ReadDir(wd)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestExpandEnv(t *testing.T) {
+	home, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	os.Setenv("UNITDB_TEST_HOME", home)
+	defer os.Unsetenv("UNITDB_TEST_HOME")
+	os.Unsetenv("UNITDB_TEST_MISSING")
+
+	for path, want := range map[string]string{
+		"$UNITDB_TEST_HOME/db":    filepath.Join(home, "db"),
+		"${UNITDB_TEST_HOME}/db":  filepath.Join(home, "db"),
+		"$UNITDB_TEST_HOME/$$db":  filepath.Join(home, "$db"),
+		filepath.Join(home, "db"): filepath.Join(home, "db"),
+	} {
+		got, err := expandEnv(path)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err = expandEnv("$UNITDB_TEST_MISSING/db")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "UNITDB_TEST_MISSING")
+	}
+
+	a := newAdapter()
+	err = a.OpenWithOptions(Options{Dir: "$UNITDB_TEST_MISSING/db", Size: 1000000, LogReleaseDur: "1m"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "dir")
+	}
+	assert.NoError(t, a.Open(`{"dir": "$UNITDB_TEST_HOME/db", "value_dir": "${UNITDB_TEST_HOME}/values", "mem_size": 1000000, "log_release_duration": "1m"}`))
+	defer a.Close()
+	assert.Equal(t, filepath.Join(home, "db"), a.config.Dir)
+	assert.Equal(t, filepath.Join(home, "values"), a.config.ValueDir)
+	assert.NoError(t, a.Put(3376684800, []byte("unit1.test"), []byte("msg")))
+}
+
+func TestValueDir(t *testing.T) {
+	parent, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+
+	// the value dir defaults to the db dir
+	dir := filepath.Join(parent, "db")
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m"}))
+	assert.Equal(t, dir, a.config.ValueDir)
+	assert.NoError(t, a.Close())
+
+	// the value dir is created and holds the log files if it is set
+	dir = filepath.Join(parent, "db2")
+	valueDir := filepath.Join(parent, "value")
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, ValueDir: valueDir, Size: 1000000, LogReleaseDur: "1m"}))
+	defer a.Close()
+	assert.Equal(t, valueDir, a.config.ValueDir)
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	assert.NoError(t, a.Sync())
+	files, err := ioutil.ReadDir(valueDir)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+}
+
+func TestInMemory(t *testing.T) {
+	contract := uint32(3376684800)
+	topic := []byte("unit1.test")
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Size: 1000000, LogReleaseDur: "1m", InMemory: true}))
+	dir := a.config.Dir
+	assert.Equal(t, filepath.Clean(os.TempDir()), filepath.Dir(dir))
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+	assert.NoError(t, a.Close())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	// messages do not persist across Close and Open
+	assert.NoError(t, a.OpenWithOptions(Options{Size: 1000000, LogReleaseDur: "1m", InMemory: true}))
+	count, err = a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), count)
+	assert.NoError(t, a.Close())
+
+	// the temp dir is created under dir
+	parent, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: parent, Size: 1000000, LogReleaseDur: "1m", InMemory: true}))
+	assert.Equal(t, parent, filepath.Dir(a.config.Dir))
+	assert.NoError(t, a.Close())
+	files, err := ioutil.ReadDir(parent)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.Error(t, a.OpenWithOptions(Options{ValueDir: parent, Size: 1000000, LogReleaseDur: "1m", InMemory: true}))
+	assert.Error(t, a.OpenWithOptions(Options{Size: 1000000, LogReleaseDur: "1m", InMemory: true, ReadOnly: true}))
+}
+
+func TestBackup(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.backup")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	var buf bytes.Buffer
+	n, err := a.Backup(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	// the archive starts with the database version followed by the database files.
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, backupVersionFile, hdr.Name)
+	version, err := ioutil.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.FormatFloat(dbVersion, 'f', -1, 64), string(version))
+	files := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hdr.Name, backupDBDir) || strings.HasPrefix(hdr.Name, backupValueDir))
+		files++
+	}
+	assert.NotZero(t, files)
+
+	// the database is writable once the backup completes.
+	assert.NoError(t, a.Put(contract, topic, []byte("after backup")))
+
+	// reads and writes are not blocked while the snapshot is written to a slow writer
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Backup(pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	_, err = pr.Read(make([]byte, 1))
+	assert.NoError(t, err)
+	assert.NoError(t, a.Put(contract, topic, []byte("during backup")))
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 3)
+	_, err = io.Copy(ioutil.Discard, pr)
+	assert.NoError(t, err)
+	assert.NoError(t, <-done)
+
+	// the staging dirs are removed once the backup completes
+	stages, err := filepath.Glob(filepath.Join(a.config.Dir, backupStageDir+"*"))
+	assert.NoError(t, err)
+	assert.Empty(t, stages)
+
+	assert.NoError(t, a.Close())
+	_, err = a.Backup(ioutil.Discard)
+	assert.Equal(t, dbadapter.ErrClosed, err)
+}
+
+func TestBackupRestore(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.backup")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	var buf bytes.Buffer
+	n, err := a.Backup(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+
+	assert.NoError(t, a.Put(contract, topic, []byte("after backup")))
+	assert.Error(t, a.Restore(bytes.NewReader(buf.Bytes()), false))
+
+	assert.NoError(t, a.Restore(bytes.NewReader(buf.Bytes()), true))
+	matches, err := a.Get(contract, topic, 100)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 3)
+	assert.Equal(t, int(dbVersion), a.Version())
+	assert.NoError(t, a.Put(contract, topic, []byte("after restore")))
+
+	for _, dir := range []string{restoreDir, restoreOldDir} {
+		_, err := os.Stat(filepath.Join(a.config.Dir, dir))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestRestoreTruncated(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.backup")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	var buf bytes.Buffer
+	_, err := a.Backup(&buf)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Put(contract, topic, []byte("after backup")))
+
+	// the database is left untouched and open if the snapshot cannot be read
+	err = a.Restore(bytes.NewReader(buf.Bytes()[:buf.Len()/2]), true)
+	assert.Error(t, err)
+	assert.True(t, a.IsOpen())
+	matches, err := a.Get(contract, topic, 100)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 4)
+	_, err = os.Stat(filepath.Join(a.config.Dir, restoreDir))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPutContext(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.putctx")
+	assert.NoError(t, a.PutContext(context.Background(), contract, topic, []byte("msg1")))
+
+	// the write is aborted if the context is already done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, a.PutContext(ctx, contract, topic, []byte("msg2")))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	assert.Equal(t, context.DeadlineExceeded, a.PutContext(ctx, contract, topic, []byte("msg3")))
+
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg1")}, matches)
+}
+
+func TestGetContext(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.getctx")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	matches, err := a.GetContext(context.Background(), contract, topic, 2)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+
+	// the iteration is stopped and no matches are returned if the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	matches, err = a.GetContext(ctx, contract, topic, 10)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Nil(t, matches)
+}
+
+func TestCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	if err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", MaxResults: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.count")
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	// the count is not capped at max results
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	assert.NoError(t, a.Put(contract, []byte("unit1.other"), []byte("other")))
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+	count, err = a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), count)
+
+	_, err = a.Count(contract, nil)
+	assert.True(t, errors.Is(err, dbadapter.ErrEmptyTopic))
+}
+
+func TestExists(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.exists")
+	id, err := a.PutReturningID(contract, topic, []byte("msg"))
+	assert.NoError(t, err)
+	ok, err := a.Exists(contract, topic, id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// the messageId is only found under the topic and contract it was written to
+	ok, err = a.Exists(contract, []byte("unit1.other"), id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = a.Exists(contract+1, topic, id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, a.Delete(contract, id, topic))
+	ok, err = a.Exists(contract, topic, id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	r := metrics.NewMetrics()
+	assert.NoError(t, a.RegisterMetrics(r))
+	// registering the metrics of the adapter again is a no-op
+	assert.NoError(t, a.RegisterMetrics(r))
+	count := func(name string) int64 {
+		return r.GetOrRegister(name, metrics.NewCounter()).(metrics.Counter).Count()
+	}
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.metrics")
+	id, err := a.PutReturningID(contract, topic, []byte("msg"))
+	assert.NoError(t, err)
+	assert.Error(t, a.Put(contract, topic, nil))
+	_, err = a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Delete(contract, id, topic))
+
+	assert.Equal(t, int64(2), count("unitdb_put_total"))
+	assert.Equal(t, int64(1), count("unitdb_put_errors"))
+	assert.Equal(t, int64(1), count("unitdb_get_total"))
+	assert.Equal(t, int64(0), count("unitdb_get_errors"))
+	assert.Equal(t, int64(1), count("unitdb_delete_total"))
+	assert.Equal(t, int64(0), count("unitdb_delete_errors"))
+	assert.NotNil(t, r.GetOrRegister("unitdb_put_timeseries_ns", nil))
+
+	// metrics of another adapter are not registered under the same names
+	err = newAdapter().RegisterMetrics(r)
+	assert.True(t, errors.As(err, new(metrics.DuplicateMetric)))
+}
+
+// recordingTracer records the spans started by the adapter.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name     string
+	contract uint32
+	topic    []byte
+	size     int
+	err      error
+	ended    bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, contract uint32, topic []byte) (context.Context, dbadapter.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordedSpan{name: name, contract: contract, topic: topic}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordedSpan) End(size int, err error) {
+	s.size, s.err, s.ended = size, err, true
+}
+
+func TestSpans(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	tracer := &recordingTracer{}
+	a.SetTracer(tracer)
+	contract := uint32(3376684800)
+	topic := []byte("unit1.spans")
+	assert.NoError(t, a.PutContext(context.Background(), contract, topic, []byte("msg")))
+	matches, err := a.GetContext(context.Background(), contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Error(t, a.DeleteContext(context.Background(), contract, []byte("id"), topic))
+
+	assert.Len(t, tracer.spans, 3)
+	for i, name := range []string{"unitdb.Put", "unitdb.Get", "unitdb.Delete"} {
+		assert.Equal(t, name, tracer.spans[i].name)
+		assert.Equal(t, contract, tracer.spans[i].contract)
+		assert.Equal(t, topic, tracer.spans[i].topic)
+		assert.True(t, tracer.spans[i].ended)
+	}
+	assert.Equal(t, 1, tracer.spans[1].size)
+
+	// errors are recorded on the span
+	assert.NoError(t, tracer.spans[0].err)
+	assert.Error(t, tracer.spans[2].err)
+
+	// a nil tracer restores the no-op tracer
+	a.SetTracer(nil)
+	assert.NoError(t, a.PutContext(context.Background(), contract, topic, []byte("msg")))
+	assert.Len(t, tracer.spans, 3)
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.Open(testConfig(dir)))
+	contract := uint32(3376684800)
+	topic := []byte("unit1.compact")
+	ids, err := a.BatchPutResult(contract, topic, [][]byte{[]byte("msg1"), []byte("msg2"), []byte("msg3")}, true)
+	assert.NoError(t, err)
+	assert.NoError(t, a.BatchDelete(contract, topic, ids[:2]))
+	assert.NoError(t, a.Compact())
+
+	// writes and deletes are on disk once Compact returns
+	assert.NoError(t, a.Close())
+	assert.Equal(t, dbadapter.ErrClosed, a.Compact())
+	assert.NoError(t, a.Open(testConfig(dir)))
+	defer a.Close()
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg3")}, matches)
+	assert.NoError(t, a.Put(contract, topic, []byte("msg4")))
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+}
+
+func TestStats(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	stats, err := a.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, int(dbVersion), stats.Version)
+	assert.True(t, stats.LastReconnect.IsZero())
+	count := stats.Count
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.stats")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	assert.NoError(t, a.Sync())
+	stats, err = a.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, count+3, stats.Count)
+	size, err := a.FileSize()
+	assert.NoError(t, err)
+	assert.Equal(t, size, stats.Size)
+	assert.NotZero(t, stats.Size)
+
+	assert.NoError(t, a.Close())
+	_, err = a.Stats()
+	assert.Equal(t, dbadapter.ErrClosed, err)
+}
+
+func TestPing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.Equal(t, dbadapter.ErrClosed, a.Ping())
+	assert.NoError(t, a.Open(testConfig(dir)))
+	assert.NoError(t, a.Ping())
+	assert.NoError(t, a.Close())
+	assert.Equal(t, dbadapter.ErrClosed, a.Ping())
+
+	// a version 1 database responds once it is migrated on open
+	v1Dir := filepath.Join(dir, "v1")
+	assert.NoError(t, os.MkdirAll(v1Dir, 0777))
+	writeV1Fixture(t, v1Dir, 3376684800, [][]byte{[]byte("unit1.v1")})
+	assert.NoError(t, a.Open(testConfig(v1Dir)))
+	defer a.Close()
+	assert.NoError(t, a.Ping())
+}
+
+func TestStream(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.stream")
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+
+	// the channels are closed once the stream completes
+	payloads, errc := a.Stream(context.Background(), contract, topic)
+	var streamed [][]byte
+	for payload := range payloads {
+		streamed = append(streamed, payload)
+	}
+	assert.NoError(t, <-errc)
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, matches, streamed)
+
+	// the stream stops and reports the context error once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	payloads, errc = a.Stream(ctx, contract, topic)
+	<-payloads
+	cancel()
+	for range payloads {
+	}
+	assert.Equal(t, context.Canceled, <-errc)
+
+	payloads, errc = a.Stream(context.Background(), contract, nil)
+	_, ok := <-payloads
+	assert.False(t, ok)
+	assert.True(t, errors.Is(<-errc, dbadapter.ErrEmptyTopic))
+}
+
+func TestDeleteByTopic(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.delete")
+	other := []byte("unit1.keep")
+	// more messages than a single delete batch holds
+	payloads := make([][]byte, maxResults+10)
+	for i := range payloads {
+		payloads[i] = []byte("msg" + strconv.Itoa(i))
+	}
+	assert.NoError(t, a.BatchPut(contract, topic, payloads))
+	assert.NoError(t, a.Put(contract, other, []byte("keep")))
+
+	deleted, err := a.DeleteByTopic(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, len(payloads), deleted)
+	count, err := a.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+	count, err = a.Count(contract, other)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+
+	// deleting a topic without messages deletes nothing
+	deleted, err = a.DeleteByTopic(contract, topic)
+	assert.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func TestPutReturningID(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.returning")
+	id1, err := a.PutReturningID(contract, topic, []byte("msg1"))
+	assert.NoError(t, err)
+	id2, err := a.PutReturningID(contract, topic, []byte("msg2"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+
+	// the returned messageId addresses the message written
+	payload, ok, err := a.GetByID(contract, topic, id1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("msg1"), payload)
+	assert.NoError(t, a.Delete(contract, id2, topic))
+	matches, err := a.Get(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("msg1")}, matches)
+
+	// no messageId is returned if the write fails
+	id, err := a.PutReturningID(contract, topic, nil)
+	assert.True(t, errors.Is(err, dbadapter.ErrEmptyPayload))
+	assert.Nil(t, id)
+}
+
+func TestDirPerm(t *testing.T) {
+	parent, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	tests := []struct {
+		dirPerm string
+		perm    os.FileMode
+	}{
+		{"", defaultDirPerm},
+		{"0750", 0750},
+	}
+	a := newAdapter()
+	for i, tt := range tests {
+		dir := filepath.Join(parent, strconv.Itoa(i), "db")
+		valueDir := filepath.Join(parent, strconv.Itoa(i), "value")
+		assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, ValueDir: valueDir, Size: 1000000, LogReleaseDur: "1m", DirPerm: tt.dirPerm}))
+		assert.NoError(t, a.Close())
+		// the db dir and the value dir are created with the permission
+		for _, d := range []string{dir, valueDir} {
+			fi, err := os.Stat(d)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.perm, fi.Mode().Perm(), d)
+		}
+	}
+}
+
+func TestDatabaseName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Name: "custom"}))
+	assert.NoError(t, a.Put(3376684800, []byte("unit1.name"), []byte("msg")))
+	assert.NoError(t, a.Close())
+	files, err := filepath.Glob(filepath.Join(dir, "custom*"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+	files, err = filepath.Glob(filepath.Join(dir, defaultDatabase+"*"))
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	// names that would place the database outside the db dir are rejected
+	for _, name := range []string{"../unitd", "sub/unitd", `sub\unitd`, ".."} {
+		err := a.OpenWithOptions(Options{Dir: dir, Size: 1000000, LogReleaseDur: "1m", Name: name})
+		assert.Error(t, err, name)
+		assert.False(t, a.IsOpen())
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	parent, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "db")
+	valueDir := filepath.Join(parent, "value")
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: dir, ValueDir: valueDir, Size: 1000000, LogReleaseDur: "1m"}))
+	defer a.Close()
+	contract := uint32(3376684800)
+	topic := []byte("unit1.size")
+	payloads := make([][]byte, 100)
+	for i := range payloads {
+		payloads[i] = bytes.Repeat([]byte("x"), 1024)
+	}
+	assert.NoError(t, a.BatchPut(contract, topic, payloads))
+	assert.NoError(t, a.Sync())
+
+	// the size is the total size of the database files in the db dir and the value dir
+	size, err := a.FileSize()
+	assert.NoError(t, err)
+	var want int64
+	for _, d := range []string{dir, valueDir} {
+		files, err := filepath.Glob(filepath.Join(d, defaultDatabase+"*"))
+		assert.NoError(t, err)
+		for _, path := range files {
+			fi, err := os.Stat(path)
+			assert.NoError(t, err)
+			want += fi.Size()
+		}
+	}
+	assert.Equal(t, want, size)
+	assert.NotZero(t, size)
+
+	assert.NoError(t, a.Close())
+	_, err = a.FileSize()
+	assert.Equal(t, dbadapter.ErrClosed, err)
+}
+
+func TestSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitdb-adapter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := newAdapter()
+	assert.NoError(t, a.OpenWithOptions(Options{Dir: filepath.Join(dir, "db"), Size: 1000000, LogReleaseDur: "1m"}))
+	defer a.Close()
+	contract := uint32(3376684800)
+	topic := []byte("unit1.sync")
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, a.Put(contract, topic, []byte("msg"+strconv.Itoa(i))))
+	}
+	assert.NoError(t, a.Sync())
+
+	// the messages are on disk once Sync returns, a copy of the database files taken while
+	// the database is open holds them.
+	copyDir := filepath.Join(dir, "copy")
+	assert.NoError(t, os.MkdirAll(copyDir, 0700))
+	files, err := ioutil.ReadDir(filepath.Join(dir, "db"))
+	assert.NoError(t, err)
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "db", fi.Name()))
+		assert.NoError(t, err)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(copyDir, fi.Name()), data, 0600))
+	}
+	b := newAdapter()
+	assert.NoError(t, b.OpenWithOptions(Options{Dir: copyDir, Size: 1000000, LogReleaseDur: "1m"}))
+	defer b.Close()
+	count, err := b.Count(contract, topic)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), count)
+
+	assert.NoError(t, a.Close())
+	assert.Equal(t, dbadapter.ErrClosed, a.Sync())
+}
+
+func TestGetLast(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.last")
+	_, ok, err := a.GetLast(contract, topic)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// the most recent message is chosen by its time, not by the order it was written in
+	now := time.Now()
+	_, err = a.PutAt(contract, topic, []byte("latest"), now.Add(-time.Second))
+	assert.NoError(t, err)
+	_, err = a.PutAt(contract, topic, []byte("older"), now.Add(-time.Minute))
+	assert.NoError(t, err)
+	payload, ok, err := a.GetLast(contract, topic)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("latest"), payload)
+
+	assert.NoError(t, a.Put(contract, topic, []byte("now")))
+	payload, ok, err = a.GetLast(contract, topic)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("now"), payload)
+}
+
+func TestGetByID(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.byid")
+	var ids [][]byte
+	for i := 0; i < 3; i++ {
+		id, err := a.PutReturningID(contract, topic, []byte("msg"+strconv.Itoa(i)))
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	for i, id := range ids {
+		payload, ok, err := a.GetByID(contract, topic, id)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("msg"+strconv.Itoa(i)), payload)
+	}
+
+	// a messageId that is not stored for the topic is not found
+	id, err := a.NewID()
+	assert.NoError(t, err)
+	payload, ok, err := a.GetByID(contract, topic, id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, payload)
+	_, ok, err = a.GetByID(contract, []byte("unit1.other"), ids[0])
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, a.Delete(contract, ids[1], topic))
+	_, ok, err = a.GetByID(contract, topic, ids[1])
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestGetMessages(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	topic := []byte("unit1.messages")
+	ts := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	written := make(map[string]time.Time)
+	for i := 0; i < 3; i++ {
+		id, err := a.PutAt(contract, topic, []byte("msg"+strconv.Itoa(i)), ts.Add(time.Duration(i)*time.Second))
+		assert.NoError(t, err)
+		written[string(id)] = ts.Add(time.Duration(i) * time.Second)
+	}
+
+	// messages carry the messageId and the time they were stored along with the payload
+	msgs, err := a.GetMessages(contract, topic, 10)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	for _, m := range msgs {
+		at, ok := written[string(m.ID)]
+		if assert.True(t, ok) {
+			assert.True(t, at.Equal(m.Timestamp))
+			payload, _, err := a.GetByID(contract, topic, m.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, m.Payload)
+		}
+	}
+	msgs, err = a.GetMessages(contract, topic, 2)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	_, err = a.GetMessages(contract, topic, 0)
+	assert.True(t, errors.Is(err, dbadapter.ErrInvalidLimit))
+}
+
+func TestPutWithQoS(t *testing.T) {
+	a, cleanup := newTestAdapter(t)
+	defer cleanup()
+
+	contract := uint32(3376684800)
+	for qos := uint8(0); qos <= maxQoS; qos++ {
+		topic := []byte("unit1.qos" + strconv.Itoa(int(qos)))
+		assert.NoError(t, a.PutWithQoS(contract, topic, []byte("msg"), qos))
+		msgs, err := a.GetMessages(contract, topic, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, msgs, 1) {
+			assert.Equal(t, qos, msgs[0].Qos)
+		}
+	}
+
+	// messages written without QoS have QoS level 0 and invalid levels are rejected
+	topic := []byte("unit1.qos")
+	assert.NoError(t, a.Put(contract, topic, []byte("msg")))
+	assert.Error(t, a.PutWithQoS(contract, topic, []byte("msg"), maxQoS+1))
+	msgs, err := a.GetMessages(contract, topic, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, uint8(0), msgs[0].Qos)
+	}
+}
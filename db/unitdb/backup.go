@@ -0,0 +1,422 @@
+package adapter
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+const (
+	// backupVersionFile is the first file in the backup archive, it holds the database version.
+	backupVersionFile = "VERSION"
+	// Backup archive dirs for files from the db dir and the value dir.
+	backupDBDir    = "db/"
+	backupValueDir = "value/"
+	// Dirs under the db dir and the value dir the snapshot is extracted to, and the database
+	// files are moved aside to while the snapshot is restored.
+	restoreDir    = ".restore"
+	restoreOldDir = ".restore-old"
+	// Prefix of the dirs under the db dir and the value dir the database files are copied
+	// to while a backup is written.
+	backupStageDir = ".backup"
+)
+
+// countWriter counts bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Backup writes a point-in-time snapshot of the database files to w as a tar archive
+// and returns number of bytes written. The database files are copied to staging dirs
+// under the write lock of the adapter, so reads and writes of the database are blocked
+// while the files are copied but not while the snapshot is written to w.
+func (a *adapter) Backup(w io.Writer) (int64, error) {
+	stage, err := a.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer stage.removeDirs()
+
+	cw := &countWriter{w: w}
+	tw := tar.NewWriter(cw)
+	version := []byte(strconv.FormatFloat(dbVersion, 'f', -1, 64))
+	if err := tw.WriteHeader(&tar.Header{Name: backupVersionFile, Mode: 0600, Size: int64(len(version))}); err != nil {
+		return cw.n, err
+	}
+	if _, err := tw.Write(version); err != nil {
+		return cw.n, err
+	}
+	if err := a.backupFiles(tw, stage.Dir, backupDBDir); err != nil {
+		return cw.n, err
+	}
+	if stage.ValueDir != stage.Dir {
+		if err := a.backupFiles(tw, stage.ValueDir, backupValueDir); err != nil {
+			return cw.n, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// snapshot syncs the database and copies the database files to staging dirs under the db
+// dir and the value dir, it returns the config of the staging dirs.
+func (a *adapter) snapshot() (stage configType, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db == nil {
+		return stage, dbadapter.ErrClosed
+	}
+	if len(a.shards) > 0 {
+		return stage, errShardedUnsupported
+	}
+	if err := a.db.Sync(); err != nil {
+		return stage, err
+	}
+
+	stage = *a.config
+	if stage.Dir, err = ioutil.TempDir(a.config.Dir, backupStageDir); err != nil {
+		return stage, err
+	}
+	stage.ValueDir = stage.Dir
+	if a.config.ValueDir != a.config.Dir {
+		if stage.ValueDir, err = ioutil.TempDir(a.config.ValueDir, backupStageDir); err != nil {
+			os.RemoveAll(stage.Dir)
+			return stage, err
+		}
+	}
+	if err := stage.copyFiles(stage.Dir, a.config.Dir); err != nil {
+		stage.removeDirs()
+		return stage, err
+	}
+	if stage.ValueDir != stage.Dir {
+		if err := stage.copyFiles(stage.ValueDir, a.config.ValueDir); err != nil {
+			stage.removeDirs()
+			return stage, err
+		}
+	}
+	return stage, nil
+}
+
+// Restore reads a snapshot written by Backup and replaces the database files with files
+// from the snapshot. Restoring into a non-empty database is rejected unless force is set.
+// The snapshot is extracted to a staging dir and opened there before the database files
+// are replaced, so a truncated or invalid snapshot leaves the database untouched. If the
+// restored database fails to open the previous database files are put back.
+func (a *adapter) Restore(r io.Reader, force bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db == nil {
+		return dbadapter.ErrClosed
+	}
+	if len(a.shards) > 0 {
+		return errShardedUnsupported
+	}
+	if a.config.ReadOnly {
+		return dbadapter.ErrReadOnly
+	}
+
+	if !force && a.db.Count() > 0 {
+		return errors.New("unitdb adapter cannot restore into a non-empty database")
+	}
+
+	stage := *a.config
+	stage.Dir = filepath.Join(a.config.Dir, restoreDir)
+	stage.ValueDir = filepath.Join(a.config.ValueDir, restoreDir)
+	stage.AutoReconnect = false
+	defer stage.removeDirs()
+	if err := stage.extractBackup(r); err != nil {
+		return err
+	}
+	if err := a.checkBackup(&stage); err != nil {
+		return err
+	}
+
+	// swap the database files with files of the snapshot
+	if err := a.db.Close(); err != nil {
+		return err
+	}
+	a.db = nil
+	a.version = -1
+	a.index.reset()
+	a.seqs.reset()
+	a.counters.reset()
+	old := stage
+	old.Dir = filepath.Join(a.config.Dir, restoreOldDir)
+	old.ValueDir = filepath.Join(a.config.ValueDir, restoreOldDir)
+	if err := a.config.swapFiles(&stage, &old); err != nil {
+		return a.reopen("adapter.Restore", err)
+	}
+	if err := a.openFiles(); err != nil {
+		if err := a.config.swapFiles(&old, &stage); err != nil {
+			a.logger.Error("adapter.Restore", "Unable to put back database files: "+err.Error())
+		}
+		return a.reopen("adapter.Restore", err)
+	}
+	return old.removeDirs()
+}
+
+// extractBackup extracts the snapshot to the db dir and the value dir of the config, the
+// dirs are created and any files in the dirs are removed first.
+func (c *configType) extractBackup(r io.Reader) error {
+	if err := c.removeDirs(); err != nil {
+		return err
+	}
+	for _, dir := range []string{c.Dir, c.ValueDir} {
+		if err := os.MkdirAll(dir, c.dirPerm); err != nil {
+			return err
+		}
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return errors.New("unitdb adapter failed to read backup: " + err.Error())
+	}
+	if hdr.Name != backupVersionFile {
+		return errors.New("unitdb adapter invalid backup, missing version header")
+	}
+	version, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return errors.New("unitdb adapter failed to read backup: " + err.Error())
+	}
+	if v, err := strconv.ParseFloat(string(version), 64); err != nil || v != dbVersion {
+		return errors.New("unitdb adapter backup version " + string(version) + " does not match database version " + strconv.FormatFloat(dbVersion, 'f', -1, 64))
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New("unitdb adapter failed to read backup: " + err.Error())
+		}
+		var dir string
+		switch {
+		case strings.HasPrefix(hdr.Name, backupDBDir):
+			dir = c.Dir
+		case strings.HasPrefix(hdr.Name, backupValueDir):
+			dir = c.ValueDir
+		default:
+			return errors.New("unitdb adapter invalid backup file " + hdr.Name)
+		}
+		if err := restoreFile(tr, filepath.Join(dir, filepath.Base(hdr.Name))); err != nil {
+			return errors.New("unitdb adapter failed to read backup: " + err.Error())
+		}
+	}
+}
+
+// checkBackup opens the database extracted to the staging dirs of the config and checks
+// its version and shards.
+func (a *adapter) checkBackup(config *configType) error {
+	db, err := openDB(config)
+	if err != nil {
+		return errors.New("unitdb adapter invalid backup: " + err.Error())
+	}
+	staged := &adapter{
+		db:       db,
+		config:   config,
+		logger:   a.logger,
+		index:    newTopicIndex(),
+		seqs:     newSequences(),
+		counters: newCounters(),
+		now:      a.now,
+	}
+	if err := staged.checkVersion(); err != nil {
+		db.Close()
+		return err
+	}
+	if staged.version != int(dbVersion) {
+		db.Close()
+		return fmt.Errorf("%w: backup database version %d, adapter version %d", dbadapter.ErrVersionMismatch, staged.version, int(dbVersion))
+	}
+	if err := staged.checkShards(); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// openFiles opens the database files in the database dirs after they are replaced and
+// checks the version and the shards of the database, the database and the shards are
+// closed if a check fails.
+func (a *adapter) openFiles() error {
+	var err error
+	if a.db, err = openDB(a.config); err != nil {
+		a.db = nil
+		return err
+	}
+	if err = a.checkVersion(); err == nil {
+		err = a.checkShards()
+	}
+	if err == nil {
+		a.shards, err = openShards(a.config)
+	}
+	if err != nil {
+		a.db.Close()
+		a.db, a.shards, a.version = nil, nil, -1
+		return err
+	}
+	return nil
+}
+
+// reopen opens the database files in the database dirs after the files failed to be
+// replaced and returns err.
+func (a *adapter) reopen(context string, err error) error {
+	if openErr := a.openFiles(); openErr != nil {
+		a.logger.Error(context, "Unable to reopen db: "+openErr.Error())
+	}
+	return err
+}
+
+// swapFiles moves the database files of all shards in the dirs of the config aside to the
+// dirs of old and moves the database files in the dirs of src to the dirs of the config.
+// If moving a file fails the files moved are moved back.
+func (c *configType) swapFiles(src, old *configType) error {
+	var dirs [][3]string
+	for i := 0; i < c.Shards; i++ {
+		dirs = append(dirs, [3]string{shardDir(c.Dir, i), shardDir(src.Dir, i), shardDir(old.Dir, i)})
+		if c.ValueDir != c.Dir {
+			dirs = append(dirs, [3]string{shardDir(c.ValueDir, i), shardDir(src.ValueDir, i), shardDir(old.ValueDir, i)})
+		}
+	}
+	for i, d := range dirs {
+		if err := c.swapDir(d[0], d[1], d[2]); err != nil {
+			for _, d := range dirs[:i] {
+				c.swapDir(d[0], d[2], d[1])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// swapDir moves the database files in the dir to the old dir and moves the database files
+// from the src dir to the dir. If moving the src files fails the old files are moved back.
+func (c *configType) swapDir(dir, src, old string) error {
+	if err := os.MkdirAll(dir, c.dirPerm); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(old, c.dirPerm); err != nil {
+		return err
+	}
+	if err := c.moveFiles(old, dir); err != nil {
+		c.moveFiles(dir, old)
+		return err
+	}
+	if err := c.moveFiles(dir, src); err != nil {
+		c.moveFiles(src, dir)
+		c.moveFiles(dir, old)
+		return err
+	}
+	return nil
+}
+
+// moveFiles moves the database files in the src dir to the dir.
+func (c *configType) moveFiles(dir, src string) error {
+	files, err := c.dbFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFiles copies the database files in the src dir to the dir.
+func (c *configType) copyFiles(dir, src string) error {
+	files, err := c.dbFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := copyFile(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return restoreFile(f, dst)
+}
+
+// removeDirs removes the db dir and the value dir of the config.
+func (c *configType) removeDirs() error {
+	if err := os.RemoveAll(c.Dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(c.ValueDir)
+}
+
+// backupFiles writes the database files in the dir to the tar archive under the archive dir.
+func (a *adapter) backupFiles(tw *tar.Writer, dir, archiveDir string) error {
+	files, err := a.config.dbFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := backupFile(tw, path, archiveDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func backupFile(tw *tar.Writer, path, archiveDir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = archiveDir + fi.Name()
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func restoreFile(r io.Reader, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
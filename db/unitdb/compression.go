@@ -0,0 +1,81 @@
+package adapter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Payload compression codecs, the codec is stored in the message envelope
+// so messages compressed with different codecs can be read back.
+const (
+	codecNone uint8 = iota
+	codecSnappy
+	codecGzip
+)
+
+// codecs maps compression config to the codec.
+var codecs = map[string]uint8{
+	"":       codecNone,
+	"none":   codecNone,
+	"snappy": codecSnappy,
+	"gzip":   codecGzip,
+}
+
+// Gzip writers and readers allocate large compression state, so they are pooled and
+// reset between messages.
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	gzipReaders sync.Pool
+)
+
+// compress compresses the envelope payload using the configured codec.
+func (a *adapter) compress(env envelope) (envelope, error) {
+	switch a.config.codec {
+	case codecSnappy:
+		env.payload = snappy.Encode(nil, env.payload)
+	case codecGzip:
+		var buf bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(env.payload); err != nil {
+			return env, err
+		}
+		if err := w.Close(); err != nil {
+			return env, err
+		}
+		env.payload = buf.Bytes()
+	}
+	env.codec = a.config.codec
+	return env, nil
+}
+
+// decompress decompresses the payload compressed with the codec.
+func decompress(codec uint8, payload []byte) ([]byte, error) {
+	switch codec {
+	case codecNone:
+		return payload, nil
+	case codecSnappy:
+		return snappy.Decode(nil, payload)
+	case codecGzip:
+		r, ok := gzipReaders.Get().(*gzip.Reader)
+		if ok {
+			if err := r.Reset(bytes.NewReader(payload)); err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			if r, err = gzip.NewReader(bytes.NewReader(payload)); err != nil {
+				return nil, err
+			}
+		}
+		defer gzipReaders.Put(r)
+		return ioutil.ReadAll(r)
+	}
+	return nil, errors.New("unitdb adapter unknown compression codec")
+}
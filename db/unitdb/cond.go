@@ -0,0 +1,126 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// Number of locks conditional writes of topics are serialized by
+const nTopicLocks = 256
+
+// topicLocks serializes writes of a topic. unitdb has no transactions, so a conditional
+// write reads the topic and writes the message while holding the lock of the topic, and
+// Put and BatchPut hold the lock while they write. Topics are assigned to locks by hash,
+// so topics may share a lock.
+type topicLocks struct {
+	locks [nTopicLocks]sync.Mutex
+}
+
+// lock locks the lock of the topic and returns the function to unlock it.
+func (l *topicLocks) lock(contract uint32, topic []byte) func() {
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], contract)
+	h := fnv.New32a()
+	h.Write(scratch[:])
+	h.Write(topicName(topic))
+	m := &l.locks[h.Sum32()%nTopicLocks]
+	m.Lock()
+	return m.Unlock
+}
+
+// PutIfAbsent appends the message to the store using the messageId unless a message with
+// the messageId is already stored for the topic, so producers retrying a write can resend
+// the message safely. It returns false if the message was already stored. Conditional writes
+// of the topic are serialized, so of concurrent writes of the same messageId exactly one is
+// written. Writes by Put and BatchPut of the topic are serialized with conditional writes.
+func (a *adapter) PutIfAbsent(contract uint32, topic, messageId, payload []byte) (written bool, err error) {
+	if err := checkContract(contract); err != nil {
+		return false, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return false, err
+	}
+	unlock := a.topicLocks.lock(contract, topic)
+	defer unlock()
+	ok, err := a.exists(contract, topic, messageId)
+	if err != nil || ok {
+		return false, err
+	}
+	if _, err := a.write(context.Background(), contract, topic, payload, dbadapter.NewWriteOptions(dbadapter.WithID(messageId))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// exists checks if a message with the messageId is stored for the topic.
+func (a *adapter) exists(contract uint32, topic, messageId []byte) (ok bool, err error) {
+	if err := a.rlock(); err != nil {
+		return false, err
+	}
+	defer a.mu.RUnlock()
+	if err := a.checkMessageID(messageId); err != nil {
+		return false, err
+	}
+	err = a.scanTopic(context.Background(), contract, topic, math.MaxInt32, false, func(env envelope) bool {
+		ok = bytes.Equal(env.id, messageId)
+		return !ok
+	})
+	return ok, err
+}
+
+// CompareAndSwap appends the message to the store if the messageId of the most recent message
+// of the topic equals expectedId and returns the generated messageId. An empty expectedId
+// means the topic has no messages. It returns false if the precondition fails. The most recent
+// message is chosen by the time the message was stored as in GetLast. Conditional writes of
+// the topic are serialized, so of concurrent writers expecting the same message exactly one
+// succeeds. Writes by Put and BatchPut of the topic are serialized with conditional writes,
+// so the most recent message does not change between the check and the write.
+func (a *adapter) CompareAndSwap(contract uint32, topic, expectedId, payload []byte) (newId []byte, ok bool, err error) {
+	if err := checkContract(contract); err != nil {
+		return nil, false, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, false, err
+	}
+	unlock := a.topicLocks.lock(contract, topic)
+	defer unlock()
+	lastId, last, err := a.last(contract, topic)
+	if err != nil {
+		return nil, false, err
+	}
+	if !bytes.Equal(lastId, expectedId) {
+		return nil, false, nil
+	}
+	var opts []dbadapter.WriteOption
+	if now := time.Now(); lastId != nil && !now.After(last) {
+		// keep the new message the most recent message of the topic
+		opts = append(opts, dbadapter.WithTimestamp(last.Add(time.Nanosecond)))
+	}
+	if newId, err = a.write(context.Background(), contract, topic, payload, dbadapter.NewWriteOptions(opts...)); err != nil {
+		return nil, false, err
+	}
+	return newId, true, nil
+}
+
+// last returns the messageId and the time of the most recent message of the topic, the
+// messageId is nil if the topic has no messages.
+func (a *adapter) last(contract uint32, topic []byte) (messageId []byte, last time.Time, err error) {
+	if err := a.rlock(); err != nil {
+		return nil, last, err
+	}
+	defer a.mu.RUnlock()
+	err = a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+		if messageId == nil || env.timestamp.After(last) {
+			messageId, last = env.id, env.timestamp
+		}
+		return true
+	})
+	return messageId, last, err
+}
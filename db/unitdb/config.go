@@ -0,0 +1,189 @@
+package adapter
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validate checks the config and sets defaults of options that are not set. Errors
+// name the offending option by its JSON key.
+func (c *configType) validate() error {
+	var err error
+	if c.Dir, err = expandEnv(c.Dir); err != nil {
+		return errors.New("unitdb adapter failed to expand config dir: " + err.Error())
+	}
+	if c.ValueDir, err = expandEnv(c.ValueDir); err != nil {
+		return errors.New("unitdb adapter failed to expand config value_dir: " + err.Error())
+	}
+	if c.InMemory {
+		if c.ValueDir != "" {
+			return errors.New("unitdb adapter invalid config, value_dir must not be set with in_memory")
+		}
+		if c.ReadOnly {
+			return errors.New("unitdb adapter invalid config, read_only must not be set with in_memory")
+		}
+	} else if c.Dir == "" {
+		return errors.New("unitdb adapter invalid config, dir must be set to the database dir")
+	}
+	if c.Size < 0 {
+		return errors.New("unitdb adapter invalid config, mem_size must not be negative")
+	}
+	if c.MaxResults < 0 {
+		return errors.New("unitdb adapter invalid config, max_results must not be negative")
+	}
+	if c.MaxResults == 0 {
+		c.MaxResults = maxResults
+	}
+	c.maxTTL = maxTTLDur
+	if c.MaxTTL != "" {
+		if c.maxTTL, err = time.ParseDuration(c.MaxTTL); err != nil {
+			return errors.New("unitdb adapter failed to parse config max_ttl: " + err.Error())
+		}
+		if c.maxTTL < 0 {
+			return errors.New("unitdb adapter invalid config, max_ttl must not be negative")
+		}
+	}
+	if c.DefaultTTL != "" {
+		if c.defaultTTL, err = time.ParseDuration(c.DefaultTTL); err != nil {
+			return errors.New("unitdb adapter failed to parse config default_ttl: " + err.Error())
+		}
+		if c.defaultTTL < 0 || c.defaultTTL > c.maxTTL {
+			return errors.New("unitdb adapter invalid config, default_ttl must be between 0 and max_ttl " + c.maxTTL.String())
+		}
+	}
+
+	if c.MaxTimestampSkew != "" {
+		if c.maxSkew, err = time.ParseDuration(c.MaxTimestampSkew); err != nil {
+			return errors.New("unitdb adapter failed to parse config max_timestamp_skew: " + err.Error())
+		}
+		if c.maxSkew < 0 {
+			return errors.New("unitdb adapter invalid config, max_timestamp_skew must not be negative")
+		}
+	}
+
+	if c.WriteRetries < 0 {
+		return errors.New("unitdb adapter invalid config, write_retries must not be negative")
+	}
+	c.retryBackoff = defaultRetryBackoff
+	if c.WriteRetryBackoff != "" {
+		if c.retryBackoff, err = time.ParseDuration(c.WriteRetryBackoff); err != nil {
+			return errors.New("unitdb adapter failed to parse config write_retry_backoff: " + err.Error())
+		}
+		if c.retryBackoff < 0 {
+			return errors.New("unitdb adapter invalid config, write_retry_backoff must not be negative")
+		}
+	}
+
+	codec, ok := codecs[c.Compression]
+	if !ok {
+		return errors.New("unitdb adapter invalid config, compression must be none, snappy or gzip")
+	}
+	c.codec = codec
+
+	switch c.IDMode {
+	case "":
+		c.IDMode = idModeDefault
+	case idModeDefault, idModeSequence:
+	default:
+		return errors.New("unitdb adapter invalid config, id_mode must be default or sequence")
+	}
+
+	if c.dur, err = time.ParseDuration(c.LogReleaseDur); err != nil {
+		return errors.New("unitdb adapter failed to parse config log_release_duration: " + err.Error())
+	}
+	if c.dur <= 0 {
+		return errors.New("unitdb adapter invalid config, log_release_duration must be positive")
+	}
+	if c.GetMultiWorkers < 0 {
+		return errors.New("unitdb adapter invalid config, get_multi_workers must not be negative")
+	}
+	if c.GetMultiWorkers == 0 {
+		c.GetMultiWorkers = defaultGetMultiWorkers
+	}
+
+	if c.MaxPayloadBytes < 0 {
+		return errors.New("unitdb adapter invalid config, max_payload_bytes must not be negative")
+	}
+	if c.MaxTopicBytes < 0 {
+		return errors.New("unitdb adapter invalid config, max_topic_bytes must not be negative")
+	}
+
+	if c.WatchBuffer < 0 {
+		return errors.New("unitdb adapter invalid config, watch_buffer must not be negative")
+	}
+	if c.WatchBuffer == 0 {
+		c.WatchBuffer = defaultWatchBuffer
+	}
+	switch c.WatchPolicy {
+	case "":
+		c.WatchPolicy = watchPolicyDrop
+	case watchPolicyDrop, watchPolicyBlock:
+	default:
+		return errors.New("unitdb adapter invalid config, watch_policy must be drop or block")
+	}
+
+	if c.Shards < 0 {
+		return errors.New("unitdb adapter invalid config, shards must not be negative")
+	}
+	if c.Shards == 0 {
+		c.Shards = 1
+	}
+
+	switch len(c.EncryptionKey) {
+	case 0, 16, 24, 32:
+	default:
+		return errors.New("unitdb adapter invalid config, encryption_key must be 16, 24 or 32 bytes long")
+	}
+
+	if c.Name == "" {
+		c.Name = defaultDatabase
+	}
+	if strings.ContainsAny(c.Name, `/\`) || strings.Contains(c.Name, "..") {
+		return errors.New("unitdb adapter invalid config, database must not contain path separators or '..'")
+	}
+
+	if c.Dir != "" {
+		if c.Dir, err = resolvePath(c.Dir); err != nil {
+			return errors.New("unitdb adapter failed to resolve config dir: " + err.Error())
+		}
+	}
+	if c.ValueDir != "" {
+		if c.ValueDir, err = resolvePath(c.ValueDir); err != nil {
+			return errors.New("unitdb adapter failed to resolve config value_dir: " + err.Error())
+		}
+	}
+
+	c.dirPerm = os.FileMode(defaultDirPerm)
+	if c.DirPerm != "" {
+		perm, err := strconv.ParseUint(c.DirPerm, 8, 32)
+		if err != nil || perm > 0777 {
+			return errors.New("unitdb adapter invalid config, dir_perm must be an octal permission such as 0750")
+		}
+		c.dirPerm = os.FileMode(perm)
+	}
+	return nil
+}
+
+// expandEnv replaces $var or ${var} in the path with the value of the environment variable,
+// "$$" is replaced with a literal "$". It fails if a variable is not set, rather than
+// expanding it to empty and putting the database in an unexpected place.
+func expandEnv(path string) (string, error) {
+	var missing []string
+	path = os.Expand(path, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", errors.New("environment variable " + strings.Join(missing, ", ") + " is not set")
+	}
+	return path, nil
+}
@@ -0,0 +1,117 @@
+package adapter
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitdb"
+)
+
+// Metadata key prefix of the counters of topics.
+const metaCounter = "counter"
+
+// counter is the value of a topic counter and the messageId of the metadata entry it is
+// persisted under.
+type counter struct {
+	value int64
+	id    []byte
+	time  time.Time
+}
+
+// counters holds counters of topics loaded from metadata on first use.
+type counters struct {
+	mu     sync.Mutex
+	values map[string]*counter
+}
+
+func newCounters() *counters {
+	return &counters{values: make(map[string]*counter)}
+}
+
+// reset drops the loaded counters, it is called when the underlying database is replaced.
+func (c *counters) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = make(map[string]*counter)
+}
+
+// counterKey returns the metadata key of the counter of the topic.
+func counterKey(contract uint32, topic []byte) []byte {
+	return append([]byte(metaCounter+"."+strconv.FormatUint(uint64(contract), 10)+"."), topicName(topic)...)
+}
+
+// Increment adds delta to the counter of the topic and returns the new value. Counters start
+// at zero and are independent of messages of the topic. Increments are serialized, so
+// concurrent increments of the same counter do not lose updates, and the new value is
+// written along with the delete of the previous value in a single batch.
+func (a *adapter) Increment(contract uint32, topic []byte, delta int64) (value int64, err error) {
+	defer a.meter.Puts.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return 0, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return 0, err
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return 0, dbadapter.ErrReadOnly
+	}
+	if err := a.checkTopicSize(topic); err != nil {
+		return 0, err
+	}
+
+	key := counterKey(contract, topic)
+	a.counters.mu.Lock()
+	defer a.counters.mu.Unlock()
+	c, ok := a.counters.values[string(key)]
+	if !ok {
+		c = &counter{}
+		query := newQuery(metaContract, key, math.MaxInt32)
+		if err := a.items(context.Background(), query, func(env envelope) bool {
+			if len(env.payload) == 8 && (c.id == nil || env.timestamp.After(c.time)) {
+				c.value, c.id, c.time = int64(binary.LittleEndian.Uint64(env.payload)), env.id, env.timestamp
+			}
+			return true
+		}); err != nil {
+			return 0, err
+		}
+		a.counters.values[string(key)] = c
+	}
+
+	id, err := a.newID()
+	if err != nil {
+		return 0, err
+	}
+	value = c.value + delta
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], uint64(value))
+	env := newEnvelope(id, scratch[:])
+	if !env.timestamp.After(c.time) {
+		// keep the new value the most recent entry of the counter
+		env.timestamp = c.time.Add(time.Nanosecond)
+	}
+	if err := a.db.Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+		if err := b.PutEntry(newEntry(metaContract, key, env)); err != nil {
+			return err
+		}
+		if c.id != nil {
+			return b.DeleteEntry(deleteEntry(metaContract, key, c.id))
+		}
+		return nil
+	}); err != nil {
+		return 0, a.failed(err)
+	}
+	if err := a.syncWrites(); err != nil {
+		return 0, err
+	}
+	c.value, c.id, c.time = value, id, env.timestamp
+	return value, nil
+}
@@ -0,0 +1,132 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitdb"
+)
+
+// DropContract deletes all messages stored under the contract and returns number of messages
+// deleted. Topics are read from the topic index and messages of each topic are deleted in
+// batches of maxResults messages, progress is logged at debug level once each topic is
+// deleted. The topic index, sequence counters and counters of the contract are deleted
+// once its messages are deleted, so the contract is no longer listed by Contracts. Other
+// contracts are not affected. Messages written to the contract while it is dropped may be
+// kept, so writers of the contract should be stopped first.
+func (a *adapter) DropContract(contract uint32) (deleted int, err error) {
+	defer a.meter.Dels.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return 0, err
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return 0, dbadapter.ErrReadOnly
+	}
+
+	a.index.mu.Lock()
+	topics, err := a.contractTopics(contract)
+	if err != nil {
+		a.index.mu.Unlock()
+		return 0, err
+	}
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	a.index.mu.Unlock()
+	sort.Strings(names)
+
+	for i, name := range names {
+		n, err := a.deleteTopic(contract, []byte(name))
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		a.logger.Debug("adapter.DropContract", "Deleted "+strconv.Itoa(n)+" messages of topic "+name+
+			", topic "+strconv.Itoa(i+1)+" of "+strconv.Itoa(len(names)))
+	}
+	if err := a.dropContractMeta(contract, names); err != nil {
+		return deleted, err
+	}
+	return deleted, a.syncWrites()
+}
+
+// dropContractMeta deletes the sequence counters, counters and the topic index of the
+// contract along with the loaded entries, and removes the contract from the contract index.
+func (a *adapter) dropContractMeta(contract uint32, topics []string) error {
+	a.seqs.mu.Lock()
+	for _, name := range topics {
+		key := seqKey(contract, []byte(name))
+		if err := a.deleteMeta(key, nil); err != nil {
+			a.seqs.mu.Unlock()
+			return err
+		}
+		delete(a.seqs.counters, string(key))
+	}
+	a.seqs.mu.Unlock()
+
+	a.counters.mu.Lock()
+	for _, name := range topics {
+		key := counterKey(contract, []byte(name))
+		if err := a.deleteMeta(key, nil); err != nil {
+			a.counters.mu.Unlock()
+			return err
+		}
+		delete(a.counters.values, string(key))
+	}
+	a.counters.mu.Unlock()
+
+	a.index.mu.Lock()
+	defer a.index.mu.Unlock()
+	if err := a.deleteMeta(topicsKey(contract), nil); err != nil {
+		return err
+	}
+	delete(a.index.topics, contract)
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], contract)
+	if err := a.deleteMeta([]byte(metaContracts), func(env envelope) bool {
+		return bytes.Equal(env.payload, scratch[:])
+	}); err != nil {
+		return err
+	}
+	if a.index.contracts != nil {
+		delete(a.index.contracts, contract)
+	}
+	return nil
+}
+
+// deleteMeta deletes entries stored for the metadata key in a single batch, if match is
+// not nil only entries it matches are deleted.
+func (a *adapter) deleteMeta(key []byte, match func(env envelope) bool) error {
+	var ids [][]byte
+	query := newQuery(metaContract, key, math.MaxInt32)
+	if err := a.items(context.Background(), query, func(env envelope) bool {
+		if match == nil || match(env) {
+			ids = append(ids, append([]byte(nil), env.id...))
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return a.db.Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+		for _, id := range ids {
+			if err := b.DeleteEntry(deleteEntry(metaContract, key, id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// envelopeHeaderSize is the size of the fixed header stored in front of each message payload.
+// The envelope layout is | magic (4 bytes) | timestamp (8 bytes) | qos (1 byte) | codec (1 byte) | seq (8 bytes) | expiry (8 bytes) | id size (1 byte) | id | payload |
+// Changing the layout requires bumping dbVersion.
+const envelopeHeaderSize = 31
+
+// envelopeMagic starts each envelope, values without it are raw payloads of version 1 messages.
+var envelopeMagic = [4]byte{0xe7, 'u', 'e', 'v'}
+
+var errInvalidEnvelope = errors.New("unitdb adapter invalid message envelope")
+
+// envelope wraps the message payload with metadata stored along with the message.
+type envelope struct {
+	timestamp time.Time
+	qos       uint8
+	codec     uint8
+	seq       uint64
+	expiry    int64 // unix nano time the message expires at, zero if it never expires
+	id        []byte
+	payload   []byte
+}
+
+// newEnvelope creates an envelope for the message stored now.
+func newEnvelope(messageId, payload []byte) envelope {
+	return envelope{timestamp: time.Now(), id: messageId, payload: payload}
+}
+
+// encode returns the envelope encoded as header followed by the payload.
+func (e envelope) encode() []byte {
+	data := make([]byte, envelopeHeaderSize+len(e.id)+len(e.payload))
+	copy(data[0:4], envelopeMagic[:])
+	binary.LittleEndian.PutUint64(data[4:12], uint64(e.timestamp.UnixNano()))
+	data[12] = e.qos
+	data[13] = e.codec
+	binary.LittleEndian.PutUint64(data[14:22], e.seq)
+	binary.LittleEndian.PutUint64(data[22:30], uint64(e.expiry))
+	data[30] = uint8(len(e.id))
+	n := copy(data[envelopeHeaderSize:], e.id)
+	copy(data[envelopeHeaderSize+n:], e.payload)
+	return data
+}
+
+// decodeEnvelope decodes the envelope from data read from the store, the payload
+// is decompressed if it was compressed. Data that does not start with the envelope
+// magic is the raw payload of a version 1 message, it is returned as the payload of
+// an envelope with a nil messageId and a zero time.
+func decodeEnvelope(data []byte) (envelope, error) {
+	if !bytes.HasPrefix(data, envelopeMagic[:]) {
+		return envelope{payload: data}, nil
+	}
+	if len(data) < envelopeHeaderSize {
+		return envelope{}, errInvalidEnvelope
+	}
+	idSize := int(data[30])
+	if len(data) < envelopeHeaderSize+idSize {
+		return envelope{}, errInvalidEnvelope
+	}
+	payload, err := decompress(data[13], data[envelopeHeaderSize+idSize:])
+	if err != nil {
+		return envelope{}, err
+	}
+	return envelope{
+		timestamp: time.Unix(0, int64(binary.LittleEndian.Uint64(data[4:12]))),
+		qos:       data[12],
+		seq:       binary.LittleEndian.Uint64(data[14:22]),
+		expiry:    int64(binary.LittleEndian.Uint64(data[22:30])),
+		id:        data[envelopeHeaderSize : envelopeHeaderSize+idSize],
+		payload:   payload,
+	}, nil
+}
+
+// expired checks if the message has expired at the time now.
+func (e envelope) expired(now time.Time) bool {
+	return e.expiry != 0 && e.expiry <= now.UnixNano()
+}
+
+// message returns the message stored in the envelope.
+func (e envelope) message() dbadapter.Message {
+	return dbadapter.Message{
+		ID:        e.id,
+		Timestamp: e.timestamp,
+		Qos:       e.qos,
+		Seq:       e.seq,
+		Payload:   e.payload,
+	}
+}
@@ -0,0 +1,218 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitdb"
+)
+
+// expiring holds messageIds of expired messages being deleted, so PurgeExpired and reads
+// coming across the same expired message delete and report it once.
+type expiring struct {
+	mu  sync.Mutex
+	ids map[string]struct{}
+}
+
+func newExpiring() *expiring {
+	return &expiring{ids: make(map[string]struct{})}
+}
+
+// claim claims the messageIds and returns the messageIds not already claimed.
+func (e *expiring) claim(ids [][]byte) (claimed [][]byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := e.ids[string(id)]; !ok {
+			e.ids[string(id)] = struct{}{}
+			claimed = append(claimed, id)
+		}
+	}
+	return claimed
+}
+
+// release releases the claimed messageIds.
+func (e *expiring) release(ids [][]byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range ids {
+		delete(e.ids, string(id))
+	}
+}
+
+// PurgeExpired deletes messages of the topic whose TTL has elapsed and returns number
+// of messages deleted. A nil topic purges all topics of the contract. unitdb expires
+// messages lazily, so PurgeExpired forces the space of expired messages to be released
+// on the next sync rather than when unitdb comes across them. Expire hooks are called
+// for the purged messages once they are deleted.
+func (a *adapter) PurgeExpired(contract uint32, topic []byte) (purged int, err error) {
+	defer a.meter.Dels.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return 0, err
+	}
+	if topic != nil {
+		if err := checkTopic(topic); err != nil {
+			return 0, err
+		}
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return 0, dbadapter.ErrReadOnly
+	}
+	topics := [][]byte{topic}
+	if topic == nil {
+		if topics, err = a.topics(contract); err != nil {
+			return 0, err
+		}
+	}
+	now := a.now()
+	for _, topic := range topics {
+		var ids [][]byte
+		if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+			if env.expired(now) {
+				ids = append(ids, append([]byte(nil), env.id...))
+			}
+			return true
+		}); err != nil {
+			return purged, err
+		}
+		n, err := a.deleteExpired(contract, topic, ids)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, a.syncWrites()
+}
+
+// deleteExpired deletes the expired messages of the topic with the messageIds in a single
+// batch and calls the expire hooks for the messages once the delete is written, it returns
+// number of messages deleted. The messageIds are claimed and only messages still stored are
+// deleted, so a message deleted by a concurrent purge or read is not reported again. The
+// caller must hold the read lock.
+func (a *adapter) deleteExpired(contract uint32, topic []byte, ids [][]byte) (int, error) {
+	ids = a.expiring.claim(ids)
+	defer a.expiring.release(ids)
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	claimed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		claimed[string(id)] = true
+	}
+	var stored [][]byte
+	if err := a.scanTopic(context.Background(), contract, topic, math.MaxInt32, false, func(env envelope) bool {
+		if claimed[string(env.id)] {
+			stored = append(stored, append([]byte(nil), env.id...))
+		}
+		return len(stored) < len(ids)
+	}); err != nil {
+		return 0, err
+	}
+	if len(stored) == 0 {
+		return 0, nil
+	}
+	if err := a.shardOf(contract, topic).Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+		for _, id := range stored {
+			if err := b.DeleteEntry(deleteEntry(contract, topic, id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	for _, id := range stored {
+		a.expired(contract, topic, id)
+	}
+	return len(stored), nil
+}
+
+// expireRead deletes the expired messages a read dropped from its results and calls the
+// expire hooks for them. If the database is read-only the messages are not deleted or
+// reported, failures are logged as the read succeeded. The caller must hold the read lock.
+func (a *adapter) expireRead(contract uint32, topic []byte, ids [][]byte) {
+	if len(ids) == 0 || a.config.ReadOnly {
+		return
+	}
+	if _, err := a.deleteExpired(contract, topic, ids); err != nil {
+		a.logger.Error("adapter.expireRead", "Unable to delete expired messages: "+err.Error())
+	}
+}
+
+// Touch resets the TTL of the message with the messageId, the message expires after the ttl
+// duration from now. A zero ttl means the message never expires and the ttl is capped at
+// configured max TTL. It returns ErrNotFound if the message was not found. unitdb cannot
+// update an entry in place, so the message is deleted and written again with the same
+// messageId, timestamp and QoS level in a single batch.
+func (a *adapter) Touch(contract uint32, topic, messageId []byte, ttl time.Duration) (err error) {
+	defer a.meter.Puts.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return errors.New("unitdb adapter invalid ttl " + ttl.String() + ", ttl must not be negative")
+	}
+	if err := a.rlock(); err != nil {
+		return err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return dbadapter.ErrReadOnly
+	}
+	if ttl > a.config.maxTTL {
+		ttl = a.config.maxTTL
+	}
+
+	var env envelope
+	var found bool
+	if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(e envelope) bool {
+		if found = bytes.Equal(e.id, messageId); found {
+			env = e
+			env.id = append([]byte(nil), e.id...)
+			env.payload = append([]byte(nil), e.payload...)
+		}
+		return !found
+	}); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: messageId %x", dbadapter.ErrNotFound, messageId)
+	}
+
+	env.expiry = 0
+	if ttl > 0 {
+		env.expiry = a.now().Add(ttl).UnixNano()
+	}
+	if env, err = a.compress(env); err != nil {
+		return err
+	}
+	entry := newEntry(contract, topic, env)
+	if ttl > 0 {
+		entry.WithTTL(ttl.String())
+	}
+	db := a.shardOf(contract, topic)
+	if err := a.retry(context.Background(), func() error {
+		return db.Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+			if err := b.DeleteEntry(deleteEntry(contract, topic, messageId)); err != nil {
+				return err
+			}
+			return b.PutEntry(entry)
+		})
+	}); err != nil {
+		return err
+	}
+	return a.syncWrites()
+}
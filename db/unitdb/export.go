@@ -0,0 +1,233 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitdb"
+)
+
+// importBatchSize is the number of records written in a batch by ImportJSON.
+const importBatchSize = 100
+
+// jsonRecord is a message in the newline-delimited JSON export format. The messageId
+// and payload are base64 encoded.
+type jsonRecord struct {
+	Contract  uint32    `json:"contract"`
+	Topic     string    `json:"topic"`
+	ID        []byte    `json:"id"`
+	Payload   []byte    `json:"payload_base64"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ExportJSON writes all messages stored under the contract to w, one JSON record per line.
+// Topics are read from the topic index and messages are written as they are read, so the
+// export is not buffered in memory.
+func (a *adapter) ExportJSON(contract uint32, w io.Writer) error {
+	if err := checkContract(contract); err != nil {
+		return err
+	}
+	if err := a.rlock(); err != nil {
+		return err
+	}
+	defer a.mu.RUnlock()
+	topics, err := a.topics(contract)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, topic := range topics {
+		var werr error
+		if err := a.topicItems(context.Background(), contract, topic, math.MaxInt32, func(env envelope) bool {
+			werr = enc.Encode(jsonRecord{
+				Contract:  contract,
+				Topic:     string(topic),
+				ID:        env.id,
+				Payload:   env.payload,
+				Timestamp: env.timestamp,
+			})
+			return werr == nil
+		}); err != nil {
+			return err
+		}
+		if werr != nil {
+			return werr
+		}
+	}
+	return nil
+}
+
+// ImportJSON reads messages in the format written by ExportJSON and stores them using
+// their original messageIds, records are written in batches of importBatchSize. Records
+// are validated like messages written by Put, imported messages expire after the configured
+// default TTL if any and are reported to write hooks and watchers. Malformed and invalid
+// lines are skipped and counted if skipMalformed is set, otherwise the import stops at the
+// first malformed line. Records written before an error remain imported.
+func (a *adapter) ImportJSON(r io.Reader, skipMalformed bool) (imported, skipped int, err error) {
+	if err := a.rlock(); err != nil {
+		return 0, 0, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return 0, 0, dbadapter.ErrReadOnly
+	}
+	br := bufio.NewReader(r)
+	batch := make([]jsonRecord, 0, importBatchSize)
+	for line := 1; ; line++ {
+		data, rerr := br.ReadBytes('\n')
+		if rerr != nil && rerr != io.EOF {
+			return imported, skipped, rerr
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			var rec jsonRecord
+			err := decodeRecord(data, &rec)
+			if err == nil {
+				err = a.checkRecord(rec)
+			}
+			if err != nil {
+				if !skipMalformed {
+					return imported, skipped, fmt.Errorf("unitdb adapter malformed record on line %d: %w", line, err)
+				}
+				skipped++
+			} else {
+				batch = append(batch, rec)
+			}
+		}
+		if len(batch) == importBatchSize || (rerr == io.EOF && len(batch) > 0) {
+			if err := a.importBatch(batch); err != nil {
+				return imported, skipped, err
+			}
+			imported += len(batch)
+			batch = batch[:0]
+		}
+		if rerr == io.EOF {
+			return imported, skipped, nil
+		}
+	}
+}
+
+// decodeRecord decodes and validates an export record.
+func decodeRecord(data []byte, rec *jsonRecord) error {
+	if err := json.Unmarshal(data, rec); err != nil {
+		return err
+	}
+	if err := checkContract(rec.Contract); err != nil {
+		return err
+	}
+	if rec.Topic == "" {
+		return errors.New("topic is empty")
+	}
+	if len(rec.ID) == 0 {
+		return errors.New("id is empty")
+	}
+	return nil
+}
+
+// checkRecord validates the topic, the messageId and the payload of an export record,
+// records exceeding the configured max topic bytes or max payload bytes are rejected.
+func (a *adapter) checkRecord(rec jsonRecord) error {
+	if err := a.checkTopicSize([]byte(rec.Topic)); err != nil {
+		return err
+	}
+	if err := a.checkMessageID(rec.ID); err != nil {
+		return err
+	}
+	return a.checkPayload(rec.Payload)
+}
+
+// importBatch writes the records keeping their messageIds and timestamps, records of each
+// shard are written in a single batch. Write hooks and watchers are called once the records
+// are written.
+func (a *adapter) importBatch(recs []jsonRecord) error {
+	shards := make(map[*unitdb.DB][]jsonRecord)
+	var order []*unitdb.DB
+	for _, rec := range recs {
+		topic := []byte(rec.Topic)
+		if err := a.indexTopic(rec.Contract, topic); err != nil {
+			return err
+		}
+		db := a.shardOf(rec.Contract, topic)
+		if _, ok := shards[db]; !ok {
+			order = append(order, db)
+		}
+		shards[db] = append(shards[db], rec)
+	}
+	watched := a.watchers.active()
+	var msgs []dbadapter.Message
+	ttl := a.config.defaultTTL
+	for _, db := range order {
+		// sequence numbers are reserved before the batch opens and persisted once it commits
+		ranges := make(map[string]*seqRange)
+		if a.config.IDMode == idModeSequence {
+			for _, rec := range shards[db] {
+				key := string(seqKey(rec.Contract, []byte(rec.Topic)))
+				if r, ok := ranges[key]; ok {
+					r.n++
+				} else {
+					ranges[key] = &seqRange{contract: rec.Contract, topic: []byte(rec.Topic), n: 1}
+				}
+			}
+			if err := a.reserveSeqRanges(ranges); err != nil {
+				return err
+			}
+		}
+		err := db.Batch(func(b *unitdb.Batch, completed <-chan struct{}) error {
+			for _, rec := range shards[db] {
+				topic := []byte(rec.Topic)
+				var seq uint64
+				if r, ok := ranges[string(seqKey(rec.Contract, topic))]; ok {
+					seq = r.next
+					r.next++
+				}
+				env, err := a.wrap(rec.ID, rec.Payload, seq)
+				if err != nil {
+					return err
+				}
+				if !rec.Timestamp.IsZero() {
+					env.timestamp = rec.Timestamp
+				}
+				if ttl > 0 {
+					env.expiry = a.now().Add(ttl).UnixNano()
+				}
+				entry := newEntry(rec.Contract, topic, env)
+				if ttl > 0 {
+					entry.WithTTL(ttl.String())
+				}
+				if err := b.PutEntry(entry); err != nil {
+					return err
+				}
+				if watched {
+					msgs = append(msgs, watchMessage(env, rec.Payload))
+				}
+			}
+			return nil
+		})
+		a.commitSeqRanges(ranges, err == nil)
+		if err != nil {
+			return a.failed(err)
+		}
+	}
+	if err := a.syncWrites(); err != nil {
+		return err
+	}
+	i := 0
+	for _, db := range order {
+		for _, rec := range shards[db] {
+			topic := []byte(rec.Topic)
+			a.written(rec.Contract, topic, rec.ID, rec.Payload)
+			if watched {
+				a.notifyWatchers(rec.Contract, topic, msgs[i])
+			}
+			i++
+		}
+	}
+	return nil
+}
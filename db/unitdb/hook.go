@@ -0,0 +1,66 @@
+package adapter
+
+import (
+	"fmt"
+	"sync"
+)
+
+// hooks holds callbacks registered by callers to be notified of writes.
+type hooks struct {
+	mu       sync.RWMutex
+	onWrite  []func(contract uint32, topic, messageId, payload []byte)
+	onExpire []func(contract uint32, topic, messageId []byte)
+}
+
+// OnWrite registers a hook called after each successful Put and BatchPut with the messageId
+// and the payload as written. Hooks are called synchronously by the writer in registration
+// order once the write succeeded, so they should be quick and must not call Close. A panic
+// in a hook is recovered and logged, it does not fail the write or skip other hooks.
+func (a *adapter) OnWrite(hook func(contract uint32, topic, messageId, payload []byte)) {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.onWrite = append(a.hooks.onWrite, hook)
+}
+
+// written calls the write hooks for the message.
+func (a *adapter) written(contract uint32, topic, messageId, payload []byte) {
+	a.hooks.mu.RLock()
+	onWrite := a.hooks.onWrite
+	a.hooks.mu.RUnlock()
+	for _, hook := range onWrite {
+		a.callHook("OnWrite", func() { hook(contract, topic, messageId, payload) })
+	}
+}
+
+// OnExpire registers a hook called for each expired message removed by PurgeExpired, or
+// dropped by Get or GetMessages, in registration order and in the order messages are
+// removed, once the delete of the messages is written. Hooks are called at most once per
+// message, messages are not reported if the process stops before their delete is written
+// and unitdb expires messages lazily without notifying the adapter, so messages unitdb
+// dropped before the adapter came across them are not reported. A panic in a hook is
+// recovered and logged.
+func (a *adapter) OnExpire(hook func(contract uint32, topic, messageId []byte)) {
+	a.hooks.mu.Lock()
+	defer a.hooks.mu.Unlock()
+	a.hooks.onExpire = append(a.hooks.onExpire, hook)
+}
+
+// expired calls the expire hooks for the message.
+func (a *adapter) expired(contract uint32, topic, messageId []byte) {
+	a.hooks.mu.RLock()
+	onExpire := a.hooks.onExpire
+	a.hooks.mu.RUnlock()
+	for _, hook := range onExpire {
+		a.callHook("OnExpire", func() { hook(contract, topic, messageId) })
+	}
+}
+
+// callHook calls the hook recovering a panic.
+func (a *adapter) callHook(name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			a.logger.Error("adapter."+name, fmt.Sprintf("Hook panic: %v", r))
+		}
+	}()
+	hook()
+}
@@ -0,0 +1,227 @@
+package adapter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+const (
+	messagesPath = "/v1/messages"
+
+	contentTypeJSON = "application/json"
+	contentTypeRaw  = "application/octet-stream"
+
+	// maxRequestBytes limits the size of the request body accepted by the HTTP handler.
+	maxRequestBytes = 1 << 20
+)
+
+// httpPutRequest is the JSON body of a put request.
+type httpPutRequest struct {
+	Contract uint32 `json:"contract"`
+	Topic    string `json:"topic"`
+	Payload  []byte `json:"payload"`
+}
+
+// httpMessage is a message as returned by the HTTP handler. Message ids are encoded
+// using unpadded URL safe base64 so they can be used in the delete path.
+type httpMessage struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Payload   []byte    `json:"payload"`
+}
+
+type httpHandler struct {
+	adp dbadapter.Adapter
+}
+
+// NewHTTPHandler returns an http.Handler exposing the store over HTTP:
+//
+//	POST   /v1/messages?contract=&topic=           puts a message and returns its id
+//	GET    /v1/messages?contract=&topic=&limit=    gets messages of the topic
+//	DELETE /v1/messages/{id}?contract=&topic=      deletes a message
+//
+// A put request with a JSON Content-Type carries contract, topic and the base64 encoded
+// payload in the body, any other Content-Type stores the body as the raw payload. A get
+// request returns up to limit messages, limit defaults to maxResults. A get request accepting
+// application/octet-stream returns the raw payload of the first message, otherwise the messages
+// are returned as JSON. Deleting a message that is not stored succeeds as the delete of the
+// adapter does. Adapter errors are mapped to status codes by their dbadapter.Classify class.
+func NewHTTPHandler(adp dbadapter.Adapter) http.Handler {
+	return &httpHandler{adp: adp}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == messagesPath:
+		switch r.Method {
+		case http.MethodPost:
+			h.put(w, r)
+		case http.MethodGet:
+			h.get(w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case strings.HasPrefix(r.URL.Path, messagesPath+"/"):
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "DELETE")
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.delete(w, r, strings.TrimPrefix(r.URL.Path, messagesPath+"/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *httpHandler) put(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		httpError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	var req httpPutRequest
+	if isJSON(r.Header.Get("Content-Type")) {
+		if err := json.Unmarshal(body, &req); err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		contract, err := parseContract(r)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req = httpPutRequest{Contract: contract, Topic: r.URL.Query().Get("topic"), Payload: body}
+	}
+	if req.Topic == "" {
+		httpError(w, http.StatusBadRequest, "topic is required")
+		return
+	}
+	id, err := h.adp.PutReturningID(req.Contract, []byte(req.Topic), req.Payload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"id": base64.RawURLEncoding.EncodeToString(id)})
+}
+
+func (h *httpHandler) get(w http.ResponseWriter, r *http.Request) {
+	contract, topic, err := parseTopic(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := maxResults
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil {
+			httpError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	msgs, err := h.adp.GetMessages(contract, topic, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.Header.Get("Accept") == contentTypeRaw {
+		if len(msgs) == 0 {
+			writeError(w, dbadapter.ErrNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeRaw)
+		w.Header().Set("X-Message-Id", base64.RawURLEncoding.EncodeToString(msgs[0].ID))
+		w.WriteHeader(http.StatusOK)
+		w.Write(msgs[0].Payload)
+		return
+	}
+	out := make([]httpMessage, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, httpMessage{ID: base64.RawURLEncoding.EncodeToString(m.ID), Timestamp: m.Timestamp, Payload: m.Payload})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *httpHandler) delete(w http.ResponseWriter, r *http.Request, encodedID string) {
+	id, err := base64.RawURLEncoding.DecodeString(encodedID)
+	if err != nil || len(id) == 0 {
+		httpError(w, http.StatusBadRequest, "invalid message id")
+		return
+	}
+	contract, topic, err := parseTopic(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.adp.Delete(contract, id, topic); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseContract(r *http.Request) (uint32, error) {
+	s := r.URL.Query().Get("contract")
+	if s == "" {
+		return 0, errors.New("contract is required")
+	}
+	contract, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid contract")
+	}
+	return uint32(contract), nil
+}
+
+func parseTopic(r *http.Request) (uint32, []byte, error) {
+	contract, err := parseContract(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		return 0, nil, errors.New("topic is required")
+	}
+	return contract, []byte(topic), nil
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == contentTypeJSON
+}
+
+// writeError maps an adapter error to the HTTP status code.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch dbadapter.Classify(err) {
+	case dbadapter.ClassNotFound:
+		code = http.StatusNotFound
+	case dbadapter.ClassUnavailable:
+		code = http.StatusServiceUnavailable
+	case dbadapter.ClassInvalidArgument:
+		code = http.StatusBadRequest
+	case dbadapter.ClassTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	case dbadapter.ClassReadOnly:
+		code = http.StatusForbidden
+	}
+	httpError(w, code, err.Error())
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,272 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitdb"
+)
+
+const (
+	// Metadata key prefix of the topic index of a contract.
+	metaTopics = "topics"
+	// Metadata key of the contract index.
+	metaContracts = "contracts"
+)
+
+// topicIndex tracks contracts and topics written under each contract. unitdb has no way
+// to enumerate its keyspace, so contracts and topics are recorded in metadata on first
+// write and loaded from metadata on first use.
+type topicIndex struct {
+	mu        sync.Mutex
+	topics    map[uint32]map[string]struct{}
+	contracts map[uint32]struct{} // nil until loaded
+}
+
+func newTopicIndex() *topicIndex {
+	return &topicIndex{topics: make(map[uint32]map[string]struct{})}
+}
+
+// reset drops the loaded topics, it is called when the underlying database is replaced.
+func (idx *topicIndex) reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.topics = make(map[uint32]map[string]struct{})
+	idx.contracts = nil
+}
+
+// topicsKey returns the metadata key of the topic index of the contract.
+func topicsKey(contract uint32) []byte {
+	return []byte(metaTopics + "." + strconv.FormatUint(uint64(contract), 10))
+}
+
+// topicName returns the topic without topic options.
+func topicName(topic []byte) []byte {
+	if i := bytes.IndexByte(topic, '?'); i >= 0 {
+		return topic[:i]
+	}
+	return topic
+}
+
+// contractTopics returns indexed topics of the contract loading them from metadata
+// if they are not loaded yet. The caller must hold the index lock.
+func (a *adapter) contractTopics(contract uint32) (map[string]struct{}, error) {
+	if topics, ok := a.index.topics[contract]; ok {
+		return topics, nil
+	}
+	topics := make(map[string]struct{})
+	query := unitdb.NewQuery(topicsKey(contract))
+	query.WithContract(metaContract)
+	query.WithLimit(math.MaxInt32)
+	if err := a.items(context.Background(), query, func(env envelope) bool {
+		topics[string(env.payload)] = struct{}{}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	a.index.topics[contract] = topics
+	return topics, nil
+}
+
+// indexedContracts returns indexed contracts loading them from metadata if they are
+// not loaded yet. The caller must hold the index lock.
+func (a *adapter) indexedContracts() (map[uint32]struct{}, error) {
+	if a.index.contracts != nil {
+		return a.index.contracts, nil
+	}
+	contracts := make(map[uint32]struct{})
+	query := unitdb.NewQuery([]byte(metaContracts))
+	query.WithContract(metaContract)
+	query.WithLimit(math.MaxInt32)
+	if err := a.items(context.Background(), query, func(env envelope) bool {
+		if len(env.payload) == 4 {
+			contracts[binary.LittleEndian.Uint32(env.payload)] = struct{}{}
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	a.index.contracts = contracts
+	return contracts, nil
+}
+
+// indexContract records the contract in the contract index if it is not indexed yet.
+// The caller must hold the index lock.
+func (a *adapter) indexContract(contract uint32) error {
+	contracts, err := a.indexedContracts()
+	if err != nil {
+		return err
+	}
+	if _, ok := contracts[contract]; ok {
+		return nil
+	}
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], contract)
+	if err := a.putMeta(metaContracts, scratch[:]); err != nil {
+		return err
+	}
+	contracts[contract] = struct{}{}
+	return nil
+}
+
+// indexTopic records the topic in the topic index of the contract if it is not indexed yet.
+func (a *adapter) indexTopic(contract uint32, topic []byte) error {
+	topic = topicName(topic)
+	a.index.mu.Lock()
+	defer a.index.mu.Unlock()
+	topics, err := a.contractTopics(contract)
+	if err != nil {
+		return err
+	}
+	if _, ok := topics[string(topic)]; ok {
+		return nil
+	}
+	if err := a.indexContract(contract); err != nil {
+		return err
+	}
+	if err := a.putMeta(string(topicsKey(contract)), topic); err != nil {
+		return err
+	}
+	topics[string(topic)] = struct{}{}
+	return nil
+}
+
+// Topics returns distinct topics that have at least one message stored under the contract,
+// sorted by topic. Topics are read from the topic index and each topic is checked with a
+// single message query, so the cost grows with number of topics ever written under the
+// contract. It returns an empty slice if the contract has no messages.
+func (a *adapter) Topics(contract uint32) ([][]byte, error) {
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	return a.topics(contract)
+}
+
+// ScanPrefix performs a query of each topic of the contract starting with the prefix and
+// attempts to fetch n messages, where n is specified by limit argument. The limit is capped
+// at configured max results. Messages are returned ordered by topic and within a topic in
+// the order unitdb iterates them. An empty prefix matches all topics of the contract. Topics
+// are read from the topic index, so the cost grows with number of topics of the contract.
+func (a *adapter) ScanPrefix(contract uint32, prefix []byte, limit int) (matches []dbadapter.Message, err error) {
+	defer a.meter.Gets.done(time.Now(), &err)
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	if limit, err = a.checkLimit(limit); err != nil {
+		return nil, err
+	}
+	a.index.mu.Lock()
+	topics, err := a.contractTopics(contract)
+	if err != nil {
+		a.index.mu.Unlock()
+		return nil, err
+	}
+	var names []string
+	for name := range topics {
+		if strings.HasPrefix(name, string(prefix)) {
+			names = append(names, name)
+		}
+	}
+	a.index.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := a.topicItems(context.Background(), contract, []byte(name), limit-len(matches), func(env envelope) bool {
+			matches = append(matches, env.message())
+			return len(matches) < limit
+		}); err != nil {
+			return nil, err
+		}
+		if len(matches) == limit {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// topics returns topics of the contract that have at least one message stored, the
+// caller must hold the read lock.
+func (a *adapter) topics(contract uint32) ([][]byte, error) {
+	a.index.mu.Lock()
+	topics, err := a.contractTopics(contract)
+	if err != nil {
+		a.index.mu.Unlock()
+		return nil, err
+	}
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	a.index.mu.Unlock()
+	sort.Strings(names)
+
+	matches := make([][]byte, 0, len(names))
+	for _, name := range names {
+		var found bool
+		if err := a.topicItems(context.Background(), contract, []byte(name), 1, func(env envelope) bool {
+			found = true
+			return false
+		}); err != nil {
+			return nil, err
+		}
+		if found {
+			matches = append(matches, []byte(name))
+		}
+	}
+	return matches, nil
+}
+
+// Contracts returns distinct contracts that have at least one message stored, sorted
+// in ascending order. Contracts are read from the contract index and topics of each
+// contract are checked as in Topics, so it is a full scan of the topic index and the cost
+// grows with number of topics ever written. If limit is greater than zero then at
+// most limit contracts are returned.
+func (a *adapter) Contracts(limit int) ([]uint32, error) {
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	a.index.mu.Lock()
+	contracts, err := a.indexedContracts()
+	if err != nil {
+		a.index.mu.Unlock()
+		return nil, err
+	}
+	ids := make([]uint32, 0, len(contracts))
+	for contract := range contracts {
+		ids = append(ids, contract)
+	}
+	a.index.mu.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	matches := make([]uint32, 0, len(ids))
+	for _, contract := range ids {
+		if limit > 0 && len(matches) == limit {
+			break
+		}
+		topics, err := a.topics(contract)
+		if err != nil {
+			return nil, err
+		}
+		if len(topics) > 0 {
+			matches = append(matches, contract)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,23 @@
+package adapter
+
+import (
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// SetLogger sets the logger used by the adapter to route adapter logs to the application
+// logger. A nil logger restores the default logger. It should be called before Open.
+func (a *adapter) SetLogger(l dbadapter.Logger) {
+	if l == nil {
+		l = dbadapter.DefaultLogger{}
+	}
+	a.logger = l
+}
+
+// SetTracer sets the tracer used by the adapter to record spans of Put, Get and Delete.
+// A nil tracer restores the no-op tracer. It should be called before Open.
+func (a *adapter) SetTracer(t dbadapter.Tracer) {
+	if t == nil {
+		t = dbadapter.NopTracer{}
+	}
+	a.tracer = t
+}
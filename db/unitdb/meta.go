@@ -0,0 +1,90 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	dbadapter "github.com/unit-io/unitd/db"
+	"github.com/unit-io/unitdb"
+)
+
+const (
+	// metaContract is the reserved contract to store adapter metadata.
+	metaContract uint32 = 1863591441 // hash("unitdbmetadata")
+
+	// Metadata keys
+	metaVersion = "version"
+)
+
+var errReservedContract = fmt.Errorf("%w: unitdb adapter contract is reserved for adapter metadata", dbadapter.ErrInvalidArgument)
+
+// getMeta returns the value stored for the metadata key or nil if the key is not found.
+func (a *adapter) getMeta(key string) ([]byte, error) {
+	query := unitdb.NewQuery([]byte(key))
+	query.WithContract(metaContract)
+	query.WithLimit(1)
+	resp, err := a.db.Get(query)
+	if err != nil || len(resp) == 0 {
+		return nil, err
+	}
+	env, err := decodeEnvelope(resp[0])
+	if err != nil {
+		return nil, err
+	}
+	return env.payload, nil
+}
+
+// putMeta stores the value for the metadata key.
+func (a *adapter) putMeta(key string, value []byte) error {
+	messageId := a.db.NewID()
+	return a.db.PutEntry(newEntry(metaContract, []byte(key), newEnvelope(messageId, value)))
+}
+
+// checkVersion stamps the database version on first open and on subsequent opens
+// it checks the stamped version matches the adapter database version. A database
+// written before versions were stamped is a version 1 database, it is migrated
+// unless it is opened read-only, see Migrate. A database stamped with any other
+// version uses an envelope layout the adapter cannot read and it is refused.
+func (a *adapter) checkVersion() error {
+	value, err := a.getMeta(metaVersion)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		// a read-only database is not stamped and its version is unknown.
+		if a.config.ReadOnly {
+			a.version = 0
+			return nil
+		}
+		if a.db.Count() > 0 {
+			a.version = 1
+			return a.migrate()
+		}
+		if err := a.stampVersion(); err != nil {
+			return err
+		}
+		return nil
+	}
+	if len(value) != 4 {
+		return fmt.Errorf("%w: invalid version stamp", dbadapter.ErrVersionMismatch)
+	}
+	a.version = int(binary.LittleEndian.Uint32(value))
+	if a.version != 1 && a.version != int(dbVersion) {
+		return fmt.Errorf("%w: database version %d, adapter version %d", dbadapter.ErrVersionMismatch, a.version, int(dbVersion))
+	}
+	if a.version == 1 && !a.config.ReadOnly {
+		return a.migrate()
+	}
+	return nil
+}
+
+// stampVersion stamps the adapter database version.
+func (a *adapter) stampVersion() error {
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], uint32(dbVersion))
+	if err := a.putMeta(metaVersion, scratch[:]); err != nil {
+		return err
+	}
+	a.version = int(dbVersion)
+	return nil
+}
@@ -0,0 +1,69 @@
+package adapter
+
+import (
+	"time"
+
+	"github.com/unit-io/unitd/pkg/metrics"
+)
+
+// opMeter tracks count, errors and latency of an adapter operation.
+type opMeter struct {
+	Total      metrics.Counter
+	Errors     metrics.Counter
+	TimeSeries metrics.TimeSeries
+}
+
+func newOpMeter() *opMeter {
+	return &opMeter{
+		Total:      metrics.NewCounter(),
+		Errors:     metrics.NewCounter(),
+		TimeSeries: metrics.NewTimeSeries(),
+	}
+}
+
+// done records an operation started at start, it is deferred by the operation
+// with a pointer to its error result.
+func (m *opMeter) done(start time.Time, err *error) {
+	m.TimeSeries.AddTime(time.Since(start))
+	m.Total.Inc(1)
+	if *err != nil {
+		m.Errors.Inc(1)
+	}
+}
+
+type meter struct {
+	Puts *opMeter
+	Gets *opMeter
+	Dels *opMeter
+}
+
+func newMeter() *meter {
+	return &meter{
+		Puts: newOpMeter(),
+		Gets: newOpMeter(),
+		Dels: newOpMeter(),
+	}
+}
+
+// RegisterMetrics registers the adapter metrics for Put, Get and Delete operations
+// with the metrics registry. It returns metrics.DuplicateMetric error if a metric with
+// the same name is already registered.
+func (a *adapter) RegisterMetrics(r metrics.Metrics) error {
+	ops := map[string]*opMeter{
+		"unitdb_put":    a.meter.Puts,
+		"unitdb_get":    a.meter.Gets,
+		"unitdb_delete": a.meter.Dels,
+	}
+	for name, m := range ops {
+		for suffix, metric := range map[string]interface{}{
+			"_total":         m.Total,
+			"_errors":        m.Errors,
+			"_timeseries_ns": m.TimeSeries,
+		} {
+			if r.GetOrRegister(name+suffix, metric) != metric {
+				return metrics.DuplicateMetric(name + suffix)
+			}
+		}
+	}
+	return nil
+}
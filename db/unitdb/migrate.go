@@ -0,0 +1,41 @@
+package adapter
+
+import (
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// Migrate upgrades a version 1 database to the adapter database version and stamps the new
+// version on completion, it is a no-op if the database is already up to date. Open migrates
+// a version 1 database unless it is opened read-only, a read-only database is read in place
+// and Migrate returns ErrReadOnly.
+//
+// Version 1 databases store raw payloads and unitdb cannot enumerate their keyspace, so
+// messages are not rewritten. Envelopes start with a magic that raw payloads are told apart
+// by and version 1 messages are read in place, they keep the key they were written with, so
+// messageIds held by clients still delete them. Version 1 messages carry no messageId, time
+// or QoS, they are returned with a nil messageId and a zero time, so time range queries do
+// not match them and they never expire. They are deleted by the messageId they were written
+// with only, topic deletes and paging skip them.
+func (a *adapter) Migrate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db == nil {
+		return dbadapter.ErrClosed
+	}
+	return a.migrate()
+}
+
+// migrate stamps the adapter database version on a version 1 database, the caller must
+// hold the write lock.
+func (a *adapter) migrate() error {
+	if a.version == int(dbVersion) {
+		return nil
+	}
+	if a.config.ReadOnly {
+		return dbadapter.ErrReadOnly
+	}
+	if a.version > 1 {
+		return dbadapter.ErrVersionMismatch
+	}
+	return a.stampVersion()
+}
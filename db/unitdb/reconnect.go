@@ -0,0 +1,74 @@
+package adapter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/unit-io/unitdb"
+)
+
+// Maximum attempts to reopen the database after a failure
+const maxReconnects = 3
+
+// failed starts reopening the database in background if auto reconnect is configured and
+// the operation failed with err, it returns err. The failed operation holds the read lock,
+// so the database is reopened once in-flight operations complete and the error is returned
+// to the caller without waiting for the reopen.
+func (a *adapter) failed(err error) error {
+	if err == nil || !a.config.AutoReconnect {
+		return err
+	}
+	if !atomic.CompareAndSwapInt32(&a.reconnecting, 0, 1) {
+		return err
+	}
+	go func(db *unitdb.DB) {
+		defer atomic.StoreInt32(&a.reconnecting, 0)
+		a.reconnect(db)
+	}(a.db)
+	return err
+}
+
+// reconnect closes the failed database along with the shards and reopens them using the saved config, retrying
+// with exponential backoff up to maxReconnects attempts. The adapter is closed if the
+// database cannot be reopened. The database is not reopened if it was replaced or closed
+// since it failed.
+func (a *adapter) reconnect(failed *unitdb.DB) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if failed == nil || a.db != failed {
+		return
+	}
+	if err := failed.Close(); err != nil {
+		a.logger.Error("adapter.reconnect", "Unable to close failed db: "+err.Error())
+	}
+	if err := closeShards(a.shards); err != nil {
+		a.logger.Error("adapter.reconnect", "Unable to close shards: "+err.Error())
+	}
+	a.shards = nil
+	a.index.reset()
+	a.seqs.reset()
+	a.counters.reset()
+
+	backoff := a.config.retryBackoff
+	for i := 0; i < maxReconnects; i++ {
+		db, err := openDB(a.config)
+		if err == nil {
+			var shards []*unitdb.DB
+			if shards, err = openShards(a.config); err != nil {
+				db.Close()
+			}
+			a.shards = shards
+		}
+		if err == nil {
+			a.db = db
+			a.lastReconnect = time.Now()
+			a.logger.Info("adapter.reconnect", "Reopened db after failure")
+			return
+		}
+		a.logger.Error("adapter.reconnect", "Unable to reopen db: "+err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	a.db = nil
+	a.version = -1
+}
@@ -0,0 +1,202 @@
+package adapter
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"sync"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// Metadata key prefix of the sequence counters of topics.
+const metaSeq = "seq"
+
+// seqCounter is the last sequence number of a topic and the messageId of the
+// metadata entry it is persisted under.
+type seqCounter struct {
+	value uint64
+	id    []byte
+}
+
+// sequences holds sequence counters of topics loaded from metadata on first use.
+type sequences struct {
+	mu       sync.Mutex
+	counters map[string]*seqCounter
+}
+
+func newSequences() *sequences {
+	return &sequences{counters: make(map[string]*seqCounter)}
+}
+
+// reset drops the loaded counters, it is called when the underlying database is replaced.
+func (s *sequences) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters = make(map[string]*seqCounter)
+}
+
+// seqKey returns the metadata key of the sequence counter of the topic.
+func seqKey(contract uint32, topic []byte) []byte {
+	return append([]byte(metaSeq+"."+strconv.FormatUint(uint64(contract), 10)+"."), topicName(topic)...)
+}
+
+// NextSeq returns the next sequence number of the topic. Sequence numbers start at one and
+// each call persists the counter, so numbers keep increasing across restarts.
+//
+// Calls are serialized, so concurrent writers requesting sequence numbers of the same topic
+// get distinct, increasing numbers. The order messages are written in is not serialized
+// with the sequence, so a message with a higher sequence number may be written before a
+// message with a lower one, and a failed write leaves a gap in the sequence if numbers were
+// reserved after its number.
+func (a *adapter) NextSeq(contract uint32, topic []byte) (uint64, error) {
+	if err := checkContract(contract); err != nil {
+		return 0, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return 0, err
+	}
+	if err := a.rlock(); err != nil {
+		return 0, err
+	}
+	defer a.mu.RUnlock()
+	if a.config.ReadOnly {
+		return 0, dbadapter.ErrReadOnly
+	}
+	return a.nextSeq(contract, topic)
+}
+
+// nextSeq increments and persists the sequence counter of the topic.
+func (a *adapter) nextSeq(contract uint32, topic []byte) (uint64, error) {
+	seq, err := a.reserveSeq(contract, topic, 1)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.persistSeq(contract, topic); err != nil {
+		a.releaseSeq(contract, topic, seq, 1)
+		return 0, err
+	}
+	return seq, nil
+}
+
+// reserveSeq reserves n sequence numbers of the topic and returns the first one. Reserved
+// numbers are not persisted, writers reserve numbers before a write and persist the counter
+// with persistSeq once the write succeeds or release them with releaseSeq if it fails.
+func (a *adapter) reserveSeq(contract uint32, topic []byte, n int) (uint64, error) {
+	a.seqs.mu.Lock()
+	defer a.seqs.mu.Unlock()
+	c, err := a.loadSeq(seqKey(contract, topic))
+	if err != nil {
+		return 0, err
+	}
+	first := c.value + 1
+	c.value += uint64(n)
+	return first, nil
+}
+
+// releaseSeq releases n sequence numbers reserved from first. Numbers are reused only if no
+// numbers were reserved after them, otherwise they are left as a gap in the sequence.
+func (a *adapter) releaseSeq(contract uint32, topic []byte, first uint64, n int) {
+	a.seqs.mu.Lock()
+	defer a.seqs.mu.Unlock()
+	if c, ok := a.seqs.counters[string(seqKey(contract, topic))]; ok && c.value == first+uint64(n)-1 {
+		c.value = first - 1
+	}
+}
+
+// persistSeq persists the sequence counter of the topic. The new counter is written before
+// the previous one is deleted, so if both are found on load the higher value is used.
+func (a *adapter) persistSeq(contract uint32, topic []byte) error {
+	key := seqKey(contract, topic)
+	a.seqs.mu.Lock()
+	defer a.seqs.mu.Unlock()
+	c, err := a.loadSeq(key)
+	if err != nil {
+		return err
+	}
+	id, err := a.newID()
+	if err != nil {
+		return err
+	}
+	var scratch [8]byte
+	binary.LittleEndian.PutUint64(scratch[:], c.value)
+	if err := a.db.PutEntry(newEntry(metaContract, key, newEnvelope(id, scratch[:]))); err != nil {
+		return err
+	}
+	if c.id != nil {
+		if err := a.db.DeleteEntry(deleteEntry(metaContract, key, c.id)); err != nil {
+			a.logger.Error("adapter.NextSeq", "Unable to delete previous sequence counter: "+err.Error())
+		}
+	}
+	c.id = id
+	return nil
+}
+
+// commitSeq persists the sequence counter of the topic once a write of messages numbered
+// from first succeeded, or releases the n numbers reserved from first if it failed. The
+// messages are written by then, so a failure to persist the counter is logged.
+func (a *adapter) commitSeq(contract uint32, topic []byte, first uint64, n int, written bool) {
+	if !written {
+		a.releaseSeq(contract, topic, first, n)
+		return
+	}
+	if err := a.persistSeq(contract, topic); err != nil {
+		a.logger.Error("adapter.commitSeq", "Unable to persist sequence counter: "+err.Error())
+	}
+}
+
+// seqRange is a range of sequence numbers reserved for the messages of a topic written by
+// a batch spanning topics.
+type seqRange struct {
+	contract uint32
+	topic    []byte
+	first    uint64
+	next     uint64
+	n        int
+}
+
+// reserveSeqRanges reserves the sequence numbers of the ranges, if a reservation fails the
+// ranges reserved so far are released.
+func (a *adapter) reserveSeqRanges(ranges map[string]*seqRange) error {
+	for _, r := range ranges {
+		first, err := a.reserveSeq(r.contract, r.topic, r.n)
+		if err != nil {
+			a.commitSeqRanges(ranges, false)
+			return err
+		}
+		r.first, r.next = first, first
+	}
+	return nil
+}
+
+// commitSeqRanges commits the reserved ranges once the batch succeeded or failed.
+func (a *adapter) commitSeqRanges(ranges map[string]*seqRange, written bool) {
+	for _, r := range ranges {
+		if r.first > 0 {
+			a.commitSeq(r.contract, r.topic, r.first, r.n, written)
+		}
+	}
+}
+
+// loadSeq returns the sequence counter for the metadata key loading it on first use, the
+// caller must hold the sequences lock.
+func (a *adapter) loadSeq(key []byte) (*seqCounter, error) {
+	if c, ok := a.seqs.counters[string(key)]; ok {
+		return c, nil
+	}
+	c := &seqCounter{}
+	query := newQuery(metaContract, key, math.MaxInt32)
+	if err := a.items(context.Background(), query, func(env envelope) bool {
+		if len(env.payload) == 8 {
+			if v := binary.LittleEndian.Uint64(env.payload); v >= c.value {
+				c.value, c.id = v, append([]byte(nil), env.id...)
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	a.seqs.counters[string(key)] = c
+	return c, nil
+}
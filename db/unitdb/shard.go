@@ -0,0 +1,151 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/unit-io/unitdb"
+)
+
+// Metadata key of the number of shards
+const metaShards = "shards"
+
+var errShardedUnsupported = errors.New("unitdb adapter operation is not supported on a database with more than one shard")
+
+// shardDir returns the dir of the shard. The first shard is stored in the dir itself and
+// other shards are stored in sub dirs, so shard dirs can be mounted on separate disks.
+func shardDir(dir string, shard int) string {
+	if shard == 0 {
+		return dir
+	}
+	return filepath.Join(dir, "shard"+strconv.Itoa(shard))
+}
+
+// openShards opens the databases of shards other than the first shard.
+func openShards(config *configType) ([]*unitdb.DB, error) {
+	var shards []*unitdb.DB
+	for i := 1; i < config.Shards; i++ {
+		c := *config
+		c.Dir = shardDir(config.Dir, i)
+		c.ValueDir = shardDir(config.ValueDir, i)
+		db, err := openShard(&c)
+		if err != nil {
+			closeShards(shards)
+			return nil, errors.New("unitdb adapter failed to open shard " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		shards = append(shards, db)
+	}
+	return shards, nil
+}
+
+func openShard(config *configType) (*unitdb.DB, error) {
+	for _, dir := range []string{config.Dir, config.ValueDir} {
+		if err := os.MkdirAll(dir, config.dirPerm); err != nil {
+			return nil, err
+		}
+	}
+	return openDB(config)
+}
+
+// closeShards closes the shard databases and returns the first error.
+func closeShards(shards []*unitdb.DB) error {
+	var err error
+	for _, db := range shards {
+		if err1 := db.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// checkShards stamps the number of shards on first open and on subsequent opens it checks
+// the stamped number of shards matches the config, as messages are only found on the shard
+// they were written to. A database written before shards were stamped has a single shard.
+func (a *adapter) checkShards() error {
+	value, err := a.getMeta(metaShards)
+	if err != nil {
+		return err
+	}
+	shards := 1
+	if value != nil {
+		if len(value) != 4 {
+			return errors.New("unitdb adapter invalid shards stamp")
+		}
+		shards = int(binary.LittleEndian.Uint32(value))
+	} else if a.db.Count() == 0 && !a.config.ReadOnly {
+		var scratch [4]byte
+		binary.LittleEndian.PutUint32(scratch[:], uint32(a.config.Shards))
+		if err := a.putMeta(metaShards, scratch[:]); err != nil {
+			return err
+		}
+		shards = a.config.Shards
+	}
+	if shards != a.config.Shards {
+		return fmt.Errorf("unitdb adapter database has %d shards, it cannot be opened with %d shards", shards, a.config.Shards)
+	}
+	return nil
+}
+
+// allShards returns the databases of all shards.
+func (a *adapter) allShards() []*unitdb.DB {
+	return append([]*unitdb.DB{a.db}, a.shards...)
+}
+
+// shardOf returns the database of the shard holding messages of the topic. Topics are
+// assigned to shards by hash of the contract and the topic name.
+func (a *adapter) shardOf(contract uint32, topic []byte) *unitdb.DB {
+	if len(a.shards) == 0 {
+		return a.db
+	}
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], contract)
+	h := fnv.New32a()
+	h.Write(scratch[:])
+	h.Write(topicName(topic))
+	if i := h.Sum32() % uint32(len(a.shards)+1); i > 0 {
+		return a.shards[i-1]
+	}
+	return a.db
+}
+
+// shardsOf returns the databases of shards that may hold messages of the topic. A wildcard
+// topic matches topics of all shards.
+func (a *adapter) shardsOf(contract uint32, topic []byte) []*unitdb.DB {
+	name := topicName(topic)
+	if len(a.shards) > 0 && (bytes.Contains(name, []byte("*")) || bytes.Contains(name, []byte("..."))) {
+		return a.allShards()
+	}
+	return []*unitdb.DB{a.shardOf(contract, topic)}
+}
+
+// topicItems iterates over messages of the topic stored under the contract on the shards
+// holding them and calls fn for each message envelope. The iteration stops if fn returns
+// false or the context is done.
+func (a *adapter) topicItems(ctx context.Context, contract uint32, topic []byte, limit int, fn func(env envelope) bool) error {
+	return a.scanTopic(ctx, contract, topic, limit, true, fn)
+}
+
+// scanTopic iterates over messages of the topic like topicItems, see scanItems for retain.
+func (a *adapter) scanTopic(ctx context.Context, contract uint32, topic []byte, limit int, retain bool, fn func(env envelope) bool) error {
+	query := newQuery(contract, topic, limit)
+	for _, db := range a.shardsOf(contract, topic) {
+		stopped := false
+		if err := a.scanItems(ctx, db, query, retain, func(env envelope) bool {
+			stopped = !fn(env)
+			return !stopped
+		}); err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
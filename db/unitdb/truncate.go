@@ -0,0 +1,63 @@
+package adapter
+
+import (
+	"os"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+// Truncate removes all messages and metadata of the database keeping the adapter open.
+// unitdb cannot enumerate its keyspace, so the database files of all shards are removed
+// and the database is created again. Truncate holds the adapter lock while the files are
+// replaced, so concurrent operations see either the old or the empty database. If the
+// database cannot be created again the adapter is closed. The message log is not truncated.
+func (a *adapter) Truncate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.db == nil {
+		return dbadapter.ErrClosed
+	}
+	if a.config.ReadOnly {
+		return dbadapter.ErrReadOnly
+	}
+
+	err := a.db.Close()
+	if err1 := closeShards(a.shards); err == nil {
+		err = err1
+	}
+	a.db, a.shards, a.version = nil, nil, -1
+	a.index.reset()
+	a.seqs.reset()
+	a.counters.reset()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < a.config.Shards; i++ {
+		for _, dir := range []string{shardDir(a.config.Dir, i), shardDir(a.config.ValueDir, i)} {
+			files, err := a.config.dbFiles(dir)
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+	}
+
+	if a.db, err = openDB(a.config); err != nil {
+		a.db = nil
+		return err
+	}
+	if err := a.stampVersion(); err != nil {
+		return a.abortOpen(err)
+	}
+	if err := a.checkShards(); err != nil {
+		return a.abortOpen(err)
+	}
+	if a.shards, err = openShards(a.config); err != nil {
+		return a.abortOpen(err)
+	}
+	return nil
+}
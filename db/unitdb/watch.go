@@ -0,0 +1,183 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	dbadapter "github.com/unit-io/unitd/db"
+)
+
+const (
+	// Default number of messages buffered for a watcher
+	defaultWatchBuffer = 64
+
+	// Watch policies
+	watchPolicyDrop  = "drop"
+	watchPolicyBlock = "block"
+)
+
+// watcher is a subscription to messages written to a topic.
+type watcher struct {
+	contract uint32
+	topic    string
+	ch       chan dbadapter.Message
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// mu serializes sends with closing the channel, closed is set once it is closed.
+	mu     sync.Mutex
+	closed bool
+}
+
+// send delivers the messages to the watcher. With the block policy a send waits until
+// the watcher receives the message or it is cancelled, otherwise the message is dropped
+// if the watcher buffer is full.
+func (w *watcher) send(block bool, msgs ...dbadapter.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	for _, m := range msgs {
+		if block {
+			select {
+			case w.ch <- m:
+			case <-w.ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case w.ch <- m:
+		default:
+		}
+	}
+}
+
+// close closes the watcher channel once a pending send returns.
+func (w *watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+}
+
+// watchers holds the subscriptions notified by the write path. unitdb has no change
+// notifications, so messages are delivered by the adapter once they are written.
+type watchers struct {
+	mu sync.RWMutex
+	m  map[*watcher]struct{}
+}
+
+func newWatchers() *watchers {
+	return &watchers{m: make(map[*watcher]struct{})}
+}
+
+// active returns true if there are watchers.
+func (ws *watchers) active() bool {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return len(ws.m) > 0
+}
+
+func (ws *watchers) add(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.m[w] = struct{}{}
+}
+
+// remove removes the watcher and closes its channel.
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	delete(ws.m, w)
+	ws.mu.Unlock()
+	w.close()
+}
+
+// cancel cancels all watchers, their channels are closed in background. A send to
+// a blocked watcher is aborted, so cancel does not wait for slow consumers.
+func (ws *watchers) cancel() {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	for w := range ws.m {
+		w.cancel()
+	}
+}
+
+// notify delivers the messages written to the topic to its watchers. The watchers of
+// the topic are copied and the lock is released before the messages are sent, so a slow
+// watcher does not hold up adding and removing watchers.
+func (ws *watchers) notify(contract uint32, topic []byte, block bool, msgs ...dbadapter.Message) {
+	ws.mu.RLock()
+	if len(ws.m) == 0 {
+		ws.mu.RUnlock()
+		return
+	}
+	name := topicName(topic)
+	var matched []*watcher
+	for w := range ws.m {
+		if w.contract == contract && w.topic == string(name) {
+			matched = append(matched, w)
+		}
+	}
+	ws.mu.RUnlock()
+	for _, w := range matched {
+		w.send(block, msgs...)
+	}
+}
+
+// Watch returns a channel delivering messages written to the topic by Put and BatchPut
+// after Watch returns. The channel is closed when the context is done or the adapter is
+// closed. Messages are buffered up to the configured watch buffer, once the buffer is full
+// messages are dropped, or with the block watch policy writers wait for the consumer.
+// Wildcard topics are not supported. Messages delivered to multiple watchers share the
+// payload, so consumers must not modify it.
+func (a *adapter) Watch(ctx context.Context, contract uint32, topic []byte) (<-chan dbadapter.Message, error) {
+	if err := checkContract(contract); err != nil {
+		return nil, err
+	}
+	if err := checkTopic(topic); err != nil {
+		return nil, err
+	}
+	name := topicName(topic)
+	if bytes.Contains(name, []byte("*")) || bytes.Contains(name, []byte("...")) {
+		return nil, errors.New("unitdb adapter watch does not support wildcard topics")
+	}
+	if err := a.rlock(); err != nil {
+		return nil, err
+	}
+	defer a.mu.RUnlock()
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watcher{
+		contract: contract,
+		topic:    string(name),
+		ch:       make(chan dbadapter.Message, a.config.WatchBuffer),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	a.watchers.add(w)
+	go func() {
+		<-ctx.Done()
+		a.watchers.remove(w)
+	}()
+	return w.ch, nil
+}
+
+// notifyWatchers delivers the messages written to the topic to its watchers.
+func (a *adapter) notifyWatchers(contract uint32, topic []byte, msgs ...dbadapter.Message) {
+	a.watchers.notify(contract, topic, a.config.WatchPolicy == watchPolicyBlock, msgs...)
+}
+
+// watchMessage returns the message of the envelope delivered to watchers. It carries the
+// payload as written rather than the compressed payload stored, the messageId and payload
+// are copied as callers may reuse them once the write returns.
+func watchMessage(env envelope, payload []byte) dbadapter.Message {
+	m := env.message()
+	m.ID = append([]byte(nil), env.id...)
+	m.Payload = append([]byte(nil), payload...)
+	return m
+}
@@ -1,2 +1,3 @@
 #!/bin/bash
-go generate protoc --proto_path=../proto --go_out=plugins=grpc:../proto ../proto/unitd.proto
\ No newline at end of file
+go generate protoc --proto_path=../proto --go_out=plugins=grpc:../proto ../proto/unitd.proto
+go generate protoc --proto_path=../db/grpcserver --go_out=plugins=grpc:../db/grpcserver ../db/grpcserver/store.proto
@@ -124,7 +124,7 @@ func (s *SubscriptionStore) Put(contract uint32, messageId, topic, payload []byt
 }
 
 func (s *SubscriptionStore) Get(contract uint32, topic []byte) (matches [][]byte, err error) {
-	resp, err := adp.Get(contract^connStoreId, topic)
+	resp, err := adp.Get(contract^connStoreId, topic, maxResults)
 	for _, payload := range resp {
 		if payload == nil {
 			continue
@@ -154,7 +154,7 @@ func (m *MessageStore) Put(contract uint32, topic, payload []byte) error {
 }
 
 func (m *MessageStore) Get(contract uint32, topic []byte) (matches []message.Message, err error) {
-	resp, err := adp.Get(contract, topic)
+	resp, err := adp.Get(contract, topic, maxResults)
 	for _, payload := range resp {
 		msg := message.Message{
 			Topic:   topic,
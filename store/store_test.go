@@ -0,0 +1,56 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	_ "github.com/unit-io/unitd/db/unitdb"
+	"github.com/unit-io/unitd/store"
+	"github.com/unit-io/unitdb"
+)
+
+func TestOpenBaselineStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unitd-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// write messages the way the baseline unitdb adapter did, raw payloads and no version stamp
+	contract := uint32(3376684800)
+	topic := []byte("unit1.baseline")
+	db, err := unitdb.Open(filepath.Join(dir, "unitd"), nil, unitdb.WithMutable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"m1", "m2"} {
+		entry := unitdb.NewEntry(topic, []byte(payload))
+		entry.WithContract(contract)
+		if err := db.PutEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := `{"adapters":{"unitdb":{"dir":"` + dir + `","mem_size":1000000,"log_release_duration":"1m"}}}`
+	assert.NoError(t, store.Open(conf))
+	defer store.Close()
+	msgs, err := store.Message.Get(contract, topic)
+	assert.NoError(t, err)
+	var payloads []string
+	for _, m := range msgs {
+		payloads = append(payloads, string(m.Payload))
+	}
+	assert.ElementsMatch(t, []string{"m1", "m2"}, payloads)
+
+	// the store keeps working after the migration
+	assert.NoError(t, store.Message.Put(contract, topic, []byte("m3")))
+	msgs, err = store.Message.Get(contract, topic)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+}